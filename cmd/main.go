@@ -27,6 +27,7 @@ func main() {
 			logger.Fatal(fmt.Sprintf("Failed to close database: %v", err))
 		}
 	}(kvdb)
-	txn := kvdb.BeginTransaction()
-
+	if _, err := kvdb.BeginTransaction(); err != nil {
+		logger.Fatal(fmt.Sprintf("Failed to begin transaction: %v", err))
+	}
 }
@@ -3,6 +3,8 @@ module FinnKV
 go 1.18
 
 require (
+	github.com/golang/snappy v0.0.4
+	github.com/klauspost/compress v1.16.7
 	github.com/spaolacci/murmur3 v1.1.0
 	github.com/stretchr/testify v1.9.0
 	github.com/tidwall/redcon v1.6.2
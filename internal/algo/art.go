@@ -0,0 +1,512 @@
+package algo
+
+import (
+	"sort"
+	"sync"
+)
+
+// 自适应基数树的节点类型，内部节点按子节点数量在四种大小之间切换
+const (
+	artLeaf uint8 = iota
+	artNode4
+	artNode16
+	artNode48
+	artNode256
+)
+
+type artEntry[V any] struct {
+	key   string
+	value V
+}
+
+// artNode 自适应基数树的节点
+// 叶子节点只有 entry；内部节点的 prefix 是压缩的公共路径，entry 是恰好在该节点结束的键
+// node4/node16 的 keys 是有序的子节点字节，node48 的 keys 是 256 项的索引（保存子节点下标加一），node256 直接按字节索引子节点
+type artNode[V any] struct {
+	entry    *artEntry[V]
+	prefix   []byte
+	keys     []byte
+	children []*artNode[V]
+	kind     uint8
+	count    uint16
+}
+
+// ART 并发安全的自适应基数树，键按字节序排列
+type ART[V any] struct {
+	root   *artNode[V]
+	length int
+	lock   sync.RWMutex
+}
+
+// NewART 创建一个新的自适应基数树
+func NewART[V any]() *ART[V] {
+	return &ART[V]{}
+}
+
+func newARTLeaf[V any](key string, value V) *artNode[V] {
+	return &artNode[V]{kind: artLeaf, entry: &artEntry[V]{key: key, value: value}}
+}
+
+func newARTInner[V any](kind uint8, prefix []byte) *artNode[V] {
+	n := &artNode[V]{kind: kind, prefix: prefix}
+	switch kind {
+	case artNode4:
+		n.keys = make([]byte, 0, 4)
+		n.children = make([]*artNode[V], 0, 4)
+	case artNode16:
+		n.keys = make([]byte, 0, 16)
+		n.children = make([]*artNode[V], 0, 16)
+	case artNode48:
+		n.keys = make([]byte, 256)
+		n.children = make([]*artNode[V], 48)
+	default:
+		n.children = make([]*artNode[V], 256)
+	}
+	return n
+}
+
+// capacity 返回内部节点最多能保存的子节点数量
+func (n *artNode[V]) capacity() int {
+	switch n.kind {
+	case artNode4:
+		return 4
+	case artNode16:
+		return 16
+	case artNode48:
+		return 48
+	default:
+		return 256
+	}
+}
+
+// child 返回字节 c 对应的子节点的位置，不存在时返回 nil
+func (n *artNode[V]) child(c byte) **artNode[V] {
+	switch n.kind {
+	case artNode4, artNode16:
+		i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i] >= c })
+		if i < len(n.keys) && n.keys[i] == c {
+			return &n.children[i]
+		}
+	case artNode48:
+		if idx := n.keys[c]; idx != 0 {
+			return &n.children[idx-1]
+		}
+	case artNode256:
+		if n.children[c] != nil {
+			return &n.children[c]
+		}
+	}
+	return nil
+}
+
+// addChild 添加字节 c 对应的子节点，节点已满时先扩容
+func (n *artNode[V]) addChild(c byte, child *artNode[V]) {
+	if int(n.count) == n.capacity() {
+		n.resize(n.kind + 1)
+	}
+	switch n.kind {
+	case artNode4, artNode16:
+		i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i] >= c })
+		n.keys = insertAt(n.keys, i, c)
+		n.children = insertAt(n.children, i, child)
+	case artNode48:
+		slot := 0
+		for n.children[slot] != nil {
+			slot++
+		}
+		n.children[slot] = child
+		n.keys[c] = byte(slot + 1)
+	default:
+		n.children[c] = child
+	}
+	n.count++
+}
+
+// removeChild 删除字节 c 对应的子节点，子节点较少时缩小节点
+func (n *artNode[V]) removeChild(c byte) {
+	switch n.kind {
+	case artNode4, artNode16:
+		i := sort.Search(len(n.keys), func(i int) bool { return n.keys[i] >= c })
+		n.keys = removeAt(n.keys, i)
+		n.children = removeAt(n.children, i)
+	case artNode48:
+		n.children[n.keys[c]-1] = nil
+		n.keys[c] = 0
+	default:
+		n.children[c] = nil
+	}
+	n.count--
+
+	// 缩小的阈值低于扩容的阈值，避免在边界处反复切换
+	switch {
+	case n.kind == artNode256 && n.count <= 40:
+		n.resize(artNode48)
+	case n.kind == artNode48 && n.count <= 12:
+		n.resize(artNode16)
+	case n.kind == artNode16 && n.count <= 3:
+		n.resize(artNode4)
+	}
+}
+
+// each 按字节顺序遍历子节点，reverse 为 true 时从大到小，fn 返回 false 时停止
+func (n *artNode[V]) each(reverse bool, fn func(c byte, child *artNode[V]) bool) bool {
+	visit := func(i int) bool {
+		switch n.kind {
+		case artNode4, artNode16:
+			return fn(n.keys[i], n.children[i])
+		case artNode48:
+			if idx := n.keys[i]; idx != 0 {
+				return fn(byte(i), n.children[idx-1])
+			}
+		default:
+			if child := n.children[i]; child != nil {
+				return fn(byte(i), child)
+			}
+		}
+		return true
+	}
+	size := len(n.keys)
+	if n.kind == artNode256 {
+		size = 256
+	}
+	if reverse {
+		for i := size - 1; i >= 0; i-- {
+			if !visit(i) {
+				return false
+			}
+		}
+		return true
+	}
+	for i := 0; i < size; i++ {
+		if !visit(i) {
+			return false
+		}
+	}
+	return true
+}
+
+// resize 将内部节点转换为另一种大小，保留前缀、entry 和所有子节点
+func (n *artNode[V]) resize(kind uint8) {
+	resized := newARTInner[V](kind, n.prefix)
+	resized.entry = n.entry
+	n.each(false, func(c byte, child *artNode[V]) bool {
+		resized.addChild(c, child)
+		return true
+	})
+	*n = *resized
+}
+
+// commonPrefix 返回两个字节序列的公共前缀长度
+func commonPrefix(a []byte, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// Get 查找键对应的值
+func (t *ART[V]) Get(key string) (V, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	n, depth := t.root, 0
+	for n != nil {
+		if n.kind == artLeaf {
+			if n.entry.key == key {
+				return n.entry.value, true
+			}
+			break
+		}
+		if commonPrefix(n.prefix, key[depth:]) < len(n.prefix) {
+			break
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			if n.entry != nil {
+				return n.entry.value, true
+			}
+			break
+		}
+		ref := n.child(key[depth])
+		if ref == nil {
+			break
+		}
+		n, depth = *ref, depth+1
+	}
+	return *new(V), false
+}
+
+// Put 插入或更新键值对，返回键是否已经存在
+func (t *ART[V]) Put(key string, value V) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	replaced := t.insert(&t.root, key, 0, value)
+	if !replaced {
+		t.length++
+	}
+	return replaced
+}
+
+// place 将叶子挂到内部节点下，键在 depth 处结束时保存为节点的 entry
+func place[V any](n *artNode[V], leaf *artNode[V], depth int) {
+	if len(leaf.entry.key) == depth {
+		n.entry = leaf.entry
+		return
+	}
+	n.addChild(leaf.entry.key[depth], leaf)
+}
+
+func (t *ART[V]) insert(ref **artNode[V], key string, depth int, value V) bool {
+	for {
+		n := *ref
+		if n == nil {
+			*ref = newARTLeaf(key, value)
+			return false
+		}
+
+		if n.kind == artLeaf {
+			if n.entry.key == key {
+				n.entry.value = value
+				return true
+			}
+			// 两个键在公共前缀之后分叉，用新的内部节点替换叶子
+			p := commonPrefix([]byte(n.entry.key[depth:]), key[depth:])
+			inner := newARTInner[V](artNode4, []byte(key[depth:depth+p]))
+			place(inner, n, depth+p)
+			place(inner, newARTLeaf(key, value), depth+p)
+			*ref = inner
+			return false
+		}
+
+		p := commonPrefix(n.prefix, key[depth:])
+		if p < len(n.prefix) {
+			// 键在节点的前缀中间分叉，拆分前缀
+			inner := newARTInner[V](artNode4, n.prefix[:p:p])
+			c := n.prefix[p]
+			n.prefix = append([]byte(nil), n.prefix[p+1:]...)
+			inner.addChild(c, n)
+			place(inner, newARTLeaf(key, value), depth+p)
+			*ref = inner
+			return false
+		}
+
+		depth += len(n.prefix)
+		if depth == len(key) {
+			if n.entry != nil {
+				n.entry.value = value
+				return true
+			}
+			n.entry = &artEntry[V]{key: key, value: value}
+			return false
+		}
+		next := n.child(key[depth])
+		if next == nil {
+			n.addChild(key[depth], newARTLeaf(key, value))
+			return false
+		}
+		ref, depth = next, depth+1
+	}
+}
+
+// Delete 删除键，返回键是否存在
+func (t *ART[V]) Delete(key string) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if !t.delete(&t.root, key, 0) {
+		return false
+	}
+	t.length--
+	return true
+}
+
+func (t *ART[V]) delete(ref **artNode[V], key string, depth int) bool {
+	n := *ref
+	if n == nil {
+		return false
+	}
+	if n.kind == artLeaf {
+		if n.entry.key != key {
+			return false
+		}
+		*ref = nil
+		return true
+	}
+	if commonPrefix(n.prefix, key[depth:]) < len(n.prefix) {
+		return false
+	}
+
+	depth += len(n.prefix)
+	if depth == len(key) {
+		if n.entry == nil {
+			return false
+		}
+		n.entry = nil
+	} else {
+		c := key[depth]
+		next := n.child(c)
+		if next == nil || !t.delete(next, key, depth+1) {
+			return false
+		}
+		if *next == nil {
+			n.removeChild(c)
+		}
+	}
+	t.collapse(ref)
+	return true
+}
+
+// collapse 删除后收缩节点：没有子节点时退化为叶子，只有一个子节点时与子节点合并
+func (t *ART[V]) collapse(ref **artNode[V]) {
+	n := *ref
+	switch {
+	case n.count == 0 && n.entry == nil:
+		*ref = nil
+	case n.count == 0:
+		*ref = &artNode[V]{kind: artLeaf, entry: n.entry}
+	case n.count == 1 && n.entry == nil:
+		n.each(false, func(c byte, child *artNode[V]) bool {
+			if child.kind != artLeaf {
+				// 叶子保存完整的键，不需要前缀；内部节点的前缀拼接上父节点的前缀和分支字节
+				prefix := make([]byte, 0, len(n.prefix)+1+len(child.prefix))
+				prefix = append(append(append(prefix, n.prefix...), c), child.prefix...)
+				child.prefix = prefix
+			}
+			*ref = child
+			return false
+		})
+	}
+}
+
+// Len 返回键的数量
+func (t *ART[V]) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.length
+}
+
+// Ascend 从第一个不小于 key 的键开始按字节序从小到大遍历，fn 返回 false 时停止
+// 遍历期间持有读锁，fn 中不能修改树
+func (t *ART[V]) Ascend(key string, fn func(key string, value V) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root != nil {
+		t.ascend(t.root, key, 0, true, fn)
+	}
+}
+
+// ascend 中序遍历以 n 为根的子树，bounded 为 true 时表示子树的路径与 key 的前 depth 个字节相同，需要跳过小于 key 的键
+func (t *ART[V]) ascend(n *artNode[V], key string, depth int, bounded bool, fn func(string, V) bool) bool {
+	if n.kind == artLeaf {
+		if bounded && n.entry.key < key {
+			return true
+		}
+		return fn(n.entry.key, n.entry.value)
+	}
+
+	if bounded {
+		rest := key[depth:]
+		p := commonPrefix(n.prefix, rest)
+		switch {
+		case p == len(n.prefix):
+			depth += p
+			// 路径等于 key 时子树中的所有键都不小于 key
+			bounded = depth < len(key)
+		case p == len(rest) || n.prefix[p] > rest[p]:
+			bounded = false
+		default:
+			// 子树中的所有键都小于 key
+			return true
+		}
+	} else {
+		depth += len(n.prefix)
+	}
+
+	if !bounded {
+		if n.entry != nil && !fn(n.entry.key, n.entry.value) {
+			return false
+		}
+		return n.each(false, func(_ byte, child *artNode[V]) bool {
+			return t.ascend(child, key, depth+1, false, fn)
+		})
+	}
+	// 在节点处结束的键是 key 的真前缀，小于 key
+	c := key[depth]
+	return n.each(false, func(b byte, child *artNode[V]) bool {
+		if b < c {
+			return true
+		}
+		return t.ascend(child, key, depth+1, b == c, fn)
+	})
+}
+
+// Descend 从最后一个不大于 key 的键开始按字节序从大到小遍历，fn 返回 false 时停止
+func (t *ART[V]) Descend(key string, fn func(key string, value V) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root != nil {
+		t.descend(t.root, key, 0, true, fn)
+	}
+}
+
+// DescendAll 从最后一个键开始按字节序从大到小遍历，fn 返回 false 时停止
+func (t *ART[V]) DescendAll(fn func(key string, value V) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root != nil {
+		t.descend(t.root, "", 0, false, fn)
+	}
+}
+
+func (t *ART[V]) descend(n *artNode[V], key string, depth int, bounded bool, fn func(string, V) bool) bool {
+	if n.kind == artLeaf {
+		if bounded && n.entry.key > key {
+			return true
+		}
+		return fn(n.entry.key, n.entry.value)
+	}
+
+	if bounded {
+		rest := key[depth:]
+		p := commonPrefix(n.prefix, rest)
+		switch {
+		case p == len(n.prefix):
+			depth += p
+		case p == len(rest) || n.prefix[p] > rest[p]:
+			// 子树中的所有键都大于 key
+			return true
+		default:
+			bounded = false
+		}
+	} else {
+		depth += len(n.prefix)
+	}
+
+	if !bounded {
+		if !n.each(true, func(_ byte, child *artNode[V]) bool {
+			return t.descend(child, key, depth+1, false, fn)
+		}) {
+			return false
+		}
+		return n.entry == nil || fn(n.entry.key, n.entry.value)
+	}
+	if depth < len(key) {
+		c := key[depth]
+		if !n.each(true, func(b byte, child *artNode[V]) bool {
+			if b > c {
+				return true
+			}
+			return t.descend(child, key, depth+1, b == c, fn)
+		}) {
+			return false
+		}
+	}
+	// 路径等于 key 时子节点中的键都大于 key，只有节点自身的键满足条件
+	return n.entry == nil || fn(n.entry.key, n.entry.value)
+}
@@ -1,6 +1,8 @@
 package algo
 
 import (
+	"encoding/binary"
+	"errors"
 	"hash/fnv"
 	"math"
 	"sync"
@@ -8,6 +10,15 @@ import (
 	"github.com/spaolacci/murmur3"
 )
 
+// Filter 布隆过滤器的公共接口
+type Filter interface {
+	Add(data []byte)
+	Contains(data []byte) bool
+	Remove(data []byte)
+	MarshalBinary() ([]byte, error)
+	UnmarshalBinary(data []byte) error
+}
+
 // BloomFilter 计数布隆过滤器结构体
 type BloomFilter struct {
 	m      uint       // 位数组大小
@@ -104,3 +115,42 @@ func (bf *BloomFilter) Remove(data []byte) {
 		}
 	}
 }
+
+// bloomHeaderSize 序列化头部大小: m(8) + k(8)
+const bloomHeaderSize = 8 + 8
+
+// ErrBloomMismatch 序列化数据与过滤器的参数不一致
+var ErrBloomMismatch = errors.New("bloom filter parameters mismatch")
+
+// MarshalBinary 将计数布隆过滤器序列化为字节数组
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	buf := make([]byte, bloomHeaderSize+4*len(bf.counts))
+	binary.BigEndian.PutUint64(buf[0:8], uint64(bf.m))
+	binary.BigEndian.PutUint64(buf[8:16], uint64(bf.k))
+	for i, c := range bf.counts {
+		binary.BigEndian.PutUint32(buf[bloomHeaderSize+4*i:], c)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary 从字节数组恢复计数器，序列化时的参数必须与当前过滤器相同
+func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	if len(data) < bloomHeaderSize {
+		return ErrBloomMismatch
+	}
+	m := uint(binary.BigEndian.Uint64(data[0:8]))
+	k := uint(binary.BigEndian.Uint64(data[8:16]))
+	if m != bf.m || k != bf.k || len(data) != bloomHeaderSize+4*int(m) {
+		return ErrBloomMismatch
+	}
+	for i := range bf.counts {
+		bf.counts[i] = binary.BigEndian.Uint32(data[bloomHeaderSize+4*i:])
+	}
+	return nil
+}
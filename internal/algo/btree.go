@@ -0,0 +1,321 @@
+package algo
+
+import (
+	"sort"
+	"sync"
+)
+
+// BTreeDegree B 树默认的最小度数，每个节点最多保存 2*BTreeDegree-1 个键
+const BTreeDegree = 16
+
+type btreeNode[K comparable, V any] struct {
+	keys     []K
+	values   []V
+	children []*btreeNode[K, V]
+}
+
+func (n *btreeNode[K, V]) leaf() bool {
+	return len(n.children) == 0
+}
+
+// BTree 并发安全的有序 B 树
+type BTree[K comparable, V any] struct {
+	root   *btreeNode[K, V]
+	degree int
+	length int
+	less   LessFunc[K]
+	lock   sync.RWMutex
+}
+
+// NewBTree 创建一个新的 B 树，degree 不大于 1 时使用 BTreeDegree
+func NewBTree[K comparable, V any](degree int, less LessFunc[K]) *BTree[K, V] {
+	if degree <= 1 {
+		degree = BTreeDegree
+	}
+	return &BTree[K, V]{
+		root:   &btreeNode[K, V]{},
+		degree: degree,
+		less:   less,
+	}
+}
+
+// search 返回节点中第一个不小于 key 的键的下标
+func (t *BTree[K, V]) search(n *btreeNode[K, V], key K) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return !t.less(n.keys[i], key)
+	})
+}
+
+// Get 查找键对应的值
+func (t *BTree[K, V]) Get(key K) (V, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	n := t.root
+	for {
+		i := t.search(n, key)
+		if i < len(n.keys) && n.keys[i] == key {
+			return n.values[i], true
+		}
+		if n.leaf() {
+			return *new(V), false
+		}
+		n = n.children[i]
+	}
+}
+
+// Put 插入或更新键值对，返回键是否已经存在
+func (t *BTree[K, V]) Put(key K, value V) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.root.keys) == 2*t.degree-1 {
+		root := &btreeNode[K, V]{children: []*btreeNode[K, V]{t.root}}
+		t.split(root, 0)
+		t.root = root
+	}
+	replaced := t.insert(t.root, key, value)
+	if !replaced {
+		t.length++
+	}
+	return replaced
+}
+
+// split 将已满的第 i 个子节点分裂为两个，中间的键上移到父节点
+func (t *BTree[K, V]) split(parent *btreeNode[K, V], i int) {
+	child := parent.children[i]
+	mid := t.degree - 1
+	right := &btreeNode[K, V]{
+		keys:   append([]K(nil), child.keys[mid+1:]...),
+		values: append([]V(nil), child.values[mid+1:]...),
+	}
+	if !child.leaf() {
+		right.children = append([]*btreeNode[K, V](nil), child.children[mid+1:]...)
+		child.children = child.children[:mid+1]
+	}
+	key, value := child.keys[mid], child.values[mid]
+	child.keys = child.keys[:mid]
+	child.values = child.values[:mid]
+
+	parent.keys = insertAt(parent.keys, i, key)
+	parent.values = insertAt(parent.values, i, value)
+	parent.children = insertAt(parent.children, i+1, right)
+}
+
+// insert 向未满的节点插入键值对，沿途分裂已满的子节点
+func (t *BTree[K, V]) insert(n *btreeNode[K, V], key K, value V) bool {
+	for {
+		i := t.search(n, key)
+		if i < len(n.keys) && n.keys[i] == key {
+			n.values[i] = value
+			return true
+		}
+		if n.leaf() {
+			n.keys = insertAt(n.keys, i, key)
+			n.values = insertAt(n.values, i, value)
+			return false
+		}
+		if len(n.children[i].keys) == 2*t.degree-1 {
+			t.split(n, i)
+			if n.keys[i] == key {
+				n.values[i] = value
+				return true
+			}
+			if t.less(n.keys[i], key) {
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// Delete 删除键，返回键是否存在
+func (t *BTree[K, V]) Delete(key K) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	deleted := t.delete(t.root, key)
+	if len(t.root.keys) == 0 && !t.root.leaf() {
+		t.root = t.root.children[0]
+	}
+	if deleted {
+		t.length--
+	}
+	return deleted
+}
+
+// delete 从以 n 为根的子树中删除键，保证下降到的子节点至少有 degree 个键
+func (t *BTree[K, V]) delete(n *btreeNode[K, V], key K) bool {
+	i := t.search(n, key)
+	if i < len(n.keys) && n.keys[i] == key {
+		if n.leaf() {
+			n.keys = removeAt(n.keys, i)
+			n.values = removeAt(n.values, i)
+			return true
+		}
+		left, right := n.children[i], n.children[i+1]
+		switch {
+		case len(left.keys) >= t.degree:
+			// 用前驱替换后从左子树删除前驱
+			pred := left
+			for !pred.leaf() {
+				pred = pred.children[len(pred.children)-1]
+			}
+			last := len(pred.keys) - 1
+			n.keys[i], n.values[i] = pred.keys[last], pred.values[last]
+			return t.delete(left, n.keys[i])
+		case len(right.keys) >= t.degree:
+			// 用后继替换后从右子树删除后继
+			succ := right
+			for !succ.leaf() {
+				succ = succ.children[0]
+			}
+			n.keys[i], n.values[i] = succ.keys[0], succ.values[0]
+			return t.delete(right, n.keys[i])
+		default:
+			t.merge(n, i)
+			return t.delete(left, key)
+		}
+	}
+	if n.leaf() {
+		return false
+	}
+
+	if len(n.children[i].keys) < t.degree {
+		i = t.fill(n, i)
+	}
+	return t.delete(n.children[i], key)
+}
+
+// fill 保证第 i 个子节点至少有 degree 个键，从兄弟节点借一个键或者与兄弟节点合并，返回要下降的子节点下标
+func (t *BTree[K, V]) fill(n *btreeNode[K, V], i int) int {
+	child := n.children[i]
+	switch {
+	case i > 0 && len(n.children[i-1].keys) >= t.degree:
+		left := n.children[i-1]
+		last := len(left.keys) - 1
+		child.keys = insertAt(child.keys, 0, n.keys[i-1])
+		child.values = insertAt(child.values, 0, n.values[i-1])
+		n.keys[i-1], n.values[i-1] = left.keys[last], left.values[last]
+		left.keys = left.keys[:last]
+		left.values = left.values[:last]
+		if !left.leaf() {
+			child.children = insertAt(child.children, 0, left.children[last+1])
+			left.children = left.children[:last+1]
+		}
+		return i
+	case i < len(n.keys) && len(n.children[i+1].keys) >= t.degree:
+		right := n.children[i+1]
+		child.keys = append(child.keys, n.keys[i])
+		child.values = append(child.values, n.values[i])
+		n.keys[i], n.values[i] = right.keys[0], right.values[0]
+		right.keys = removeAt(right.keys, 0)
+		right.values = removeAt(right.values, 0)
+		if !right.leaf() {
+			child.children = append(child.children, right.children[0])
+			right.children = removeAt(right.children, 0)
+		}
+		return i
+	case i < len(n.keys):
+		t.merge(n, i)
+		return i
+	default:
+		t.merge(n, i-1)
+		return i - 1
+	}
+}
+
+// merge 将第 i 个键和第 i+1 个子节点合并到第 i 个子节点
+func (t *BTree[K, V]) merge(n *btreeNode[K, V], i int) {
+	left, right := n.children[i], n.children[i+1]
+	left.keys = append(append(left.keys, n.keys[i]), right.keys...)
+	left.values = append(append(left.values, n.values[i]), right.values...)
+	left.children = append(left.children, right.children...)
+	n.keys = removeAt(n.keys, i)
+	n.values = removeAt(n.values, i)
+	n.children = removeAt(n.children, i+1)
+}
+
+// Len 返回键的数量
+func (t *BTree[K, V]) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.length
+}
+
+// Ascend 从第一个不小于 key 的键开始按从小到大的顺序遍历，fn 返回 false 时停止
+// 遍历期间持有读锁，fn 中不能修改 B 树
+func (t *BTree[K, V]) Ascend(key K, fn func(key K, value V) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	t.ascend(t.root, key, true, fn)
+}
+
+func (t *BTree[K, V]) ascend(n *btreeNode[K, V], key K, bounded bool, fn func(K, V) bool) bool {
+	start := 0
+	if bounded {
+		start = t.search(n, key)
+	}
+	for j := start; j <= len(n.keys); j++ {
+		if !n.leaf() && !t.ascend(n.children[j], key, bounded && j == start, fn) {
+			return false
+		}
+		if j < len(n.keys) && !fn(n.keys[j], n.values[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Descend 从最后一个不大于 key 的键开始按从大到小的顺序遍历，fn 返回 false 时停止
+func (t *BTree[K, V]) Descend(key K, fn func(key K, value V) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	t.descend(t.root, key, true, fn)
+}
+
+// DescendAll 从最后一个键开始按从大到小的顺序遍历，fn 返回 false 时停止
+func (t *BTree[K, V]) DescendAll(fn func(key K, value V) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	t.descend(t.root, *new(K), false, fn)
+}
+
+func (t *BTree[K, V]) descend(n *btreeNode[K, V], key K, bounded bool, fn func(K, V) bool) bool {
+	end := len(n.keys)
+	if bounded {
+		// 第一个大于 key 的键的下标
+		end = sort.Search(len(n.keys), func(i int) bool {
+			return t.less(key, n.keys[i])
+		})
+	}
+	for j := end; j >= 0; j-- {
+		if !n.leaf() && !t.descend(n.children[j], key, bounded && j == end, fn) {
+			return false
+		}
+		if j > 0 && !fn(n.keys[j-1], n.values[j-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// insertAt 在下标 i 处插入元素
+func insertAt[T any](s []T, i int, v T) []T {
+	s = append(s, v)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+// removeAt 删除下标 i 处的元素
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
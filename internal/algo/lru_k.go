@@ -2,13 +2,19 @@ package algo
 
 import (
 	"container/heap"
+	"container/list"
+	"sync"
+	"time"
 )
 
 type CacheItem struct {
-	Key      string
-	Value    interface{}
-	Accesses []int64
-	index    int
+	Key       string
+	Value     interface{}
+	Size      int64
+	ExpiresAt int64 // 过期时间（UnixNano），0 表示永不过期
+	Accesses  []int64
+	index     int
+	elem      *list.Element // 在历史队列中的位置，访问达到 K 次后为 nil
 }
 
 func (item *CacheItem) Priority(k int) int64 {
@@ -18,6 +24,12 @@ func (item *CacheItem) Priority(k int) int64 {
 	return item.Accesses[0]
 }
 
+// expired 返回条目在 now 时刻是否已经过期
+func (item *CacheItem) expired(now int64) bool {
+	return item.ExpiresAt != 0 && item.ExpiresAt <= now
+}
+
+// PriorityQueue 访问达到 K 次的条目，按倒数第 K 次访问的时间排序
 type PriorityQueue struct {
 	items []*CacheItem
 	k     int
@@ -26,17 +38,7 @@ type PriorityQueue struct {
 func (pq PriorityQueue) Len() int { return len(pq.items) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
-	priorityI := pq.items[i].Priority(pq.k)
-	priorityJ := pq.items[j].Priority(pq.k)
-	if priorityI == -1 && priorityJ == -1 {
-		return pq.items[i].Accesses[len(pq.items[i].Accesses)-1] < pq.items[j].Accesses[len(pq.items[j].Accesses)-1]
-	} else if priorityI == -1 {
-		return true
-	} else if priorityJ == -1 {
-		return false
-	} else {
-		return priorityI < priorityJ
-	}
+	return pq.items[i].Priority(pq.k) < pq.items[j].Priority(pq.k)
 }
 
 func (pq PriorityQueue) Swap(i, j int) {
@@ -65,61 +67,272 @@ func (pq *PriorityQueue) update(item *CacheItem) {
 	heap.Fix(pq, item.index)
 }
 
+// EvictCallback 条目因容量不足或过期被淘汰时的回调，主动删除或覆盖的条目不会触发
+type EvictCallback func(key string, value interface{})
+
+// LRUKOption LRU-K 缓存的配置项
+type LRUKOption func(*LRUKCache)
+
+// WithCacheMaxSize 限制条目的总字节数，条目的大小由 SetWithSize 指定
+func WithCacheMaxSize(maxSize int64) LRUKOption {
+	return func(c *LRUKCache) {
+		c.maxSize = maxSize
+	}
+}
+
+// WithHistorySize 限制访问不足 K 次的条目数，超出时淘汰其中最久没有访问的条目
+func WithHistorySize(size int) LRUKOption {
+	return func(c *LRUKCache) {
+		c.historySize = size
+	}
+}
+
+// WithEvictCallback 设置条目被淘汰时的回调，回调在释放锁之后执行
+func WithEvictCallback(fn EvictCallback) LRUKOption {
+	return func(c *LRUKCache) {
+		c.onEvict = fn
+	}
+}
+
+// LRUKCache LRU-K 缓存，并发安全
+// 访问不足 K 次的条目保存在按最近访问排序的历史队列中，淘汰时优先淘汰；
+// 访问达到 K 次的条目进入优先队列，按倒数第 K 次访问的时间淘汰
+// 可以限制条目数、条目的总字节数以及历史队列的长度
 type LRUKCache struct {
-	capacity    int
+	capacity    int   // 最大条目数，0 表示不限制
+	maxSize     int64 // 条目的最大总字节数，0 表示不限制
+	historySize int   // 历史队列的最大长度，0 表示不单独限制
+	size        int64
 	k           int
 	items       map[string]*CacheItem
 	accessCount int64
+	history     *list.List // 访问不足 K 次的条目，队首是最近访问的条目
 	pq          *PriorityQueue
+	onEvict     EvictCallback
+	lock        sync.Mutex
 }
 
-func NewLRUKCache(capacity int, k int) *LRUKCache {
+// NewLRUKCache 创建一个最多保存 capacity 个条目的 LRU-K 缓存，capacity 为 0 表示不限制条目数
+func NewLRUKCache(capacity int, k int, opts ...LRUKOption) *LRUKCache {
+	if k <= 0 {
+		k = 1
+	}
 	pq := &PriorityQueue{
 		items: []*CacheItem{},
 		k:     k,
 	}
 	heap.Init(pq)
-	return &LRUKCache{
+	c := &LRUKCache{
 		capacity: capacity,
 		k:        k,
 		items:    make(map[string]*CacheItem),
+		history:  list.New(),
 		pq:       pq,
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
+// NewSizedLRUKCache 创建一个按字节数限制容量的 LRU-K 缓存，条目的大小由 SetWithSize 指定
+func NewSizedLRUKCache(maxSize int64, k int, opts ...LRUKOption) *LRUKCache {
+	return NewLRUKCache(0, k, append([]LRUKOption{WithCacheMaxSize(maxSize)}, opts...)...)
+}
+
+// Get 获取缓存的值，并记录一次访问
 func (c *LRUKCache) Get(key string) (interface{}, bool) {
+	var evicted []*CacheItem
+	defer func() { c.notify(evicted) }()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	c.accessCount++
-	if item, ok := c.items[key]; ok {
-		item.Accesses = append(item.Accesses, c.accessCount)
-		if len(item.Accesses) > c.k {
-			item.Accesses = item.Accesses[1:]
-		}
-		c.pq.update(item)
-		return item.Value, true
+	item, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if item.expired(time.Now().UnixNano()) {
+		c.remove(item)
+		evicted = append(evicted, item)
+		return nil, false
+	}
+	c.touch(item)
+	return item.Value, true
+}
+
+// Peek 获取缓存的值，不记录访问
+func (c *LRUKCache) Peek(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	item, ok := c.items[key]
+	if !ok || item.expired(time.Now().UnixNano()) {
+		return nil, false
 	}
-	return nil, false
+	return item.Value, true
 }
 
+// Set 写入缓存的值，大小计为 0
 func (c *LRUKCache) Set(key string, value interface{}) {
+	c.set(key, value, 0, 0)
+}
+
+// SetWithSize 写入缓存的值并指定其字节数，超出容量时先淘汰其他条目
+// 大小超过整个缓存容量的值不会被缓存
+func (c *LRUKCache) SetWithSize(key string, value interface{}, size int64) {
+	c.set(key, value, size, 0)
+}
+
+// SetWithTTL 写入缓存的值并指定其字节数，条目在 ttl 之后过期，ttl 不大于 0 表示永不过期
+func (c *LRUKCache) SetWithTTL(key string, value interface{}, size int64, ttl time.Duration) {
+	var expiresAt int64
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl).UnixNano()
+	}
+	c.set(key, value, size, expiresAt)
+}
+
+// set 写入缓存的值，已经存在的条目保留其访问历史
+func (c *LRUKCache) set(key string, value interface{}, size int64, expiresAt int64) {
+	var evicted []*CacheItem
+	defer func() { c.notify(evicted) }()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	c.accessCount++
+	var accesses []int64
 	if item, ok := c.items[key]; ok {
-		item.Value = value
-		item.Accesses = append(item.Accesses, c.accessCount)
-		if len(item.Accesses) > c.k {
-			item.Accesses = item.Accesses[1:]
-		}
+		accesses = item.Accesses
+		c.remove(item)
+	}
+	accesses = c.record(accesses)
+	if c.maxSize > 0 && size > c.maxSize {
+		return
+	}
+
+	for len(c.items) > 0 && ((c.capacity > 0 && len(c.items) >= c.capacity) || (c.maxSize > 0 && c.size+size > c.maxSize)) {
+		evicted = append(evicted, c.evict())
+	}
+	c.insert(&CacheItem{
+		Key:       key,
+		Value:     value,
+		Size:      size,
+		ExpiresAt: expiresAt,
+		Accesses:  accesses,
+	})
+	for c.historySize > 0 && c.history.Len() > c.historySize {
+		evicted = append(evicted, c.evict())
+	}
+}
+
+// Delete 删除缓存的值，返回条目是否存在
+func (c *LRUKCache) Delete(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	item, ok := c.items[key]
+	if ok {
+		c.remove(item)
+	}
+	return ok
+}
+
+// Purge 清空缓存
+func (c *LRUKCache) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.items = make(map[string]*CacheItem)
+	c.history.Init()
+	c.pq.items = c.pq.items[:0]
+	c.size = 0
+}
+
+// Len 返回缓存的条目数，包括已经过期但还没有被清理的条目
+func (c *LRUKCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.items)
+}
+
+// Size 返回缓存条目的总字节数
+func (c *LRUKCache) Size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.size
+}
+
+// record 记录一次访问，只保留最近 K 次访问的时间，调用方需持有锁
+func (c *LRUKCache) record(accesses []int64) []int64 {
+	accesses = append(accesses, c.accessCount)
+	if len(accesses) > c.k {
+		accesses = accesses[1:]
+	}
+	return accesses
+}
+
+// touch 记录对已有条目的一次访问，访问达到 K 次的条目从历史队列移入优先队列，调用方需持有锁
+func (c *LRUKCache) touch(item *CacheItem) {
+	item.Accesses = c.record(item.Accesses)
+	if item.elem == nil {
 		c.pq.update(item)
-	} else {
-		if len(c.items) >= c.capacity {
-			evictedItem := heap.Pop(c.pq).(*CacheItem)
-			delete(c.items, evictedItem.Key)
-		}
-		item := &CacheItem{
-			Key:      key,
-			Value:    value,
-			Accesses: []int64{c.accessCount},
-		}
-		c.items[key] = item
+		return
+	}
+	if len(item.Accesses) >= c.k {
+		c.history.Remove(item.elem)
+		item.elem = nil
+		heap.Push(c.pq, item)
+		return
+	}
+	c.history.MoveToFront(item.elem)
+}
+
+// insert 将新条目加入历史队列或优先队列，调用方需持有锁
+func (c *LRUKCache) insert(item *CacheItem) {
+	c.items[item.Key] = item
+	c.size += item.Size
+	if len(item.Accesses) >= c.k {
 		heap.Push(c.pq, item)
+	} else {
+		item.elem = c.history.PushFront(item)
+	}
+}
+
+// evict 淘汰一个条目并返回，优先淘汰历史队列中最久没有访问的条目，调用方需持有锁且缓存不为空
+func (c *LRUKCache) evict() *CacheItem {
+	var item *CacheItem
+	if back := c.history.Back(); back != nil {
+		item = back.Value.(*CacheItem)
+	} else {
+		item = c.pq.items[0]
+	}
+	c.remove(item)
+	return item
+}
+
+// remove 从缓存中删除条目，调用方需持有锁
+func (c *LRUKCache) remove(item *CacheItem) {
+	if item.elem != nil {
+		c.history.Remove(item.elem)
+		item.elem = nil
+	} else {
+		heap.Remove(c.pq, item.index)
+	}
+	delete(c.items, item.Key)
+	c.size -= item.Size
+}
+
+// notify 在释放锁之后对被淘汰的条目执行回调
+func (c *LRUKCache) notify(evicted []*CacheItem) {
+	if c.onEvict == nil {
+		return
+	}
+	for _, item := range evicted {
+		c.onEvict(item.Key, item.Value)
 	}
 }
@@ -0,0 +1,157 @@
+package algo
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	scalableGrowth     = 2   // 每一层的容量是上一层的倍数
+	scalableTightening = 0.5 // 每一层的误判率是上一层的倍数
+)
+
+// bloomLayer 可扩展布隆过滤器中的一层
+type bloomLayer struct {
+	filter   *BloomFilter
+	capacity uint // 该层的设计容量
+	count    uint // 该层已经添加的元素数量
+}
+
+// ScalableBloomFilter 可扩展的计数布隆过滤器
+// 元素数量超过当前层的容量时添加一个容量更大、误判率更低的新层，总的误判率不超过初始误判率的两倍
+type ScalableBloomFilter struct {
+	n      uint // 第一层的容量
+	p      float64
+	layers []*bloomLayer
+	lock   sync.Mutex
+}
+
+// NewScalableBloomFilter 创建一个新的可扩展计数布隆过滤器，n 为第一层的容量，p 为第一层的误判率
+func NewScalableBloomFilter(n uint, p float64) *ScalableBloomFilter {
+	sbf := &ScalableBloomFilter{n: n, p: p}
+	sbf.addLayer()
+	return sbf
+}
+
+// addLayer 添加一个新层，调用方需持有锁
+func (sbf *ScalableBloomFilter) addLayer() {
+	capacity := sbf.n
+	p := sbf.p * scalableTightening
+	for range sbf.layers {
+		capacity *= scalableGrowth
+		p *= scalableTightening
+	}
+	sbf.layers = append(sbf.layers, &bloomLayer{
+		filter:   NewBloomFilter(capacity, p),
+		capacity: capacity,
+	})
+}
+
+// Add 向过滤器中添加元素，元素写入最新的一层
+// 已经可能存在的元素不再重复添加，层的计数只随不同元素的数量增长，反复覆盖同一个键不会添加新层
+func (sbf *ScalableBloomFilter) Add(data []byte) {
+	sbf.lock.Lock()
+	defer sbf.lock.Unlock()
+
+	if sbf.contains(data) {
+		return
+	}
+	last := sbf.layers[len(sbf.layers)-1]
+	if last.count >= last.capacity {
+		sbf.addLayer()
+		last = sbf.layers[len(sbf.layers)-1]
+	}
+	last.filter.Add(data)
+	last.count++
+}
+
+// Contains 检查元素是否可能存在于任意一层中
+func (sbf *ScalableBloomFilter) Contains(data []byte) bool {
+	sbf.lock.Lock()
+	defer sbf.lock.Unlock()
+
+	return sbf.contains(data)
+}
+
+// contains 检查元素是否可能存在于任意一层中，调用方需持有锁
+func (sbf *ScalableBloomFilter) contains(data []byte) bool {
+	for _, layer := range sbf.layers {
+		if layer.filter.Contains(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove 可扩展过滤器不支持删除，调用不会产生任何效果
+// 无法确定元素实际写入了哪一层，从误判的层中删除会减少其他元素的计数，导致漏判；被删除的元素之后只会成为误判
+func (sbf *ScalableBloomFilter) Remove(data []byte) {}
+
+// Layers 返回当前的层数
+func (sbf *ScalableBloomFilter) Layers() int {
+	sbf.lock.Lock()
+	defer sbf.lock.Unlock()
+
+	return len(sbf.layers)
+}
+
+// MarshalBinary 将过滤器序列化为字节数组
+// 格式: layerCount(4)，之后每一层依次为 count(8) + size(4) + 该层计数布隆过滤器的序列化数据
+func (sbf *ScalableBloomFilter) MarshalBinary() ([]byte, error) {
+	sbf.lock.Lock()
+	defer sbf.lock.Unlock()
+
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(len(sbf.layers)))
+	for _, layer := range sbf.layers {
+		data, err := layer.filter.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		header := make([]byte, 12)
+		binary.BigEndian.PutUint64(header[0:8], uint64(layer.count))
+		binary.BigEndian.PutUint32(header[8:12], uint32(len(data)))
+		buf = append(buf, header...)
+		buf = append(buf, data...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary 从字节数组恢复过滤器，第一层的参数必须与当前过滤器相同
+func (sbf *ScalableBloomFilter) UnmarshalBinary(data []byte) error {
+	sbf.lock.Lock()
+	defer sbf.lock.Unlock()
+
+	if len(data) < 4 {
+		return ErrBloomMismatch
+	}
+	layerCount := int(binary.BigEndian.Uint32(data[0:4]))
+	if layerCount == 0 {
+		return ErrBloomMismatch
+	}
+	data = data[4:]
+
+	fresh := &ScalableBloomFilter{n: sbf.n, p: sbf.p}
+	for i := 0; i < layerCount; i++ {
+		if len(data) < 12 {
+			return ErrBloomMismatch
+		}
+		count := uint(binary.BigEndian.Uint64(data[0:8]))
+		size := int(binary.BigEndian.Uint32(data[8:12]))
+		if len(data) < 12+size {
+			return ErrBloomMismatch
+		}
+		fresh.addLayer()
+		layer := fresh.layers[i]
+		if err := layer.filter.UnmarshalBinary(data[12 : 12+size]); err != nil {
+			return err
+		}
+		layer.count = count
+		data = data[12+size:]
+	}
+	if len(data) != 0 {
+		return ErrBloomMismatch
+	}
+	sbf.layers = fresh.layers
+	return nil
+}
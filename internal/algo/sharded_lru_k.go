@@ -0,0 +1,98 @@
+package algo
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// DefaultCacheShards 分片 LRU-K 缓存默认的分片数
+const DefaultCacheShards = 16
+
+// ShardedLRUKCache 按键的哈希分片的 LRU-K 缓存，不同分片的访问互不阻塞
+// 容量限制平均分配到每个分片，每个分片独立淘汰
+type ShardedLRUKCache struct {
+	shards []*LRUKCache
+}
+
+// NewShardedLRUKCache 创建分片 LRU-K 缓存，capacity 和配置项中的容量限制为所有分片的总和
+func NewShardedLRUKCache(shards, capacity, k int, opts ...LRUKOption) *ShardedLRUKCache {
+	if shards <= 0 {
+		shards = DefaultCacheShards
+	}
+	sc := &ShardedLRUKCache{shards: make([]*LRUKCache, shards)}
+	for i := range sc.shards {
+		shard := NewLRUKCache(0, k, opts...)
+		shard.capacity = divideCeil(capacity, shards)
+		shard.historySize = divideCeil(shard.historySize, shards)
+		shard.maxSize = (shard.maxSize + int64(shards) - 1) / int64(shards)
+		sc.shards[i] = shard
+	}
+	return sc
+}
+
+// divideCeil 向上取整的除法，保证 0 仍然表示不限制
+func divideCeil(n, shards int) int {
+	return (n + shards - 1) / shards
+}
+
+// shard 返回键所在的分片
+func (sc *ShardedLRUKCache) shard(key string) *LRUKCache {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return sc.shards[h.Sum32()%uint32(len(sc.shards))]
+}
+
+// Get 获取缓存的值，并记录一次访问
+func (sc *ShardedLRUKCache) Get(key string) (interface{}, bool) {
+	return sc.shard(key).Get(key)
+}
+
+// Peek 获取缓存的值，不记录访问
+func (sc *ShardedLRUKCache) Peek(key string) (interface{}, bool) {
+	return sc.shard(key).Peek(key)
+}
+
+// Set 写入缓存的值，大小计为 0
+func (sc *ShardedLRUKCache) Set(key string, value interface{}) {
+	sc.shard(key).Set(key, value)
+}
+
+// SetWithSize 写入缓存的值并指定其字节数
+func (sc *ShardedLRUKCache) SetWithSize(key string, value interface{}, size int64) {
+	sc.shard(key).SetWithSize(key, value, size)
+}
+
+// SetWithTTL 写入缓存的值并指定其字节数，条目在 ttl 之后过期
+func (sc *ShardedLRUKCache) SetWithTTL(key string, value interface{}, size int64, ttl time.Duration) {
+	sc.shard(key).SetWithTTL(key, value, size, ttl)
+}
+
+// Delete 删除缓存的值，返回条目是否存在
+func (sc *ShardedLRUKCache) Delete(key string) bool {
+	return sc.shard(key).Delete(key)
+}
+
+// Purge 清空所有分片
+func (sc *ShardedLRUKCache) Purge() {
+	for _, shard := range sc.shards {
+		shard.Purge()
+	}
+}
+
+// Len 返回所有分片的条目数之和
+func (sc *ShardedLRUKCache) Len() int {
+	n := 0
+	for _, shard := range sc.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Size 返回所有分片的条目总字节数
+func (sc *ShardedLRUKCache) Size() int64 {
+	var size int64
+	for _, shard := range sc.shards {
+		size += shard.Size()
+	}
+	return size
+}
@@ -163,3 +163,92 @@ func (s *SkipList[K, V]) Iterator() func() (K, V, bool) {
 		return key, value, true
 	}
 }
+
+// findGreaterOrEqual 返回第一个键不小于 key 的节点，调用方需持有读锁
+func (s *SkipList[K, V]) findGreaterOrEqual(key K) *Node[K, V] {
+	curr := s.head
+	for i := s.level; i >= 0; i-- {
+		for curr.forward[i] != s.tail && s.less(curr.forward[i].key, key) {
+			curr = curr.forward[i]
+		}
+	}
+	return curr.forward[0]
+}
+
+// findLess 返回最后一个键小于 key 的节点（inclusive 为 true 时为小于等于），不存在时返回 head，调用方需持有读锁
+func (s *SkipList[K, V]) findLess(key K, inclusive bool) *Node[K, V] {
+	curr := s.head
+	for i := s.level; i >= 0; i-- {
+		for curr.forward[i] != s.tail {
+			next := curr.forward[i]
+			if s.less(next.key, key) || (inclusive && next.key == key) {
+				curr = next
+				continue
+			}
+			break
+		}
+	}
+	return curr
+}
+
+// findLast 返回最后一个节点，跳表为空时返回 head，调用方需持有读锁
+func (s *SkipList[K, V]) findLast() *Node[K, V] {
+	curr := s.head
+	for i := s.level; i >= 0; i-- {
+		for curr.forward[i] != s.tail {
+			curr = curr.forward[i]
+		}
+	}
+	return curr
+}
+
+// Seek 返回从第一个不小于 key 的键开始的正向迭代器
+func (s *SkipList[K, V]) Seek(key K) func() (K, V, bool) {
+	s.lock.RLock()
+	curr := s.findGreaterOrEqual(key)
+	s.lock.RUnlock()
+
+	return func() (K, V, bool) {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+
+		if curr == s.tail {
+			return *new(K), *new(V), false
+		}
+		key, value := curr.key, curr.value
+		curr = curr.forward[0]
+		return key, value, true
+	}
+}
+
+// ReverseIterator 返回从最后一个键开始的反向迭代器
+func (s *SkipList[K, V]) ReverseIterator() func() (K, V, bool) {
+	s.lock.RLock()
+	curr := s.findLast()
+	s.lock.RUnlock()
+	return s.reverseFrom(curr)
+}
+
+// SeekReverse 返回从最后一个不大于 key 的键开始的反向迭代器
+func (s *SkipList[K, V]) SeekReverse(key K) func() (K, V, bool) {
+	s.lock.RLock()
+	curr := s.findLess(key, true)
+	s.lock.RUnlock()
+	return s.reverseFrom(curr)
+}
+
+// reverseFrom 从指定节点开始反向遍历
+// 节点只有前向指针，每一步都重新查找前驱节点，因此迭代过程中的并发修改是安全的
+func (s *SkipList[K, V]) reverseFrom(curr *Node[K, V]) func() (K, V, bool) {
+	return func() (K, V, bool) {
+		s.lock.RLock()
+		defer s.lock.RUnlock()
+
+		if curr == s.head {
+			return *new(K), *new(V), false
+		}
+		key, value := curr.key, curr.value
+		curr = s.findLess(key, false)
+		return key, value, true
+	}
+}
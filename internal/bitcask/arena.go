@@ -0,0 +1,76 @@
+package bitcask
+
+const (
+	arenaMinBlockSize = 4 << 10 // 键分配区第一个内存块的大小
+	arenaBlockSize    = 1 << 20 // 键分配区内存块的最大大小
+)
+
+// keyRef 键在分配区中的位置
+type keyRef struct {
+	block  uint32
+	offset uint32
+	length uint32
+}
+
+// keyArena 将键连续地保存在大块内存中，避免每个键一次堆分配以及字符串头部的开销
+// 删除的键只记为垃圾，由索引在垃圾过多时整体重建
+type keyArena struct {
+	blocks  [][]byte
+	used    int64 // 仍然有效的键的字节数
+	garbage int64 // 已经删除的键的字节数
+}
+
+func newKeyArena() *keyArena {
+	return &keyArena{}
+}
+
+// alloc 复制键并返回其位置
+func (a *keyArena) alloc(key string) keyRef {
+	n := len(key)
+	last := len(a.blocks) - 1
+	if last < 0 || cap(a.blocks[last])-len(a.blocks[last]) < n {
+		// 内存块从小到大增长，键很少时不会浪费一整个块
+		size := int(a.size())
+		if size < arenaMinBlockSize {
+			size = arenaMinBlockSize
+		}
+		if size > arenaBlockSize {
+			size = arenaBlockSize
+		}
+		if n > size {
+			size = n
+		}
+		a.blocks = append(a.blocks, make([]byte, 0, size))
+		last++
+	}
+	block := a.blocks[last]
+	ref := keyRef{block: uint32(last), offset: uint32(len(block)), length: uint32(n)}
+	a.blocks[last] = append(block, key...)
+	a.used += int64(n)
+	return ref
+}
+
+// bytes 返回键的内容，返回的切片引用分配区的内存，不能修改
+func (a *keyArena) bytes(ref keyRef) []byte {
+	return a.blocks[ref.block][ref.offset : ref.offset+ref.length]
+}
+
+// free 将键记为垃圾
+func (a *keyArena) free(ref keyRef) {
+	a.used -= int64(ref.length)
+	a.garbage += int64(ref.length)
+}
+
+// needsCompaction 返回垃圾是否多到需要重建分配区
+func (a *keyArena) needsCompaction() bool {
+	return a.garbage > arenaBlockSize && a.garbage > a.used
+}
+
+// size 返回分配区占用的内存
+func (a *keyArena) size() int64 {
+	var size int64
+	for _, block := range a.blocks {
+		size += int64(cap(block))
+	}
+	return size
+}
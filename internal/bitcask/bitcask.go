@@ -1,27 +1,47 @@
 package bitcask
 
 import (
-	"encoding/binary"
 	"errors"
-	"hash/crc32"
+	"io"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"FinnKV/internal/algo"
 )
 
+// NoTTL 表示键永不过期
+const NoTTL time.Duration = -1
+
 type Bitcask struct {
 	sync.RWMutex
 	dir       string
 	options   *Options
 	dataFiles *algo.SkipList[int64, *DataFile]
 	currFile  *DataFile
-	index     *algo.SkipList[string, *EntryMetadata]
+	index     Indexer
 	maxFileID int64
 	writable  bool
+	hints     []*hintRecord // 当前活跃文件的 hint 记录，文件封存时写入 hint 文件
+	report    *RecoveryReport
+	mergeLock sync.Mutex // 保证同一时间只有一个合并在进行
+	stopMerge chan struct{}
+	mergeDone chan struct{}
+	stopScrub chan struct{}
+	scrubDone chan struct{}
+	closed    int32
+	flock     *dirLock    // 读写模式下持有的数据目录锁
+	cache     *valueCache // 热点键的值缓存，没有启用时为 nil
+	disk      *diskKeydir // 磁盘索引，没有启用时为 nil
+
+	scrubMu    sync.Mutex // 保护 scrubStats
+	scrubStats ScrubStats
+
+	appliedLSN   uint64 // 已经应用到存储中的最大日志序列号
+	persistedLSN uint64 // 已经持久化的日志序列号
 }
 
 // Open 打开或创建一个 Bitcask 实例
@@ -30,6 +50,12 @@ func Open(dir string, opts ...Option) (*Bitcask, error) {
 	for _, opt := range opts {
 		opt(options)
 	}
+	if (options.IndexMode == IndexCompact || options.IndexMode == IndexHash) && options.MaxFileSize > maxCompactFileSize {
+		return nil, ErrInvalidOptions
+	}
+	if options.FormatVersion == 0 || options.FormatVersion > DataFileVersion {
+		return nil, ErrInvalidOptions
+	}
 
 	err := os.MkdirAll(dir, 0755)
 	if err != nil {
@@ -40,106 +66,368 @@ func Open(dir string, opts ...Option) (*Bitcask, error) {
 		dir:       dir,
 		options:   options,
 		dataFiles: algo.NewSkipList[int64, *DataFile](func(a, b int64) bool { return a < b }),
-		index:     algo.NewSkipList[string, *EntryMetadata](func(a, b string) bool { return a < b }),
+		index:     NewIndexer(options.IndexMode),
 		writable:  options.ReadWrite,
+		report:    &RecoveryReport{},
+		cache:     newValueCache(options.CacheSize, options.CacheK),
+	}
+	bc.appliedLSN = readAppliedLSN(dir)
+	bc.persistedLSN = bc.appliedLSN
+	// 只读模式不加锁，可以与一个写进程同时打开
+	if options.ReadWrite {
+		bc.flock, err = lockDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		if err := bc.recoverMerge(); err != nil {
+			_ = bc.flock.unlock()
+			return nil, err
+		}
+	}
+	// 磁盘索引在合并恢复之后打开，检查点之后完成的合并会使检查点失效
+	if options.IndexMode == IndexDisk {
+		bc.disk, err = openDiskKeydir(dir, options.ReadWrite, options.IndexCacheSize)
+		if err != nil {
+			if bc.flock != nil {
+				_ = bc.flock.unlock()
+			}
+			return nil, err
+		}
+		bc.index = bc.disk
 	}
 	err = bc.loadDataFiles()
 	if err != nil {
+		_ = bc.disk.close()
+		if bc.flock != nil {
+			_ = bc.flock.unlock()
+		}
 		return nil, err
 	}
+	if options.ReadWrite && options.MergePolicy != nil {
+		bc.startAutoMerge(*options.MergePolicy)
+	}
+	if options.ScrubPolicy != nil {
+		bc.startScrubber(*options.ScrubPolicy)
+	}
 	return bc, nil
 }
 
 // loadDataFiles 加载数据文件并重建内存索引
+// 磁盘索引从干净的检查点恢复时，只重放检查点之后写入的记录
 func (bc *Bitcask) loadDataFiles() error {
 	files, err := os.ReadDir(bc.dir)
 	if err != nil {
 		return err
 	}
 
+	var fileIDs []int64
 	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), ".data") {
+		if !strings.HasSuffix(file.Name(), dataFileSuffix) {
 			continue
 		}
-		fileID, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), ".data"), 10, 64)
+		fileID, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), dataFileSuffix), 10, 64)
 		if err != nil {
 			continue
 		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	cp, err := bc.disk.resume(fileIDs)
+	if err != nil {
+		return err
+	}
+
+	for i, fileID := range fileIDs {
 		df, err := NewDataFile(bc.dir, fileID, false)
 		if err != nil {
 			return err
 		}
-		bc.dataFiles.Add(fileID, df)
 		if fileID > bc.maxFileID {
 			bc.maxFileID = fileID
 		}
-		err = bc.buildIndex(df)
+		bc.dataFiles.Add(fileID, df)
+		from := df.dataStart
+		if cp != nil && fileID <= cp.fileID {
+			df.addDeadBytes(cp.files[fileID])
+			if fileID < cp.fileID {
+				if err := bc.mapFile(df); err != nil {
+					return err
+				}
+				continue
+			}
+			from = cp.offset
+		}
+		// 最新的数据文件是上次运行时的活跃文件，崩溃时可能留下不完整的尾部记录
+		loaded, err := bc.loadIndex(df, i == len(fileIDs)-1, from)
 		if err != nil {
 			return err
 		}
+		if !loaded {
+			bc.dataFiles.Del(fileID)
+			continue
+		}
+		if err := bc.mapFile(df); err != nil {
+			return err
+		}
+	}
+	if err := bc.disk.failed(); err != nil {
+		return err
 	}
+	bc.findResurrected()
 	if bc.options.ReadWrite {
-		bc.maxFileID++
-		currFile, err := NewDataFile(bc.dir, bc.maxFileID, true)
+		if err := bc.openActiveFile(); err != nil {
+			return err
+		}
+	}
+	return bc.checkpointIndex()
+}
+
+// openActiveFile 读写模式下打开活跃文件，最新的数据文件可以继续写入时重新以读写方式打开它，否则创建新文件
+func (bc *Bitcask) openActiveFile() error {
+	if last, ok := bc.dataFiles.Find(bc.maxFileID); ok && bc.resumable(last) {
+		df, err := newDataFile(bc.dir, last.FileID, true, last.Version)
 		if err != nil {
 			return err
 		}
-		bc.currFile = currFile
-		bc.dataFiles.Add(bc.maxFileID, currFile)
+		df.setCompression(bc.options.Compression, bc.options.CompressMinSize)
+		df.addDeadBytes(last.DeadBytes())
+		if err := last.Close(); err != nil {
+			_ = df.Close()
+			return err
+		}
+		bc.currFile = df
+		bc.dataFiles.Add(df.FileID, df)
+		return nil
+	}
+
+	bc.maxFileID++
+	currFile, err := bc.newActiveFile(bc.maxFileID)
+	if err != nil {
+		return err
 	}
+	bc.currFile = currFile
+	bc.dataFiles.Add(bc.maxFileID, currFile)
 	return nil
 }
 
-// buildIndex 从数据文件构建内存索引
-func (bc *Bitcask) buildIndex(df *DataFile) error {
-	var offset int64 = 0
+// resumable 返回读写模式下能否继续写入上次运行时的活跃文件：格式版本与配置一致，且没有达到大小上限
+func (bc *Bitcask) resumable(df *DataFile) bool {
+	return bc.options.ReadWrite && df.Version == bc.options.FormatVersion && df.WriteOff < bc.options.MaxFileSize
+}
+
+// loadIndex 优先从 hint 文件加载索引，hint 文件缺失或损坏时回退到扫描数据文件
+// 扫描时遇到损坏的记录会按照 RecoveryMode 处理，返回值表示数据文件是否被加载
+// 只有偏移量不小于 from 的记录会被应用到索引，之前的记录已经在磁盘索引的检查点中
+func (bc *Bitcask) loadIndex(df *DataFile, active bool, from int64) (bool, error) {
+	records, err := readHintFile(bc.dir, df.FileID, df.dataStart, df.WriteOff)
+	scanned := err != nil
+	if scanned {
+		records, err = bc.buildIndex(df)
+	}
+	if err != nil {
+		var corruptErr *CorruptionError
+		if !errors.As(err, &corruptErr) {
+			return false, err
+		}
+		switch {
+		case active && bc.options.RecoveryMode != RecoveryModeNone:
+			if err := bc.truncateTail(df, corruptErr); err != nil {
+				return false, err
+			}
+		case !active && bc.options.RecoveryMode == RecoveryModeStrict:
+			return false, bc.quarantine(df, corruptErr, records)
+		default:
+			return false, err
+		}
+	}
+
+	bc.applyHintRecords(records, from)
+	if active && bc.resumable(df) {
+		// 活跃文件会被继续写入，已有的 hint 文件随之过期，封存时再与新写入的记录一起写入
+		bc.hints = records
+		return true, removeHintFile(bc.dir, df.FileID)
+	}
+	// 读写模式下其余已有的数据文件都不会再被写入，顺便补写 hint 文件
+	if scanned && bc.options.ReadWrite {
+		return true, writeHintFile(bc.dir, df.FileID, records)
+	}
+	return true, nil
+}
+
+// applyHintRecords 将偏移量不小于 from 的 hint 记录应用到内存索引
+func (bc *Bitcask) applyHintRecords(records []*hintRecord, from int64) {
+	for _, r := range records {
+		if r.Meta.Offset >= from {
+			bc.applyHintRecord(r)
+		}
+	}
+}
+
+// applyHintRecord 将一条 hint 记录应用到内存索引，并统计被覆盖的记录占用的字节数
+func (bc *Bitcask) applyHintRecord(r *hintRecord) {
+	key := string(r.Key)
+	if old, ok := bc.index.Get(key); ok {
+		bc.markDead(old)
+	}
+	if r.Type == EntryTypePut {
+		meta := r.Meta
+		bc.index.Put(key, &meta)
+	} else if r.Type == EntryTypeDelete {
+		bc.index.Delete(key)
+		// 删除标记本身也是失效数据
+		bc.markDead(&r.Meta)
+	}
+}
+
+// markDead 将一条记录占用的字节数计入所在数据文件的失效字节数
+func (bc *Bitcask) markDead(meta *EntryMetadata) {
+	if df, ok := bc.dataFiles.Find(meta.FileID); ok {
+		df.addDeadBytes(meta.Size)
+	}
+}
+
+// buildIndex 扫描数据文件，返回用于构建内存索引的 hint 记录
+// 遇到不完整或校验失败的记录时，返回此前完整的记录和 *CorruptionError
+func (bc *Bitcask) buildIndex(df *DataFile) ([]*hintRecord, error) {
+	var records []*hintRecord
+	offset := df.dataStart
 	fileInfo, err := df.File.Stat()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	fileSize := fileInfo.Size()
+	corrupted := func(err error) ([]*hintRecord, error) {
+		return records, &CorruptionError{FileID: df.FileID, Offset: offset, Size: fileSize - offset, Err: err}
+	}
 	for offset < fileSize {
-		headerBuf := make([]byte, 29) // 4 + 1 + 8 + 8 + 4 + 4
+		if fileSize-offset < entryHeaderSize {
+			return corrupted(io.ErrUnexpectedEOF)
+		}
+		headerBuf := make([]byte, maxEntryHeaderSize)
+		if fileSize-offset < maxEntryHeaderSize {
+			headerBuf = headerBuf[:fileSize-offset]
+		}
 		_, err := df.File.ReadAt(headerBuf, offset)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		header, headerSize, err := decodeEntryHeader(headerBuf)
+		if err != nil {
+			return corrupted(io.ErrUnexpectedEOF)
 		}
-		checksum := binary.BigEndian.Uint32(headerBuf[0:4])
-		entryType := headerBuf[4]
-		timestamp := int64(binary.BigEndian.Uint64(headerBuf[5:13]))
-		//txnID := int64(binary.BigEndian.Uint64(headerBuf[13:21]))
-		keySize := binary.BigEndian.Uint32(headerBuf[21:25])
-		valueSize := binary.BigEndian.Uint32(headerBuf[25:29])
 
-		entrySize := int64(29 + keySize + valueSize)
+		entrySize := int64(headerSize) + int64(header.keySize) + int64(header.valueSize)
+		if entrySize > fileSize-offset {
+			return corrupted(io.ErrUnexpectedEOF)
+		}
 		buf := make([]byte, entrySize)
 		_, err = df.File.ReadAt(buf, offset)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		calcChecksum := crc32.ChecksumIEEE(buf[4:])
-		if checksum != calcChecksum {
-			return ErrInvalidChecksum
+		if header.checksum != df.checksum(buf[4:]) {
+			return corrupted(ErrInvalidChecksum)
 		}
-		key := buf[29 : 29+keySize]
-		// 构建内存索引
-		if entryType == EntryTypePut {
-			bc.index.Add(string(key), &EntryMetadata{
+		key := buf[headerSize : headerSize+int(header.keySize)]
+		records = append(records, &hintRecord{
+			Key:  key,
+			Type: header.typ,
+			Meta: EntryMetadata{
 				FileID:    df.FileID,
 				Offset:    offset,
 				Size:      entrySize,
-				Timestamp: timestamp,
-			})
-		} else if entryType == EntryTypeDelete {
-			bc.index.Del(string(key))
-		}
+				Timestamp: header.timestamp,
+				ExpiresAt: header.expiresAt,
+			},
+		})
 		offset += entrySize
 	}
+	return records, nil
+}
+
+// rotateFile 当前数据文件写满时封存它，并创建新的活跃文件
+func (bc *Bitcask) rotateFile() error {
+	if bc.currFile.WriteOff < bc.options.MaxFileSize {
+		return nil
+	}
+	return bc.sealActiveFile(bc.maxFileID + 1)
+}
+
+// sealActiveFile 封存当前活跃文件并写入 hint 文件，然后以 nextID 创建新的活跃文件
+// 封存后的文件仍然保持打开，用于读取
+func (bc *Bitcask) sealActiveFile(nextID int64) error {
+	if err := bc.currFile.Sync(); err != nil {
+		return err
+	}
+	if err := writeHintFile(bc.dir, bc.currFile.FileID, bc.hints); err != nil {
+		return err
+	}
+	bc.hints = nil
+	if err := bc.mapFile(bc.currFile); err != nil {
+		return err
+	}
+
+	currFile, err := bc.newActiveFile(nextID)
+	if err != nil {
+		return err
+	}
+	bc.maxFileID = nextID
+	bc.currFile = currFile
+	bc.dataFiles.Add(nextID, currFile)
 	return nil
 }
 
+// replaceActiveFile 以 nextID 创建新的活跃文件，并删除当前空的活跃文件，不留下只有文件头的封存文件
+func (bc *Bitcask) replaceActiveFile(nextID int64) error {
+	currFile, err := bc.newActiveFile(nextID)
+	if err != nil {
+		return err
+	}
+	empty := bc.currFile
+	bc.dataFiles.Del(empty.FileID)
+	bc.maxFileID = nextID
+	bc.currFile = currFile
+	bc.dataFiles.Add(nextID, currFile)
+	bc.hints = nil
+
+	if err := empty.Close(); err != nil {
+		return err
+	}
+	return removeDataFile(bc.dir, empty.FileID)
+}
+
+// newActiveFile 按照配置的格式版本和压缩算法创建新的活跃文件
+func (bc *Bitcask) newActiveFile(fileID int64) (*DataFile, error) {
+	df, err := newDataFile(bc.dir, fileID, true, bc.options.FormatVersion)
+	if err != nil {
+		return nil, err
+	}
+	df.setCompression(bc.options.Compression, bc.options.CompressMinSize)
+	return df, nil
+}
+
+// mapFile 启用了 MMap 时将封存的数据文件映射到内存，活跃文件仍然通过 pread 读取
+func (bc *Bitcask) mapFile(df *DataFile) error {
+	if !bc.options.MMap {
+		return nil
+	}
+	return df.mmap()
+}
+
 // Put 插入或更新键值对
 func (bc *Bitcask) Put(key, value []byte) error {
+	return bc.put(key, value, 0)
+}
+
+// PutWithTTL 插入或更新键值对，并在 ttl 之后过期
+func (bc *Bitcask) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	return bc.put(key, value, expireAt(ttl))
+}
+
+// put 写入键值对，expiresAt 为 0 表示永不过期
+func (bc *Bitcask) put(key, value []byte, expiresAt int64) error {
 	if !bc.options.ReadWrite {
 		return errors.New("bitcask is read-only")
 	}
@@ -152,23 +440,66 @@ func (bc *Bitcask) Put(key, value []byte) error {
 		Timestamp: time.Now().Unix(),
 		Type:      EntryTypePut,
 		TxnID:     0, // 非事务操作，TxnID 为 0
+		ExpiresAt: expiresAt,
 	}
 
-	// 检查当前文件大小，必要时创建新的数据文件
-	if bc.currFile.WriteOff >= bc.options.MaxFileSize {
-		if err := bc.currFile.Sync(); err != nil {
+	if err := bc.writeEntry(entry); err != nil {
+		return err
+	}
+
+	if bc.options.SyncOnPut {
+		return bc.currFile.Sync()
+	}
+	return nil
+}
+
+// ApplyBatch 在一次加锁内按顺序写入一组 Put 和 Delete 记录，其他类型的记录会被忽略
+// 记录保留调用方设置的事务 ID 和过期时间；ApplyBatch 不会同步磁盘，需要时由调用方调用 Sync
+func (bc *Bitcask) ApplyBatch(entries []*Entry) error {
+	if !bc.options.ReadWrite {
+		return errors.New("bitcask is read-only")
+	}
+	bc.Lock()
+	defer bc.Unlock()
+
+	// 写入任何记录之前检查所有的键，避免只写入一部分
+	for _, e := range entries {
+		if err := bc.disk.checkKey(e.Key); err != nil {
 			return err
 		}
-		if err := bc.currFile.Close(); err != nil {
-			return err
+	}
+	now := time.Now().Unix()
+	for _, e := range entries {
+		if e.Type != EntryTypePut && e.Type != EntryTypeDelete {
+			continue
 		}
-		bc.maxFileID++
-		var err error
-		bc.currFile, err = NewDataFile(bc.dir, bc.maxFileID, true)
-		if err != nil {
+		entry := &Entry{
+			Key:       e.Key,
+			Value:     e.Value,
+			Timestamp: now,
+			Type:      e.Type,
+			TxnID:     e.TxnID,
+			ExpiresAt: e.ExpiresAt,
+		}
+		if entry.Type == EntryTypeDelete {
+			entry.Value = []byte{}
+			entry.ExpiresAt = 0
+		}
+		if err := bc.writeEntry(entry); err != nil {
 			return err
 		}
-		bc.dataFiles.Add(bc.maxFileID, bc.currFile)
+	}
+	return nil
+}
+
+// writeEntry 将 Entry 追加到活跃文件并更新内存索引，调用方需持有写锁
+func (bc *Bitcask) writeEntry(entry *Entry) error {
+	if err := bc.disk.checkKey(entry.Key); err != nil {
+		return err
+	}
+	// 检查当前文件大小，必要时创建新的数据文件
+	if err := bc.rotateFile(); err != nil {
+		return err
 	}
 
 	offset, err := bc.currFile.Write(entry)
@@ -176,17 +507,38 @@ func (bc *Bitcask) Put(key, value []byte) error {
 		return err
 	}
 
-	bc.index.Add(string(key), &EntryMetadata{
-		FileID:    bc.currFile.FileID,
-		Offset:    offset,
-		Size:      int64(len(entry.Encode())),
-		Timestamp: entry.Timestamp,
-	})
+	// hint 记录在文件封存时才编码，键需要复制，调用方可能会复用键的缓冲区
+	record := &hintRecord{
+		Key:  append([]byte(nil), entry.Key...),
+		Type: entry.Type,
+		Meta: EntryMetadata{
+			FileID:    bc.currFile.FileID,
+			Offset:    offset,
+			Size:      bc.currFile.WriteOff - offset,
+			Timestamp: entry.Timestamp,
+			ExpiresAt: entry.ExpiresAt,
+		},
+	}
+	bc.applyHintRecord(record)
+	bc.hints = append(bc.hints, record)
+	bc.cache.invalidate(entry.Key)
+	return bc.disk.failed()
+}
 
-	if bc.options.SyncOnPut {
-		return bc.currFile.Sync()
+// lookup 在索引中查找没有过期的键，调用方需持有锁
+func (bc *Bitcask) lookup(key []byte, now int64) (*EntryMetadata, error) {
+	meta, ok := bc.index.Get(string(key))
+	if !ok {
+		// 磁盘索引读取失败时返回错误，而不是当作键不存在
+		if err := bc.disk.failed(); err != nil {
+			return nil, err
+		}
+		return nil, ErrKeyNotFound
 	}
-	return nil
+	if meta.Expired(now) {
+		return nil, ErrKeyNotFound
+	}
+	return meta, nil
 }
 
 // Get 根据键获取值
@@ -194,24 +546,53 @@ func (bc *Bitcask) Get(key []byte) ([]byte, error) {
 	bc.RLock()
 	defer bc.RUnlock()
 
-	meta, ok := bc.index.Find(string(key))
-	if !ok {
-		return nil, errors.New("key not found")
+	meta, err := bc.lookup(key, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	// 过期检查在读取缓存之前，缓存中的值不会比索引活得更久
+	if value, ok := bc.cache.get(key); ok {
+		return value, nil
 	}
 	df, ok := bc.dataFiles.Find(meta.FileID)
 	if !ok {
-		return nil, errors.New("key not found")
+		return nil, ErrKeyNotFound
 	}
 	entry, err := df.ReadAt(meta.Offset, meta.Size)
 	if err != nil {
 		return nil, err
 	}
 	if entry.Type == EntryTypeDelete {
-		return nil, errors.New("key not found")
+		return nil, ErrKeyNotFound
 	}
+	bc.cache.add(key, entry.Value)
 	return entry.Value, nil
 }
 
+// View 在读锁内以 fn 访问键的值，启用了 MMap 且记录在封存文件中时值直接引用内存映射，不复制
+// value 只在 fn 执行期间有效，且不能修改；需要保留时由 fn 复制。View 不经过值缓存
+func (bc *Bitcask) View(key []byte, fn func(value []byte) error) error {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	meta, err := bc.lookup(key, time.Now().UnixNano())
+	if err != nil {
+		return err
+	}
+	df, ok := bc.dataFiles.Find(meta.FileID)
+	if !ok {
+		return ErrKeyNotFound
+	}
+	entry, err := df.viewAt(meta.Offset, meta.Size)
+	if err != nil {
+		return err
+	}
+	if entry.Type == EntryTypeDelete {
+		return ErrKeyNotFound
+	}
+	return fn(entry.Value)
+}
+
 // Delete 删除键
 func (bc *Bitcask) Delete(key []byte) error {
 	if !bc.options.ReadWrite {
@@ -229,31 +610,48 @@ func (bc *Bitcask) Delete(key []byte) error {
 		TxnID:     0,
 	}
 
-	_, err := bc.currFile.Write(entry)
-	if err != nil {
+	if err := bc.writeEntry(entry); err != nil {
 		return err
 	}
 
-	bc.index.Del(string(key))
-
 	if bc.options.SyncOnPut {
 		return bc.currFile.Sync()
 	}
 	return nil
 }
 
+// TTL 返回键的剩余存活时间，永不过期的键返回 NoTTL
+func (bc *Bitcask) TTL(key []byte) (time.Duration, error) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	now := time.Now().UnixNano()
+	meta, err := bc.lookup(key, now)
+	if err != nil {
+		return 0, err
+	}
+	if meta.ExpiresAt == 0 {
+		return NoTTL, nil
+	}
+	return time.Duration(meta.ExpiresAt - now), nil
+}
+
 // ListKeys 列出所有键
 func (bc *Bitcask) ListKeys() ([][]byte, error) {
 	bc.RLock()
 	defer bc.RUnlock()
 
+	now := time.Now().UnixNano()
 	keys := make([][]byte, 0, bc.index.Len())
 	iter := bc.index.Iterator()
 	for {
-		key, _, ok := iter()
+		key, meta, ok := iter()
 		if !ok {
 			break
 		}
+		if meta.Expired(now) {
+			continue
+		}
 		keys = append(keys, []byte(key))
 	}
 	return keys, nil
@@ -264,12 +662,16 @@ func (bc *Bitcask) Fold(fn func(key, value []byte, acc interface{}) interface{},
 	bc.RLock()
 	defer bc.RUnlock()
 
+	now := time.Now().UnixNano()
 	iter := bc.index.Iterator()
 	for {
 		key, meta, ok := iter()
 		if !ok {
 			break
 		}
+		if meta.Expired(now) {
+			continue
+		}
 		df, ok := bc.dataFiles.Find(meta.FileID)
 		if !ok {
 			continue
@@ -286,100 +688,71 @@ func (bc *Bitcask) Fold(fn func(key, value []byte, acc interface{}) interface{},
 	return acc
 }
 
-// Merge 合并数据文件，清理冗余数据
-func (bc *Bitcask) Merge() error {
-	if !bc.options.ReadWrite {
-		return errors.New("bitcask is read-only")
-	}
+// Writable 返回是否以读写模式打开
+func (bc *Bitcask) Writable() bool {
+	return bc.writable
+}
+
+// Sync 将当前数据文件同步到磁盘，并持久化已经应用的日志序列号，启用了磁盘索引时同时写入索引的检查点
+func (bc *Bitcask) Sync() error {
 	bc.Lock()
 	defer bc.Unlock()
 
-	tempFileID := bc.maxFileID + 1
-	tempDataFile, err := NewDataFile(bc.dir, tempFileID, true)
-	if err != nil {
+	if err := bc.currFile.Sync(); err != nil {
 		return err
 	}
+	if err := bc.persistAppliedLSN(); err != nil {
+		return err
+	}
+	return bc.checkpointIndex()
+}
 
-	newIndex := algo.NewSkipList[string, *EntryMetadata](func(a, b string) bool {
-		return a < b
-	})
-	iterIndex := bc.index.Iterator()
-	for {
-		key, meta, ok := iterIndex()
-		if !ok {
-			break
-		}
-		df, ok := bc.dataFiles.Find(meta.FileID)
-		if !ok {
-			continue
-		}
-		entry, err := df.ReadAt(meta.Offset, meta.Size)
-		if err != nil {
-			continue
-		}
-		if entry.Type == EntryTypeDelete {
-			continue
-		}
-		offset, err := tempDataFile.Write(entry)
-		if err != nil {
-			return err
+// Close 关闭 Bitcask 实例，正在进行的合并和校验会被中止
+func (bc *Bitcask) Close() error {
+	atomic.StoreInt32(&bc.closed, 1)
+	bc.stopAutoMerge()
+	bc.stopScrubber()
+	bc.mergeLock.Lock()
+	defer bc.mergeLock.Unlock()
+
+	bc.Lock()
+	defer bc.Unlock()
+
+	// 关闭前写入活跃文件的 hint 文件，下次打开时不再继续写入它的话可以直接加载
+	// 任何一步失败都继续关闭文件并释放目录锁，只返回第一个错误
+	var firstErr error
+	setErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
 		}
-		newIndex.Add(key, &EntryMetadata{
-			FileID:    tempFileID,
-			Offset:    offset,
-			Size:      int64(len(entry.Encode())),
-			Timestamp: entry.Timestamp,
-		})
 	}
-
-	// 替换旧的数据文件
-	iterDataFiles := bc.dataFiles.Iterator()
-	for {
-		_, df, ok := iterDataFiles()
-		if !ok {
-			break
+	if bc.currFile != nil {
+		err := bc.currFile.Sync()
+		if err == nil {
+			err = writeHintFile(bc.dir, bc.currFile.FileID, bc.hints)
 		}
-		if err := df.Close(); err != nil {
-			return err
+		if err == nil {
+			err = bc.persistAppliedLSN()
 		}
-		if err := os.Remove(df.File.Name()); err != nil {
-			return err
+		if err == nil {
+			err = bc.checkpointIndex()
 		}
+		setErr(err)
 	}
 
-	dataFiles := algo.NewSkipList[int64, *DataFile](func(a, b int64) bool {
-		return a < b
-	})
-	dataFiles.Add(tempFileID, tempDataFile)
-	bc.dataFiles = dataFiles
-	bc.index = newIndex
-	bc.maxFileID = tempFileID
-	bc.currFile = tempDataFile
-	return nil
-}
-
-// Sync 将当前数据文件同步到磁盘
-func (bc *Bitcask) Sync() error {
-	bc.Lock()
-	defer bc.Unlock()
-
-	return bc.currFile.Sync()
-}
-
-// Close 关闭 Bitcask 实例
-func (bc *Bitcask) Close() error {
-	bc.Lock()
-	defer bc.Unlock()
-
 	iter := bc.dataFiles.Iterator()
 	for {
 		_, df, ok := iter()
 		if !ok {
 			break
 		}
-		if err := df.Close(); err != nil {
-			return err
-		}
+		setErr(df.Close())
 	}
-	return nil
+	setErr(bc.disk.close())
+
+	if bc.flock != nil {
+		setErr(bc.flock.unlock())
+		bc.flock = nil
+	}
+	return firstErr
 }
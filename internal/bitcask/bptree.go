@@ -0,0 +1,585 @@
+package bitcask
+
+import (
+	"container/list"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"sort"
+)
+
+const (
+	diskPageSize       = 4096
+	diskPageHeaderSize = 4 + 1 + 1 + 2 + 8 + 8 // checksum(4) + type(1) + reserved(1) + count(2) + prev(8) + next(8)
+	diskLeafMetaSize   = 5 * 8                 // fileID + offset + size + timestamp + expiresAt
+
+	// MaxDiskKeySize 磁盘索引支持的最大键长度，保证一个页面至少能放下三个键
+	MaxDiskKeySize = 1024
+
+	// bpMergeThreshold 节点编码后小于该大小时尝试与相邻节点合并
+	bpMergeThreshold = diskPageSize / 4
+	// minIndexCachePages 页缓存至少保留的页数，一次操作涉及的页面不会超过该数量
+	minIndexCachePages = 64
+
+	bpLeafPage     byte = 1
+	bpInternalPage byte = 2
+)
+
+// bpNode B+ 树的节点，从页面解码后缓存在内存中
+// 叶子节点保存键和元数据，并通过 prev 和 next 连接成双向链表；内部节点的 children[i] 中的键都小于 keys[i]，不小于 keys[i-1]
+type bpNode struct {
+	id       uint64
+	leaf     bool
+	keys     []string
+	metas    []EntryMetadata
+	children []uint64
+	prev     uint64
+	next     uint64
+	dirty    bool
+	elem     *list.Element
+}
+
+// size 返回节点编码后的大小
+func (n *bpNode) size() int {
+	size := diskPageHeaderSize
+	if n.leaf {
+		size += len(n.keys) * (2 + diskLeafMetaSize)
+	} else {
+		size += 8 + len(n.keys)*(2+8)
+	}
+	for _, key := range n.keys {
+		size += len(key)
+	}
+	return size
+}
+
+// encode 将节点编码为一个页面
+func (n *bpNode) encode() []byte {
+	buf := make([]byte, diskPageSize)
+	buf[4] = bpInternalPage
+	if n.leaf {
+		buf[4] = bpLeafPage
+	}
+	binary.BigEndian.PutUint16(buf[6:8], uint16(len(n.keys)))
+	binary.BigEndian.PutUint64(buf[8:16], n.prev)
+	binary.BigEndian.PutUint64(buf[16:24], n.next)
+	offset := diskPageHeaderSize
+	if !n.leaf {
+		binary.BigEndian.PutUint64(buf[offset:], n.children[0])
+		offset += 8
+	}
+	for i, key := range n.keys {
+		binary.BigEndian.PutUint16(buf[offset:], uint16(len(key)))
+		offset += 2 + copy(buf[offset+2:], key)
+		if n.leaf {
+			m := &n.metas[i]
+			for _, v := range []int64{m.FileID, m.Offset, m.Size, m.Timestamp, m.ExpiresAt} {
+				binary.BigEndian.PutUint64(buf[offset:], uint64(v))
+				offset += 8
+			}
+		} else {
+			binary.BigEndian.PutUint64(buf[offset:], n.children[i+1])
+			offset += 8
+		}
+	}
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// decodeNode 解码页面，页面损坏时返回 ErrInvalidIndex
+func decodeNode(id uint64, buf []byte) (*bpNode, error) {
+	if binary.BigEndian.Uint32(buf[0:4]) != crc32.ChecksumIEEE(buf[4:]) {
+		return nil, ErrInvalidIndex
+	}
+	n := &bpNode{id: id}
+	switch buf[4] {
+	case bpLeafPage:
+		n.leaf = true
+	case bpInternalPage:
+	default:
+		return nil, ErrInvalidIndex
+	}
+	count := int(binary.BigEndian.Uint16(buf[6:8]))
+	n.prev = binary.BigEndian.Uint64(buf[8:16])
+	n.next = binary.BigEndian.Uint64(buf[16:24])
+	n.keys = make([]string, count)
+	if n.leaf {
+		n.metas = make([]EntryMetadata, count)
+	} else {
+		n.children = make([]uint64, count+1)
+	}
+
+	offset := diskPageHeaderSize
+	if !n.leaf {
+		n.children[0] = binary.BigEndian.Uint64(buf[offset:])
+		offset += 8
+	}
+	cellSize := 8
+	if n.leaf {
+		cellSize = diskLeafMetaSize
+	}
+	for i := 0; i < count; i++ {
+		if offset+2 > len(buf) {
+			return nil, ErrInvalidIndex
+		}
+		keySize := int(binary.BigEndian.Uint16(buf[offset:]))
+		offset += 2
+		if offset+keySize+cellSize > len(buf) {
+			return nil, ErrInvalidIndex
+		}
+		n.keys[i] = string(buf[offset : offset+keySize])
+		offset += keySize
+		if n.leaf {
+			n.metas[i] = EntryMetadata{
+				FileID:    int64(binary.BigEndian.Uint64(buf[offset:])),
+				Offset:    int64(binary.BigEndian.Uint64(buf[offset+8:])),
+				Size:      int64(binary.BigEndian.Uint64(buf[offset+16:])),
+				Timestamp: int64(binary.BigEndian.Uint64(buf[offset+24:])),
+				ExpiresAt: int64(binary.BigEndian.Uint64(buf[offset+32:])),
+			}
+		} else {
+			n.children[i+1] = binary.BigEndian.Uint64(buf[offset:])
+		}
+		offset += cellSize
+	}
+	return n, nil
+}
+
+// bpTree 保存在文件中的 B+ 树，第 0 页是文件头，由调用方管理
+// 节点按页读写，解码后的节点缓存在按 LRU 淘汰的页缓存中；脏页在被淘汰或 flush 时写回
+// 一次操作期间不淘汰页面，操作持有的节点指针始终有效；bpTree 不是并发安全的，由调用方加锁
+type bpTree struct {
+	file      *os.File
+	nodes     map[uint64]*bpNode
+	lru       *list.List
+	capacity  int
+	root      uint64
+	pageCount uint64
+	free      []uint64 // 空闲页，随检查点持久化
+	length    int
+	keyBytes  int64
+
+	// beforeWrite 在第一次写回页面之前调用，用于将文件头标记为未完成的状态
+	beforeWrite func() error
+}
+
+func newBPTree(file *os.File, capacity int) *bpTree {
+	if capacity < minIndexCachePages {
+		capacity = minIndexCachePages
+	}
+	return &bpTree{
+		file:     file,
+		nodes:    make(map[uint64]*bpNode),
+		lru:      list.New(),
+		capacity: capacity,
+	}
+}
+
+// init 初始化一棵只有一个空叶子节点的树
+func (t *bpTree) init() {
+	t.pageCount = 1
+	t.free = nil
+	t.length = 0
+	t.keyBytes = 0
+	t.root = t.alloc(true).id
+}
+
+// node 返回页面对应的节点，不在缓存中时从文件读取
+func (t *bpTree) node(id uint64) (*bpNode, error) {
+	if n, ok := t.nodes[id]; ok {
+		t.lru.MoveToFront(n.elem)
+		return n, nil
+	}
+	if id == 0 || id >= t.pageCount {
+		return nil, ErrInvalidIndex
+	}
+	buf := make([]byte, diskPageSize)
+	if _, err := t.file.ReadAt(buf, int64(id)*diskPageSize); err != nil {
+		return nil, err
+	}
+	n, err := decodeNode(id, buf)
+	if err != nil {
+		return nil, err
+	}
+	n.elem = t.lru.PushFront(n)
+	t.nodes[id] = n
+	return n, nil
+}
+
+// alloc 分配一个新的节点，优先复用空闲页
+func (t *bpTree) alloc(leaf bool) *bpNode {
+	var id uint64
+	if len(t.free) > 0 {
+		id = t.free[len(t.free)-1]
+		t.free = t.free[:len(t.free)-1]
+	} else {
+		id = t.pageCount
+		t.pageCount++
+	}
+	n := &bpNode{id: id, leaf: leaf, dirty: true}
+	n.elem = t.lru.PushFront(n)
+	t.nodes[id] = n
+	return n
+}
+
+// release 释放节点占用的页面
+func (t *bpTree) release(n *bpNode) {
+	t.lru.Remove(n.elem)
+	delete(t.nodes, n.id)
+	t.free = append(t.free, n.id)
+}
+
+// write 将节点写回文件
+func (t *bpTree) write(n *bpNode) error {
+	if err := t.beforeWrite(); err != nil {
+		return err
+	}
+	if _, err := t.file.WriteAt(n.encode(), int64(n.id)*diskPageSize); err != nil {
+		return err
+	}
+	n.dirty = false
+	return nil
+}
+
+// evict 淘汰最久没有访问的页面，直到缓存不超过容量
+func (t *bpTree) evict() error {
+	for t.lru.Len() > t.capacity {
+		n := t.lru.Back().Value.(*bpNode)
+		if n.dirty {
+			if err := t.write(n); err != nil {
+				return err
+			}
+		}
+		t.lru.Remove(n.elem)
+		delete(t.nodes, n.id)
+	}
+	return nil
+}
+
+// flush 写回所有脏页
+func (t *bpTree) flush() error {
+	for e := t.lru.Front(); e != nil; e = e.Next() {
+		if n := e.Value.(*bpNode); n.dirty {
+			if err := t.write(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cacheSize 返回页缓存占用的内存
+func (t *bpTree) cacheSize() int64 {
+	return int64(t.lru.Len()) * diskPageSize
+}
+
+// upperBound 返回第一个大于 key 的键的下标，即 key 所在的子节点
+func upperBound(keys []string, key string) int {
+	return sort.Search(len(keys), func(i int) bool { return keys[i] > key })
+}
+
+// lowerBound 返回第一个不小于 key 的键的下标
+func lowerBound(keys []string, key string) int {
+	return sort.SearchStrings(keys, key)
+}
+
+// leafFor 返回可能包含 key 的叶子节点
+func (t *bpTree) leafFor(key string) (*bpNode, error) {
+	n, err := t.node(t.root)
+	for err == nil && !n.leaf {
+		n, err = t.node(n.children[upperBound(n.keys, key)])
+	}
+	return n, err
+}
+
+func (t *bpTree) get(key string) (EntryMetadata, bool, error) {
+	n, err := t.leafFor(key)
+	if err != nil {
+		return EntryMetadata{}, false, err
+	}
+	i := lowerBound(n.keys, key)
+	if i < len(n.keys) && n.keys[i] == key {
+		return n.metas[i], true, nil
+	}
+	return EntryMetadata{}, false, nil
+}
+
+// bpSplit 节点分裂后需要插入父节点的分隔键和右侧的新节点
+type bpSplit struct {
+	key   string
+	right uint64
+}
+
+// put 插入或更新键，返回键是否已经存在
+func (t *bpTree) put(key string, meta EntryMetadata) (bool, error) {
+	root, err := t.node(t.root)
+	if err != nil {
+		return false, err
+	}
+	replaced, split, err := t.insert(root, key, meta)
+	if err != nil || split == nil {
+		return replaced, err
+	}
+	// 根节点分裂，树增高一层
+	n := t.alloc(false)
+	n.keys = []string{split.key}
+	n.children = []uint64{t.root, split.right}
+	t.root = n.id
+	return replaced, nil
+}
+
+func (t *bpTree) insert(n *bpNode, key string, meta EntryMetadata) (bool, *bpSplit, error) {
+	if n.leaf {
+		i := lowerBound(n.keys, key)
+		n.dirty = true
+		if i < len(n.keys) && n.keys[i] == key {
+			n.metas[i] = meta
+			return true, nil, nil
+		}
+		n.keys = insertAt(n.keys, i, key)
+		n.metas = insertAt(n.metas, i, meta)
+		t.length++
+		t.keyBytes += int64(len(key))
+		split, err := t.split(n)
+		return false, split, err
+	}
+
+	i := upperBound(n.keys, key)
+	child, err := t.node(n.children[i])
+	if err != nil {
+		return false, nil, err
+	}
+	replaced, split, err := t.insert(child, key, meta)
+	if err != nil || split == nil {
+		return replaced, nil, err
+	}
+	n.keys = insertAt(n.keys, i, split.key)
+	n.children = insertAt(n.children, i+1, split.right)
+	n.dirty = true
+	split, err = t.split(n)
+	return replaced, split, err
+}
+
+// split 节点超过一页时按字节数对半分裂，返回需要插入父节点的分隔键
+func (t *bpTree) split(n *bpNode) (*bpSplit, error) {
+	if n.size() <= diskPageSize {
+		return nil, nil
+	}
+	cellSize := 2 + 8
+	if n.leaf {
+		cellSize = 2 + diskLeafMetaSize
+	}
+	half := (n.size() - diskPageHeaderSize) / 2
+	mid, used := 0, 0
+	for mid < len(n.keys)-1 && used < half {
+		used += len(n.keys[mid]) + cellSize
+		mid++
+	}
+
+	right := t.alloc(n.leaf)
+	if n.leaf {
+		right.keys = append([]string(nil), n.keys[mid:]...)
+		right.metas = append([]EntryMetadata(nil), n.metas[mid:]...)
+		n.keys = n.keys[:mid:mid]
+		n.metas = n.metas[:mid:mid]
+
+		right.prev, right.next = n.id, n.next
+		if n.next != 0 {
+			next, err := t.node(n.next)
+			if err != nil {
+				return nil, err
+			}
+			next.prev = right.id
+			next.dirty = true
+		}
+		n.next = right.id
+		return &bpSplit{key: right.keys[0], right: right.id}, nil
+	}
+
+	// 内部节点的中间键上移到父节点
+	key := n.keys[mid]
+	right.keys = append([]string(nil), n.keys[mid+1:]...)
+	right.children = append([]uint64(nil), n.children[mid+1:]...)
+	n.keys = n.keys[:mid:mid]
+	n.children = n.children[: mid+1 : mid+1]
+	return &bpSplit{key: key, right: right.id}, nil
+}
+
+// delete 删除键，返回键是否存在
+func (t *bpTree) delete(key string) (bool, error) {
+	root, err := t.node(t.root)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := t.remove(root, key)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	// 根节点只剩一个子节点时，树降低一层
+	if !root.leaf && len(root.keys) == 0 {
+		t.root = root.children[0]
+		t.release(root)
+	}
+	return true, nil
+}
+
+func (t *bpTree) remove(n *bpNode, key string) (bool, error) {
+	if n.leaf {
+		i := lowerBound(n.keys, key)
+		if i == len(n.keys) || n.keys[i] != key {
+			return false, nil
+		}
+		n.keys = removeAt(n.keys, i)
+		n.metas = removeAt(n.metas, i)
+		n.dirty = true
+		t.length--
+		t.keyBytes -= int64(len(key))
+		return true, nil
+	}
+
+	i := upperBound(n.keys, key)
+	child, err := t.node(n.children[i])
+	if err != nil {
+		return false, err
+	}
+	deleted, err := t.remove(child, key)
+	if err != nil || !deleted {
+		return deleted, err
+	}
+	if child.size() < bpMergeThreshold {
+		return true, t.rebalance(n, i)
+	}
+	return true, nil
+}
+
+// rebalance 尝试将过小的第 i 个子节点与相邻的兄弟节点合并，合并后放不进一页时保持不变
+// 只合并不重新分配，删除后的节点可能不满，空节点总是会被合并掉
+func (t *bpTree) rebalance(parent *bpNode, i int) error {
+	if len(parent.children) < 2 {
+		return nil
+	}
+	if i == len(parent.children)-1 {
+		i--
+	}
+	left, err := t.node(parent.children[i])
+	if err != nil {
+		return err
+	}
+	right, err := t.node(parent.children[i+1])
+	if err != nil {
+		return err
+	}
+
+	size := left.size() + right.size() - diskPageHeaderSize
+	if !left.leaf {
+		size += 2 + len(parent.keys[i])
+	}
+	if size > diskPageSize {
+		return nil
+	}
+
+	if left.leaf {
+		left.keys = append(left.keys, right.keys...)
+		left.metas = append(left.metas, right.metas...)
+		left.next = right.next
+		if right.next != 0 {
+			next, err := t.node(right.next)
+			if err != nil {
+				return err
+			}
+			next.prev = left.id
+			next.dirty = true
+		}
+	} else {
+		// 分隔键下移到合并后的内部节点
+		left.keys = append(append(left.keys, parent.keys[i]), right.keys...)
+		left.children = append(left.children, right.children...)
+	}
+	left.dirty = true
+	parent.keys = removeAt(parent.keys, i)
+	parent.children = removeAt(parent.children, i+1)
+	parent.dirty = true
+	t.release(right)
+	return nil
+}
+
+// ascend 从第一个不小于 key 的键开始正向遍历，fn 返回 false 时停止
+// 遍历时每进入一个新的叶子节点就淘汰多余的页面，长时间的遍历不会使缓存无限增长
+func (t *bpTree) ascend(key string, fn func(string, *EntryMetadata) bool) error {
+	n, err := t.leafFor(key)
+	if err != nil {
+		return err
+	}
+	i := lowerBound(n.keys, key)
+	for {
+		for ; i < len(n.keys); i++ {
+			meta := n.metas[i]
+			if !fn(n.keys[i], &meta) {
+				return nil
+			}
+		}
+		if n.next == 0 {
+			return nil
+		}
+		if n, err = t.node(n.next); err != nil {
+			return err
+		}
+		if err := t.evict(); err != nil {
+			return err
+		}
+		i = 0
+	}
+}
+
+// descend 从最后一个不大于 key 的键开始反向遍历，all 为 true 时从最后一个键开始
+func (t *bpTree) descend(key string, all bool, fn func(string, *EntryMetadata) bool) error {
+	n, err := t.node(t.root)
+	for err == nil && !n.leaf {
+		i := len(n.children) - 1
+		if !all {
+			i = upperBound(n.keys, key)
+		}
+		n, err = t.node(n.children[i])
+	}
+	if err != nil {
+		return err
+	}
+	i := len(n.keys) - 1
+	if !all {
+		i = upperBound(n.keys, key) - 1
+	}
+	for {
+		for ; i >= 0; i-- {
+			meta := n.metas[i]
+			if !fn(n.keys[i], &meta) {
+				return nil
+			}
+		}
+		if n.prev == 0 {
+			return nil
+		}
+		if n, err = t.node(n.prev); err != nil {
+			return err
+		}
+		if err := t.evict(); err != nil {
+			return err
+		}
+		i = len(n.keys) - 1
+	}
+}
+
+// insertAt 在下标 i 处插入元素
+func insertAt[T any](s []T, i int, v T) []T {
+	s = append(s, v)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+// removeAt 删除下标 i 处的元素
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
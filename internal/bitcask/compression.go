@@ -0,0 +1,101 @@
+package bitcask
+
+import (
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression 值的压缩算法
+type Compression byte
+
+const (
+	CompressionNone   Compression = 0 // 不压缩
+	CompressionSnappy Compression = 1 // snappy，速度快，压缩率较低
+	CompressionZstd   Compression = 2 // zstd，压缩率高，速度较慢
+)
+
+// DefaultCompressMinSize 默认的压缩阈值，更小的值压缩收益有限
+const DefaultCompressMinSize = 128
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// initZstd 创建共享的 zstd 编码器和解码器，EncodeAll 和 DecodeAll 可以并发调用
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+// compress 使用指定算法压缩值
+func compress(codec Compression, value []byte) ([]byte, error) {
+	switch codec {
+	case CompressionSnappy:
+		return snappy.Encode(nil, value), nil
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(value, nil), nil
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+// decompress 使用指定算法解压值
+func decompress(codec Compression, value []byte) ([]byte, error) {
+	switch codec {
+	case CompressionSnappy:
+		return snappy.Decode(nil, value)
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(value, nil)
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+// compressEntry 按配置压缩 Put 记录的值，值小于阈值、已经压缩过或压缩后没有变小时原样返回
+func compressEntry(e *Entry, codec Compression, minSize int) (*Entry, error) {
+	if codec == CompressionNone || e.codec != CompressionNone || e.Type != EntryTypePut || len(e.Value) < minSize {
+		return e, nil
+	}
+	value, err := compress(codec, e.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) >= len(e.Value) {
+		return e, nil
+	}
+	compressed := *e
+	compressed.Value = value
+	compressed.codec = codec
+	return &compressed, nil
+}
+
+// decompressEntry 解压记录的值
+func decompressEntry(e *Entry) error {
+	if e.codec == CompressionNone {
+		return nil
+	}
+	value, err := decompress(e.codec, e.Value)
+	if err != nil {
+		return err
+	}
+	e.Value = value
+	e.codec = CompressionNone
+	return nil
+}
@@ -1,24 +1,66 @@
 package bitcask
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type DataFile struct {
 	sync.Mutex
-	File     *os.File
-	FileID   int64
-	WriteOff int64
+	File      *os.File
+	FileID    int64
+	WriteOff  int64
+	deadBytes int64  // 已被覆盖、删除的记录以及删除标记占用的字节数，原子访问
+	Version   uint16 // 数据文件的格式版本，0 表示没有文件头的旧格式
+	CreatedAt int64  // 文件的创建时间（Unix 纳秒），旧格式为 0
+	dataStart int64  // 第一条记录的偏移量，即文件头的大小
+	mapping   []byte // 封存文件的只读内存映射，没有映射时为 nil
+
+	checksum        func([]byte) uint32 // 记录使用的校验和算法，由格式版本决定
+	codec           Compression         // 写入时使用的压缩算法
+	compressMinSize int                 // 值不小于该大小时才会压缩
+}
+
+const (
+	dataFileSuffix = ".data"
+	hintFileSuffix = ".hint"
+)
+
+// 数据文件头: magic(4) + version(2) + reserved(2) + createdAt(8) + reserved(4) + crc(4)
+// 没有文件头的旧数据文件版本为 0，合并时会被重写为当前版本
+// 版本 1 的记录使用 IEEE 校验和，版本 2 起使用 CRC32C，两种版本都可以读取
+const (
+	dataFileMagic      uint32 = 0x464b5644 // "FKVD"
+	DataFileVersion    uint16 = 2          // 当前的数据文件格式版本
+	dataFileHeaderSize        = 24
+)
+
+// dataFileName 返回数据文件的路径
+func dataFileName(dir string, fileID int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%09d%s", fileID, dataFileSuffix))
 }
 
-// NewDataFile 创建新的数据文件
+// hintFileName 返回数据文件对应的 hint 文件路径
+func hintFileName(dir string, fileID int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%09d%s", fileID, hintFileSuffix))
+}
+
+// NewDataFile 创建新的数据文件，新文件使用当前的格式版本
 func NewDataFile(dir string, fileID int64, writable bool) (*DataFile, error) {
-	filename := filepath.Join(dir, fmt.Sprintf("%09d.data", fileID))
+	return newDataFile(dir, fileID, writable, DataFileVersion)
+}
+
+// newDataFile 创建新的数据文件，version 为新文件使用的格式版本，打开已有文件时使用文件头中的版本
+func newDataFile(dir string, fileID int64, writable bool, version uint16) (*DataFile, error) {
+	filename := dataFileName(dir, fileID)
 	var file *os.File
 	var err error
 	if writable {
@@ -31,21 +73,92 @@ func NewDataFile(dir string, fileID int64, writable bool) (*DataFile, error) {
 	}
 	writeOff, err := file.Seek(0, io.SeekEnd)
 	if err != nil {
+		_ = file.Close()
 		return nil, err
 	}
-	return &DataFile{
+	df := &DataFile{
 		File:     file,
 		FileID:   fileID,
 		WriteOff: writeOff,
-	}, nil
+	}
+	if writable && writeOff == 0 {
+		err = df.writeHeader(version)
+	} else {
+		err = df.readHeader()
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	df.checksum = entryChecksum(df.Version)
+	return df, nil
+}
+
+// writeHeader 为新创建的数据文件写入文件头
+func (df *DataFile) writeHeader(version uint16) error {
+	buf := make([]byte, dataFileHeaderSize)
+	now := time.Now().UnixNano()
+	binary.BigEndian.PutUint32(buf[0:4], dataFileMagic)
+	binary.BigEndian.PutUint16(buf[4:6], version)
+	binary.BigEndian.PutUint64(buf[8:16], uint64(now))
+	binary.BigEndian.PutUint32(buf[20:24], crc32.ChecksumIEEE(buf[:20]))
+	if _, err := df.File.WriteAt(buf, 0); err != nil {
+		return err
+	}
+	df.Version = version
+	df.CreatedAt = now
+	df.dataStart = dataFileHeaderSize
+	df.WriteOff = dataFileHeaderSize
+	return nil
 }
 
-// Write 写入 Entry，并返回偏移量
+// readHeader 读取并校验数据文件头，不以魔数开头的文件按没有文件头的旧格式处理
+func (df *DataFile) readHeader() error {
+	if df.WriteOff < dataFileHeaderSize {
+		return nil
+	}
+	buf := make([]byte, dataFileHeaderSize)
+	if _, err := df.File.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(buf[0:4]) != dataFileMagic {
+		return nil
+	}
+	if binary.BigEndian.Uint32(buf[20:24]) != crc32.ChecksumIEEE(buf[:20]) {
+		return fmt.Errorf("data file %d: %w", df.FileID, ErrInvalidDataFile)
+	}
+	version := binary.BigEndian.Uint16(buf[4:6])
+	if version == 0 || version > DataFileVersion {
+		return fmt.Errorf("data file %d version %d: %w", df.FileID, version, ErrUnsupportedVersion)
+	}
+	df.Version = version
+	df.CreatedAt = int64(binary.BigEndian.Uint64(buf[8:16]))
+	df.dataStart = dataFileHeaderSize
+	return nil
+}
+
+// Legacy 返回数据文件是否为没有文件头的旧格式
+func (df *DataFile) Legacy() bool {
+	return df.Version == 0
+}
+
+// setCompression 设置写入时使用的压缩算法和压缩阈值
+func (df *DataFile) setCompression(codec Compression, minSize int) {
+	df.codec = codec
+	df.compressMinSize = minSize
+}
+
+// Write 写入 Entry，并返回偏移量，配置了压缩算法时值达到阈值的记录会被压缩，已经压缩过的值原样写入
 func (df *DataFile) Write(e *Entry) (int64, error) {
+	e, err := compressEntry(e, df.codec, df.compressMinSize)
+	if err != nil {
+		return 0, err
+	}
+
 	df.Lock()
 	defer df.Unlock()
 
-	buf := e.Encode()
+	buf := e.encode(df.checksum)
 	offset := df.WriteOff
 	n, err := df.File.WriteAt(buf, offset)
 	if err != nil {
@@ -55,14 +168,94 @@ func (df *DataFile) Write(e *Entry) (int64, error) {
 	return offset, nil
 }
 
-// ReadAt 从指定偏移量读取指定大小的数据
+// ReadAt 从指定偏移量读取指定大小的数据，压缩过的值会被解压
+// 文件已经映射到内存时从映射中复制，不需要系统调用
 func (df *DataFile) ReadAt(offset int64, size int64) (*Entry, error) {
+	entry, err := df.readRaw(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	if err := decompressEntry(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// readRaw 与 ReadAt 相同，但压缩过的值保持原样，合并时用于不经解压地复制记录
+func (df *DataFile) readRaw(offset int64, size int64) (*Entry, error) {
 	buf := make([]byte, size)
-	_, err := df.File.ReadAt(buf, offset)
+	if df.mapped(offset, size) {
+		copy(buf, df.mapping[offset:offset+size])
+	} else if _, err := df.File.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return decodeEntry(buf, df.checksum)
+}
+
+// viewAt 与 ReadAt 相同，但文件已经映射到内存时返回的 Entry 直接引用映射中的数据
+// 返回的键值只在持有 Bitcask 的读锁期间有效，且不能修改
+func (df *DataFile) viewAt(offset int64, size int64) (*Entry, error) {
+	if !df.mapped(offset, size) {
+		return df.ReadAt(offset, size)
+	}
+	return df.decode(df.mapping[offset : offset+size])
+}
+
+// decode 按文件的格式版本校验并解码记录，然后解压其中的值
+func (df *DataFile) decode(buf []byte) (*Entry, error) {
+	entry, err := decodeEntry(buf, df.checksum)
 	if err != nil {
 		return nil, err
 	}
-	return DecodeEntry(buf)
+	if err := decompressEntry(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// mapped 返回 [offset, offset+size) 是否在内存映射的范围内
+func (df *DataFile) mapped(offset int64, size int64) bool {
+	return df.mapping != nil && offset >= 0 && offset+size <= int64(len(df.mapping))
+}
+
+// mmap 将封存的数据文件映射到内存，之后不能再写入
+func (df *DataFile) mmap() error {
+	if df.mapping != nil || df.WriteOff == 0 {
+		return nil
+	}
+	mapping, err := mmap(df, df.WriteOff)
+	if err != nil {
+		return err
+	}
+	df.mapping = mapping
+	return nil
+}
+
+// munmap 解除数据文件的内存映射
+func (df *DataFile) munmap() error {
+	if df.mapping == nil {
+		return nil
+	}
+	mapping := df.mapping
+	df.mapping = nil
+	return munmap(mapping)
+}
+
+// DeadBytes 返回数据文件中已失效记录占用的字节数
+func (df *DataFile) DeadBytes() int64 {
+	return atomic.LoadInt64(&df.deadBytes)
+}
+
+// DeadRatio 返回数据文件中失效数据的占比，不计入文件头
+func (df *DataFile) DeadRatio() float64 {
+	if df.WriteOff <= df.dataStart {
+		return 0
+	}
+	return float64(df.DeadBytes()) / float64(df.WriteOff-df.dataStart)
+}
+
+func (df *DataFile) addDeadBytes(n int64) {
+	atomic.AddInt64(&df.deadBytes, n)
 }
 
 func safeClose(file *os.File) error {
@@ -78,8 +271,11 @@ func safeClose(file *os.File) error {
 	return nil
 }
 
-// Close 关闭数据文件
+// Close 解除内存映射并关闭数据文件
 func (df *DataFile) Close() error {
+	if err := df.munmap(); err != nil {
+		return err
+	}
 	return safeClose(df.File)
 }
 
@@ -3,6 +3,7 @@ package bitcask
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"time"
 )
 
 const (
@@ -12,6 +13,19 @@ const (
 	EntryTypeTxnEnd   byte = 3 // 事务结束
 )
 
+// 类型字节的低 4 位表示操作类型，高位作为格式扩展标志
+// 不带标志的 Entry 与最初的格式完全一致，旧数据文件无需转换即可读取
+const (
+	entryTypeMask   byte = 0x0f
+	entryFlagExpire byte = 0x80 // 头部在事务 ID 之后带有 8 字节的过期时间
+	entryCodecMask  byte = 0x30 // 值的压缩算法，右移 4 位后为 Compression，0 表示没有压缩
+)
+
+const (
+	entryHeaderSize    = 4 + 1 + 8 + 8 + 4 + 4 // 基础头部大小
+	maxEntryHeaderSize = entryHeaderSize + 8   // 带有全部扩展字段时的头部大小
+)
+
 // Entry 表示一个数据条目
 type Entry struct {
 	Key       []byte
@@ -19,15 +33,97 @@ type Entry struct {
 	Timestamp int64
 	Type      byte  // 操作类型
 	TxnID     int64 // 事务 ID
+	ExpiresAt int64 // 过期时间（Unix 纳秒），0 表示永不过期
+
+	codec Compression // 编码后的值使用的压缩算法，只在数据文件的读写过程中使用
+}
+
+// entryHeader 表示 Entry 的头部
+type entryHeader struct {
+	checksum  uint32
+	typ       byte
+	flags     byte
+	timestamp int64
+	txnID     int64
+	expiresAt int64
+	keySize   uint32
+	valueSize uint32
+}
+
+// headerSize 返回带有扩展字段时的头部大小
+func headerSize(flags byte) int {
+	size := entryHeaderSize
+	if flags&entryFlagExpire != 0 {
+		size += 8
+	}
+	return size
+}
+
+// decodeEntryHeader 解码 Entry 头部，返回头部及其大小
+func decodeEntryHeader(buf []byte) (*entryHeader, int, error) {
+	if len(buf) < entryHeaderSize {
+		return nil, 0, ErrInvalidEntry
+	}
+	h := &entryHeader{
+		checksum: binary.BigEndian.Uint32(buf[0:4]),
+		typ:      buf[4] & entryTypeMask,
+		flags:    buf[4] &^ entryTypeMask,
+	}
+	size := headerSize(h.flags)
+	if len(buf) < size {
+		return nil, 0, ErrInvalidEntry
+	}
+	offset := 5
+	h.timestamp = int64(binary.BigEndian.Uint64(buf[offset:]))
+	offset += 8
+	h.txnID = int64(binary.BigEndian.Uint64(buf[offset:]))
+	offset += 8
+	if h.flags&entryFlagExpire != 0 {
+		h.expiresAt = int64(binary.BigEndian.Uint64(buf[offset:]))
+		offset += 8
+	}
+	h.keySize = binary.BigEndian.Uint32(buf[offset:])
+	offset += 4
+	h.valueSize = binary.BigEndian.Uint32(buf[offset:])
+	return h, size, nil
+}
+
+// castagnoliTable CRC32C 的查找表，amd64 和 arm64 上会使用硬件指令计算
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumCRC32C 计算 CRC32C 校验和
+func checksumCRC32C(data []byte) uint32 {
+	return crc32.Checksum(data, castagnoliTable)
+}
+
+// entryChecksum 返回指定格式版本的数据文件中记录使用的校验和算法
+// 版本 2 起使用 CRC32C，之前的版本使用 IEEE
+func entryChecksum(version uint16) func([]byte) uint32 {
+	if version >= 2 {
+		return checksumCRC32C
+	}
+	return crc32.ChecksumIEEE
 }
 
-// Encode 将 Entry 编码为字节数组
+// Encode 将 Entry 编码为字节数组，使用 IEEE 校验和
 func (e *Entry) Encode() []byte {
-	keySize := int32(len(e.Key))
-	valueSize := int32(len(e.Value))
+	return e.encode(crc32.ChecksumIEEE)
+}
+
+// encode 将 Entry 编码为字节数组，checksum 为校验和算法
+func (e *Entry) encode(checksum func([]byte) uint32) []byte {
+	keySize := len(e.Key)
+	valueSize := len(e.Value)
+
+	var flags byte
+	if e.ExpiresAt != 0 {
+		flags |= entryFlagExpire
+	}
+	flags |= byte(e.codec) << 4 & entryCodecMask
 
 	// 计算总长度
-	totalSize := 4 + 1 + 8 + 8 + 4 + 4 + keySize + valueSize
+	size := headerSize(flags)
+	totalSize := size + keySize + valueSize
 	buf := make([]byte, totalSize)
 	offset := 0
 
@@ -35,8 +131,8 @@ func (e *Entry) Encode() []byte {
 	binary.BigEndian.PutUint32(buf[offset:], 0)
 	offset += 4
 
-	// 操作类型
-	buf[offset] = e.Type
+	// 操作类型和扩展标志
+	buf[offset] = e.Type | flags
 	offset += 1
 
 	// 时间戳
@@ -47,6 +143,12 @@ func (e *Entry) Encode() []byte {
 	binary.BigEndian.PutUint64(buf[offset:], uint64(e.TxnID))
 	offset += 8
 
+	// 过期时间
+	if flags&entryFlagExpire != 0 {
+		binary.BigEndian.PutUint64(buf[offset:], uint64(e.ExpiresAt))
+		offset += 8
+	}
+
 	// Key 和 Value 的大小
 	binary.BigEndian.PutUint32(buf[offset:], uint32(keySize))
 	offset += 4
@@ -55,59 +157,42 @@ func (e *Entry) Encode() []byte {
 
 	// Key
 	copy(buf[offset:], e.Key)
-	offset += int(keySize)
+	offset += keySize
 
 	// Value
 	copy(buf[offset:], e.Value)
 
 	// 计算校验和
-	checksum := crc32.ChecksumIEEE(buf[4:])
-	binary.BigEndian.PutUint32(buf[0:], checksum)
+	binary.BigEndian.PutUint32(buf[0:], checksum(buf[4:]))
 
 	return buf
 }
 
-// DecodeEntry 从字节数组解码为 Entry
+// DecodeEntry 从字节数组解码为 Entry，使用 IEEE 校验和
 func DecodeEntry(buf []byte) (*Entry, error) {
-	if len(buf) < 25 { // 4 + 1 + 8 + 8 + 4 + 4 = 29
-		return nil, ErrInvalidEntry
+	return decodeEntry(buf, crc32.ChecksumIEEE)
+}
+
+// decodeEntry 从字节数组解码为 Entry，checksum 为校验和算法
+func decodeEntry(buf []byte, checksum func([]byte) uint32) (*Entry, error) {
+	h, offset, err := decodeEntryHeader(buf)
+	if err != nil {
+		return nil, err
 	}
-	offset := 0
 
 	// 校验和验证
-	checksum := binary.BigEndian.Uint32(buf[offset:])
-	offset += 4
-	calcChecksum := crc32.ChecksumIEEE(buf[4:])
-	if checksum != calcChecksum {
+	if h.checksum != checksum(buf[4:]) {
 		return nil, ErrInvalidChecksum
 	}
 
-	// 操作类型
-	entryType := buf[offset]
-	offset += 1
-
-	// 时间戳
-	timestamp := int64(binary.BigEndian.Uint64(buf[offset:]))
-	offset += 8
-
-	// 事务 ID
-	txnID := int64(binary.BigEndian.Uint64(buf[offset:]))
-	offset += 8
-
-	// Key 和 Value 的大小
-	keySize := binary.BigEndian.Uint32(buf[offset:])
-	offset += 4
-	valueSize := binary.BigEndian.Uint32(buf[offset:])
-	offset += 4
-
-	totalSize := offset + int(keySize) + int(valueSize)
+	totalSize := offset + int(h.keySize) + int(h.valueSize)
 	if totalSize != len(buf) {
 		return nil, ErrInvalidEntry
 	}
 
 	// Key
-	key := buf[offset : offset+int(keySize)]
-	offset += int(keySize)
+	key := buf[offset : offset+int(h.keySize)]
+	offset += int(h.keySize)
 
 	// Value
 	value := buf[offset:]
@@ -115,9 +200,11 @@ func DecodeEntry(buf []byte) (*Entry, error) {
 	return &Entry{
 		Key:       key,
 		Value:     value,
-		Timestamp: timestamp,
-		Type:      entryType,
-		TxnID:     txnID,
+		Timestamp: h.timestamp,
+		Type:      h.typ,
+		TxnID:     h.txnID,
+		ExpiresAt: h.expiresAt,
+		codec:     Compression(h.flags&entryCodecMask) >> 4,
 	}, nil
 }
 
@@ -127,4 +214,15 @@ type EntryMetadata struct {
 	Offset    int64
 	Size      int64
 	Timestamp int64
+	ExpiresAt int64
+}
+
+// Expired 返回记录在 now 时刻是否已经过期
+func (m *EntryMetadata) Expired(now int64) bool {
+	return m.ExpiresAt != 0 && m.ExpiresAt <= now
+}
+
+// expireAt 根据 TTL 计算过期时间
+func expireAt(ttl time.Duration) int64 {
+	return time.Now().Add(ttl).UnixNano()
 }
@@ -3,6 +3,19 @@ package bitcask
 import "errors"
 
 var (
-	ErrInvalidChecksum = errors.New("invalid checksum")
-	ErrInvalidEntry    = errors.New("invalid entry")
+	ErrKeyNotFound        = errors.New("key not found")
+	ErrInvalidChecksum    = errors.New("invalid checksum")
+	ErrInvalidEntry       = errors.New("invalid entry")
+	ErrInvalidHint        = errors.New("invalid hint file")
+	ErrInvalidTTL         = errors.New("invalid ttl")
+	ErrMergeInProgress    = errors.New("merge is in progress")
+	ErrClosed             = errors.New("bitcask is closed")
+	ErrDatabaseLocked     = errors.New("database is locked by another process")
+	ErrLockUnsupported    = errors.New("directory locking is not supported on this platform")
+	ErrUnknownCompression = errors.New("unknown compression codec")
+	ErrInvalidDataFile    = errors.New("invalid data file header")
+	ErrUnsupportedVersion = errors.New("unsupported data file version")
+	ErrInvalidOptions     = errors.New("invalid options")
+	ErrInvalidIndex       = errors.New("invalid index file")
+	ErrKeyTooLarge        = errors.New("key is too large")
 )
@@ -0,0 +1,11 @@
+package bitcask
+
+import "os"
+
+// lockFileName 数据目录中的锁文件
+const lockFileName = "LOCK"
+
+// dirLock 数据目录的排他锁，保证同一时间只有一个进程以读写模式打开数据目录
+type dirLock struct {
+	file *os.File
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package bitcask
+
+// lockDir 在不支持 flock 的平台上无法保证排他访问，返回 ErrLockUnsupported
+func lockDir(dir string) (*dirLock, error) {
+	return nil, ErrLockUnsupported
+}
+
+// unlock 释放数据目录的锁
+func (l *dirLock) unlock() error {
+	return nil
+}
@@ -0,0 +1,149 @@
+package bitcask
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// hintHeaderSize hint 记录头部大小: checksum(4) + type(1) + fileID(8) + offset(8) + size(8) + timestamp(8) + keySize(4)
+// 与 Entry 相同，类型字节带有 entryFlagExpire 时在时间戳之后多出 8 字节的过期时间
+const hintHeaderSize = 4 + 1 + 8 + 8 + 8 + 8 + 4
+
+// hintRecord 表示 hint 文件中的一条记录，对应数据文件中的一个 Entry
+type hintRecord struct {
+	Key  []byte
+	Type byte
+	Meta EntryMetadata
+}
+
+// encode 将 hint 记录编码为字节数组
+func (r *hintRecord) encode() []byte {
+	var flags byte
+	size := hintHeaderSize
+	if r.Meta.ExpiresAt != 0 {
+		flags |= entryFlagExpire
+		size += 8
+	}
+	buf := make([]byte, size+len(r.Key))
+	buf[4] = r.Type | flags
+	binary.BigEndian.PutUint64(buf[5:13], uint64(r.Meta.FileID))
+	binary.BigEndian.PutUint64(buf[13:21], uint64(r.Meta.Offset))
+	binary.BigEndian.PutUint64(buf[21:29], uint64(r.Meta.Size))
+	binary.BigEndian.PutUint64(buf[29:37], uint64(r.Meta.Timestamp))
+	offset := 37
+	if flags&entryFlagExpire != 0 {
+		binary.BigEndian.PutUint64(buf[offset:], uint64(r.Meta.ExpiresAt))
+		offset += 8
+	}
+	binary.BigEndian.PutUint32(buf[offset:], uint32(len(r.Key)))
+	copy(buf[size:], r.Key)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// writeHintFile 将 hint 记录写入数据文件对应的 hint 文件
+// 先写入临时文件再重命名，保证 hint 文件要么完整存在，要么不存在
+func writeHintFile(dir string, fileID int64, records []*hintRecord) error {
+	filename := hintFileName(dir, fileID)
+	tmpName := filename + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	for _, r := range records {
+		if _, err := w.Write(r.encode()); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
+// readHintFile 读取数据文件对应的 hint 文件
+// dataStart 为数据文件中第一条记录的偏移量，dataSize 为数据文件大小，用于检查 hint 记录是否越界以及是否覆盖整个数据文件
+func readHintFile(dir string, fileID int64, dataStart, dataSize int64) ([]*hintRecord, error) {
+	file, err := os.Open(hintFileName(dir, fileID))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var records []*hintRecord
+	end := dataStart
+	header := make([]byte, hintHeaderSize+8)
+	for {
+		_, err := io.ReadFull(r, header[:5])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrInvalidHint
+		}
+		size := hintHeaderSize
+		if header[4]&entryFlagExpire != 0 {
+			size += 8
+		}
+		if _, err := io.ReadFull(r, header[5:size]); err != nil {
+			return nil, ErrInvalidHint
+		}
+		keySize := binary.BigEndian.Uint32(header[size-4 : size])
+		buf := make([]byte, size+int(keySize))
+		copy(buf, header[:size])
+		if _, err := io.ReadFull(r, buf[size:]); err != nil {
+			return nil, ErrInvalidHint
+		}
+		if binary.BigEndian.Uint32(buf[0:4]) != crc32.ChecksumIEEE(buf[4:]) {
+			return nil, ErrInvalidHint
+		}
+
+		record := &hintRecord{
+			Key:  buf[size:],
+			Type: buf[4] & entryTypeMask,
+			Meta: EntryMetadata{
+				FileID:    int64(binary.BigEndian.Uint64(buf[5:13])),
+				Offset:    int64(binary.BigEndian.Uint64(buf[13:21])),
+				Size:      int64(binary.BigEndian.Uint64(buf[21:29])),
+				Timestamp: int64(binary.BigEndian.Uint64(buf[29:37])),
+			},
+		}
+		if buf[4]&entryFlagExpire != 0 {
+			record.Meta.ExpiresAt = int64(binary.BigEndian.Uint64(buf[37:45]))
+		}
+		if record.Meta.FileID != fileID || record.Meta.Offset < dataStart || record.Meta.Offset+record.Meta.Size > dataSize {
+			return nil, ErrInvalidHint
+		}
+		records = append(records, record)
+		end = record.Meta.Offset + record.Meta.Size
+	}
+	// hint 文件必须完整覆盖数据文件，否则说明数据文件在 hint 写入后又被追加过
+	if end != dataSize {
+		return nil, ErrInvalidHint
+	}
+	return records, nil
+}
+
+// removeHintFile 删除 hint 文件，文件不存在时忽略
+func removeHintFile(dir string, fileID int64) error {
+	err := os.Remove(hintFileName(dir, fileID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package bitcask
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// ScanOption 范围扫描的配置函数
+type ScanOption func(*ScanOptions)
+
+// ScanOptions 范围扫描的配置项
+type ScanOptions struct {
+	Reverse bool // 是否按键从大到小遍历
+	Limit   int  // 最多返回的键数量，0 表示不限制
+}
+
+// WithReverse 按键从大到小遍历
+func WithReverse() ScanOption {
+	return func(opts *ScanOptions) {
+		opts.Reverse = true
+	}
+}
+
+// WithLimit 限制返回的键数量
+func WithLimit(limit int) ScanOption {
+	return func(opts *ScanOptions) {
+		opts.Limit = limit
+	}
+}
+
+// NewScanOptions 根据配置函数生成扫描配置
+func NewScanOptions(opts ...ScanOption) *ScanOptions {
+	options := &ScanOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// PrefixEnd 返回所有以 prefix 为前缀的键的上界（不包含），prefix 全为 0xff 时返回 nil
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Iterator 按键的顺序遍历 [start, end) 范围内的键值对
+// 迭代器不持有 Bitcask 的锁，遍历过程中可以并发读写
+type Iterator struct {
+	bc      *Bitcask
+	start   []byte
+	end     []byte
+	options *ScanOptions
+	next    func() (string, *EntryMetadata, bool)
+	key     string
+	meta    *EntryMetadata
+	valid   bool
+	count   int
+}
+
+// Scan 返回遍历 [start, end) 范围内键值对的迭代器，start 或 end 为 nil 表示不限制
+func (bc *Bitcask) Scan(start, end []byte, opts ...ScanOption) *Iterator {
+	it := &Iterator{
+		bc:      bc,
+		start:   start,
+		end:     end,
+		options: NewScanOptions(opts...),
+	}
+	it.Rewind()
+	return it
+}
+
+// PrefixScan 返回遍历所有以 prefix 为前缀的键值对的迭代器
+func (bc *Bitcask) PrefixScan(prefix []byte, opts ...ScanOption) *Iterator {
+	return bc.Scan(prefix, PrefixEnd(prefix), opts...)
+}
+
+// Rewind 回到范围的起点（反向遍历时为终点）
+func (it *Iterator) Rewind() {
+	if it.options.Reverse {
+		if it.end == nil {
+			it.next = it.bc.index.ReverseIterator()
+		} else {
+			it.next = it.bc.index.SeekReverse(string(it.end))
+		}
+	} else {
+		it.next = it.bc.index.Seek(string(it.start))
+	}
+	it.count = 0
+	it.Next()
+}
+
+// Seek 定位到第一个不小于 key 的键（反向遍历时为最后一个不大于 key 的键）
+func (it *Iterator) Seek(key []byte) {
+	if it.options.Reverse {
+		if it.end != nil && bytes.Compare(key, it.end) >= 0 {
+			key = it.end
+		}
+		it.next = it.bc.index.SeekReverse(string(key))
+	} else {
+		if bytes.Compare(key, it.start) < 0 {
+			key = it.start
+		}
+		it.next = it.bc.index.Seek(string(key))
+	}
+	it.count = 0
+	it.Next()
+}
+
+// Next 移动到下一个键
+func (it *Iterator) Next() {
+	it.valid = false
+	if it.next == nil || (it.options.Limit > 0 && it.count >= it.options.Limit) {
+		return
+	}
+	now := time.Now().UnixNano()
+	for {
+		key, meta, ok := it.next()
+		if !ok {
+			it.next = nil
+			return
+		}
+		if !it.inRange(key) {
+			// 已经越过范围边界，后续的键都不在范围内
+			if (it.options.Reverse && key < string(it.start)) ||
+				(!it.options.Reverse && it.end != nil && key >= string(it.end)) {
+				it.next = nil
+				return
+			}
+			continue
+		}
+		if meta.Expired(now) {
+			continue
+		}
+		it.key, it.meta, it.valid = key, meta, true
+		it.count++
+		return
+	}
+}
+
+// inRange 返回 key 是否在 [start, end) 范围内
+func (it *Iterator) inRange(key string) bool {
+	if key < string(it.start) {
+		return false
+	}
+	return it.end == nil || key < string(it.end)
+}
+
+// Valid 返回迭代器是否指向一个有效的键
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+// Key 返回当前的键
+func (it *Iterator) Key() []byte {
+	if !it.valid {
+		return nil
+	}
+	return []byte(it.key)
+}
+
+// Value 返回当前键对应的值
+func (it *Iterator) Value() ([]byte, error) {
+	if !it.valid {
+		return nil, errors.New("iterator is not valid")
+	}
+	it.bc.RLock()
+	defer it.bc.RUnlock()
+
+	meta := it.meta
+	df, ok := it.bc.dataFiles.Find(meta.FileID)
+	if !ok {
+		// 数据文件已被合并，重新从索引中查找记录的位置
+		meta, ok = it.bc.index.Get(it.key)
+		if !ok {
+			return nil, ErrKeyNotFound
+		}
+		df, ok = it.bc.dataFiles.Find(meta.FileID)
+		if !ok {
+			return nil, ErrKeyNotFound
+		}
+	}
+	entry, err := df.ReadAt(meta.Offset, meta.Size)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Type == EntryTypeDelete {
+		return nil, ErrKeyNotFound
+	}
+	return entry.Value, nil
+}
+
+// Close 关闭迭代器
+func (it *Iterator) Close() {
+	it.next = nil
+	it.valid = false
+}
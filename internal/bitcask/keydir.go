@@ -0,0 +1,176 @@
+package bitcask
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"FinnKV/internal/algo"
+)
+
+// IndexMode 内存索引的实现方式
+type IndexMode byte
+
+const (
+	// IndexSkipList 默认的跳表索引，有序，每个键的额外开销较大
+	IndexSkipList IndexMode = iota
+	// IndexCompact 紧凑的有序索引，键保存在连续的内存块中，元数据压缩为 32 字节
+	IndexCompact
+	// IndexHash 紧凑的哈希索引，内存占用最小，范围扫描时需要临时排序
+	IndexHash
+	// IndexBTree B 树索引，有序，节点中的键连续存放，缓存友好
+	IndexBTree
+	// IndexART 自适应基数树索引，有序，查找的代价只与键的长度有关，适合有较长公共前缀的键
+	IndexART
+	// IndexDisk 保存在数据目录中的 B+ 树索引，只有页缓存常驻内存，适合键的总量超过内存的数据集
+	IndexDisk
+)
+
+// maxCompactFileSize 紧凑索引用 32 位保存偏移量和大小，数据文件不能超过该大小
+const maxCompactFileSize = 1 << 31
+
+// Indexer 内存索引，保存每个键最新记录的位置
+// 修改由 Bitcask 的写锁串行化；读取和迭代可以与修改并发进行
+type Indexer interface {
+	Get(key string) (*EntryMetadata, bool)
+	Put(key string, meta *EntryMetadata)
+	Delete(key string) bool
+	Len() int
+	Size() int64     // 估算的内存占用，包括键本身
+	KeyBytes() int64 // 所有键的字节数之和
+
+	Iterator() func() (string, *EntryMetadata, bool)       // 按键从小到大遍历，哈希索引不保证顺序
+	Seek(key string) func() (string, *EntryMetadata, bool) // 从第一个不小于 key 的键开始正向遍历
+	ReverseIterator() func() (string, *EntryMetadata, bool)
+	SeekReverse(key string) func() (string, *EntryMetadata, bool) // 从最后一个不大于 key 的键开始反向遍历
+}
+
+// NewIndexer 按索引模式创建内存索引
+// IndexDisk 需要数据目录，只能由 Open 创建，此时返回 nil
+func NewIndexer(mode IndexMode) Indexer {
+	switch mode {
+	case IndexCompact:
+		return newCompactKeydir()
+	case IndexHash:
+		return newHashKeydir()
+	case IndexBTree:
+		return newBTreeKeydir()
+	case IndexART:
+		return newARTKeydir()
+	case IndexDisk:
+		return nil
+	default:
+		return newSkipListKeydir()
+	}
+}
+
+// IndexStats 内存索引的统计信息
+type IndexStats struct {
+	Mode           IndexMode
+	Keys           int
+	KeyBytes       int64   // 所有键的字节数之和
+	Size           int64   // 估算的内存占用，包括键本身；IndexDisk 为页缓存占用的内存
+	OverheadPerKey float64 // 除键本身之外，每个键平均占用的字节数；键不常驻内存时为 0
+}
+
+// IndexStats 返回内存索引的统计信息
+func (bc *Bitcask) IndexStats() IndexStats {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	stats := IndexStats{
+		Mode:     bc.options.IndexMode,
+		Keys:     bc.index.Len(),
+		KeyBytes: bc.index.KeyBytes(),
+		Size:     bc.index.Size(),
+	}
+	if stats.Keys > 0 && stats.Size > stats.KeyBytes {
+		stats.OverheadPerKey = float64(stats.Size-stats.KeyBytes) / float64(stats.Keys)
+	}
+	return stats
+}
+
+// skipListNodeSize 跳表索引中每个键的估算开销：节点、平均约 2.3 层的前向指针以及堆上的 EntryMetadata
+const skipListNodeSize = 48 + 24 + 8*7/3 + 48
+
+// skipListKeydir 基于 algo.SkipList 的有序索引
+type skipListKeydir struct {
+	list     *algo.SkipList[string, *EntryMetadata]
+	keyBytes int64
+}
+
+func newSkipListKeydir() *skipListKeydir {
+	return &skipListKeydir{
+		list: algo.NewSkipList[string, *EntryMetadata](func(a, b string) bool { return a < b }),
+	}
+}
+
+func (s *skipListKeydir) Get(key string) (*EntryMetadata, bool) {
+	return s.list.Find(key)
+}
+
+func (s *skipListKeydir) Put(key string, meta *EntryMetadata) {
+	if _, ok := s.list.Find(key); !ok {
+		atomic.AddInt64(&s.keyBytes, int64(len(key)))
+	}
+	s.list.Add(key, meta)
+}
+
+func (s *skipListKeydir) Delete(key string) bool {
+	if !s.list.Del(key) {
+		return false
+	}
+	atomic.AddInt64(&s.keyBytes, -int64(len(key)))
+	return true
+}
+
+func (s *skipListKeydir) Len() int {
+	return s.list.Len()
+}
+
+func (s *skipListKeydir) Size() int64 {
+	return int64(s.list.Len())*skipListNodeSize + s.KeyBytes()
+}
+
+func (s *skipListKeydir) KeyBytes() int64 {
+	return atomic.LoadInt64(&s.keyBytes)
+}
+
+func (s *skipListKeydir) Iterator() func() (string, *EntryMetadata, bool) {
+	return s.list.Iterator()
+}
+
+func (s *skipListKeydir) Seek(key string) func() (string, *EntryMetadata, bool) {
+	return s.list.Seek(key)
+}
+
+func (s *skipListKeydir) ReverseIterator() func() (string, *EntryMetadata, bool) {
+	return s.list.ReverseIterator()
+}
+
+func (s *skipListKeydir) SeekReverse(key string) func() (string, *EntryMetadata, bool) {
+	return s.list.SeekReverse(key)
+}
+
+// sortedIterator 按给定的键的顺序遍历，每一步重新查找元数据，已经删除的键会被跳过
+func sortedIterator(kd Indexer, keys []string) func() (string, *EntryMetadata, bool) {
+	i := 0
+	return func() (string, *EntryMetadata, bool) {
+		for i < len(keys) {
+			key := keys[i]
+			i++
+			if meta, ok := kd.Get(key); ok {
+				return key, meta, true
+			}
+		}
+		return "", nil, false
+	}
+}
+
+// sortKeys 对键排序，reverse 为 true 时从大到小
+func sortKeys(keys []string, reverse bool) {
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	} else {
+		sort.Strings(keys)
+	}
+}
@@ -0,0 +1,289 @@
+package bitcask
+
+import (
+	"sort"
+	"sync"
+)
+
+// compactChunkSize 紧凑索引中每个有序块的目标大小，块超过两倍时分裂
+const compactChunkSize = 256
+
+// compactEntry 紧凑索引中的一个键，共 32 字节
+// 不保存记录的时间戳，索引只需要定位记录和判断过期
+type compactEntry struct {
+	key       keyRef
+	fileID    uint32
+	offset    uint32
+	size      uint32
+	expiresAt int64
+}
+
+// packEntry 将元数据压缩为紧凑的格式
+func packEntry(key keyRef, meta *EntryMetadata) compactEntry {
+	return compactEntry{
+		key:       key,
+		fileID:    uint32(meta.FileID),
+		offset:    uint32(meta.Offset),
+		size:      uint32(meta.Size),
+		expiresAt: meta.ExpiresAt,
+	}
+}
+
+// meta 还原为 EntryMetadata
+func (e *compactEntry) meta() *EntryMetadata {
+	return &EntryMetadata{
+		FileID:    int64(e.fileID),
+		Offset:    int64(e.offset),
+		Size:      int64(e.size),
+		ExpiresAt: e.expiresAt,
+	}
+}
+
+// compactKeydir 紧凑的有序索引
+// 键按顺序保存在若干个有序块中，查找时先二分定位块再在块内二分；迭代器记住上一个键，每一步重新定位
+type compactKeydir struct {
+	lock   sync.RWMutex
+	arena  *keyArena
+	chunks [][]compactEntry
+	length int
+}
+
+func newCompactKeydir() *compactKeydir {
+	return &compactKeydir{arena: newKeyArena()}
+}
+
+// keyOf 返回条目的键，调用方需持有锁
+func (kd *compactKeydir) keyOf(e *compactEntry) []byte {
+	return kd.arena.bytes(e.key)
+}
+
+// search 返回第一个不小于 key 的条目所在的块和位置，以及该条目的键是否等于 key，调用方需持有锁
+// 所有键都小于 key 时块的下标为 len(chunks)
+func (kd *compactKeydir) search(key string) (int, int, bool) {
+	ci := sort.Search(len(kd.chunks), func(i int) bool {
+		c := kd.chunks[i]
+		return string(kd.keyOf(&c[len(c)-1])) >= key
+	})
+	if ci == len(kd.chunks) {
+		return ci, 0, false
+	}
+	c := kd.chunks[ci]
+	pos := sort.Search(len(c), func(j int) bool {
+		return string(kd.keyOf(&c[j])) >= key
+	})
+	return ci, pos, pos < len(c) && string(kd.keyOf(&c[pos])) == key
+}
+
+func (kd *compactKeydir) Get(key string) (*EntryMetadata, bool) {
+	kd.lock.RLock()
+	defer kd.lock.RUnlock()
+
+	ci, pos, found := kd.search(key)
+	if !found {
+		return nil, false
+	}
+	return kd.chunks[ci][pos].meta(), true
+}
+
+func (kd *compactKeydir) Put(key string, meta *EntryMetadata) {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	ci, pos, found := kd.search(key)
+	if found {
+		e := &kd.chunks[ci][pos]
+		*e = packEntry(e.key, meta)
+		return
+	}
+
+	e := packEntry(kd.arena.alloc(key), meta)
+	kd.length++
+	if len(kd.chunks) == 0 {
+		kd.chunks = [][]compactEntry{{e}}
+		return
+	}
+	if ci == len(kd.chunks) {
+		ci = len(kd.chunks) - 1
+		pos = len(kd.chunks[ci])
+	}
+	c := append(kd.chunks[ci], compactEntry{})
+	copy(c[pos+1:], c[pos:])
+	c[pos] = e
+	kd.chunks[ci] = c
+
+	if len(c) >= 2*compactChunkSize {
+		left := append([]compactEntry(nil), c[:compactChunkSize]...)
+		right := append([]compactEntry(nil), c[compactChunkSize:]...)
+		kd.chunks = append(kd.chunks, nil)
+		copy(kd.chunks[ci+2:], kd.chunks[ci+1:])
+		kd.chunks[ci] = left
+		kd.chunks[ci+1] = right
+	}
+}
+
+func (kd *compactKeydir) Delete(key string) bool {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	ci, pos, found := kd.search(key)
+	if !found {
+		return false
+	}
+	c := kd.chunks[ci]
+	kd.arena.free(c[pos].key)
+	c = append(c[:pos], c[pos+1:]...)
+	if len(c) == 0 {
+		kd.chunks = append(kd.chunks[:ci], kd.chunks[ci+1:]...)
+	} else {
+		kd.chunks[ci] = c
+	}
+	kd.length--
+
+	if kd.arena.needsCompaction() {
+		kd.compactArena()
+	}
+	return true
+}
+
+// compactArena 将仍然有效的键复制到新的分配区，释放已删除的键占用的内存，调用方需持有写锁
+func (kd *compactKeydir) compactArena() {
+	arena := newKeyArena()
+	for _, c := range kd.chunks {
+		for i := range c {
+			c[i].key = arena.alloc(string(kd.keyOf(&c[i])))
+		}
+	}
+	kd.arena = arena
+}
+
+func (kd *compactKeydir) Len() int {
+	kd.lock.RLock()
+	defer kd.lock.RUnlock()
+
+	return kd.length
+}
+
+func (kd *compactKeydir) Size() int64 {
+	kd.lock.RLock()
+	defer kd.lock.RUnlock()
+
+	size := kd.arena.size() + int64(cap(kd.chunks))*24
+	for _, c := range kd.chunks {
+		size += int64(cap(c)) * 32
+	}
+	return size
+}
+
+func (kd *compactKeydir) KeyBytes() int64 {
+	kd.lock.RLock()
+	defer kd.lock.RUnlock()
+
+	return kd.arena.used
+}
+
+// after 返回第一个大于 key（inclusive 为 true 时为不小于）的条目，调用方需持有读锁
+func (kd *compactKeydir) after(key string, inclusive bool) (string, *EntryMetadata, bool) {
+	ci, pos, found := kd.search(key)
+	if found && !inclusive {
+		pos++
+	}
+	if ci < len(kd.chunks) && pos >= len(kd.chunks[ci]) {
+		ci, pos = ci+1, 0
+	}
+	if ci >= len(kd.chunks) {
+		return "", nil, false
+	}
+	e := &kd.chunks[ci][pos]
+	return string(kd.keyOf(e)), e.meta(), true
+}
+
+// before 返回最后一个小于 key（inclusive 为 true 时为不大于）的条目，调用方需持有读锁
+func (kd *compactKeydir) before(key string, inclusive bool) (string, *EntryMetadata, bool) {
+	ci, pos, found := kd.search(key)
+	if found && inclusive {
+		e := &kd.chunks[ci][pos]
+		return key, e.meta(), true
+	}
+	switch {
+	case ci == len(kd.chunks):
+		if ci == 0 {
+			return "", nil, false
+		}
+		ci--
+		pos = len(kd.chunks[ci]) - 1
+	case pos > 0:
+		pos--
+	case ci > 0:
+		ci--
+		pos = len(kd.chunks[ci]) - 1
+	default:
+		return "", nil, false
+	}
+	e := &kd.chunks[ci][pos]
+	return string(kd.keyOf(e)), e.meta(), true
+}
+
+// last 返回最后一个条目，调用方需持有读锁
+func (kd *compactKeydir) last() (string, *EntryMetadata, bool) {
+	if len(kd.chunks) == 0 {
+		return "", nil, false
+	}
+	c := kd.chunks[len(kd.chunks)-1]
+	e := &c[len(c)-1]
+	return string(kd.keyOf(e)), e.meta(), true
+}
+
+// walk 返回迭代器，first 定位第一个键，step 根据上一个键定位下一个键
+func (kd *compactKeydir) walk(first func() (string, *EntryMetadata, bool), step func(string) (string, *EntryMetadata, bool)) func() (string, *EntryMetadata, bool) {
+	started, done := false, false
+	var prev string
+	return func() (string, *EntryMetadata, bool) {
+		if done {
+			return "", nil, false
+		}
+		kd.lock.RLock()
+		defer kd.lock.RUnlock()
+
+		var key string
+		var meta *EntryMetadata
+		var ok bool
+		if started {
+			key, meta, ok = step(prev)
+		} else {
+			key, meta, ok = first()
+			started = true
+		}
+		if !ok {
+			done = true
+			return "", nil, false
+		}
+		prev = key
+		return key, meta, true
+	}
+}
+
+func (kd *compactKeydir) Iterator() func() (string, *EntryMetadata, bool) {
+	return kd.Seek("")
+}
+
+func (kd *compactKeydir) Seek(key string) func() (string, *EntryMetadata, bool) {
+	return kd.walk(func() (string, *EntryMetadata, bool) {
+		return kd.after(key, true)
+	}, func(prev string) (string, *EntryMetadata, bool) {
+		return kd.after(prev, false)
+	})
+}
+
+func (kd *compactKeydir) ReverseIterator() func() (string, *EntryMetadata, bool) {
+	return kd.walk(kd.last, func(prev string) (string, *EntryMetadata, bool) {
+		return kd.before(prev, false)
+	})
+}
+
+func (kd *compactKeydir) SeekReverse(key string) func() (string, *EntryMetadata, bool) {
+	return kd.walk(func() (string, *EntryMetadata, bool) {
+		return kd.before(key, true)
+	}, func(prev string) (string, *EntryMetadata, bool) {
+		return kd.before(prev, false)
+	})
+}
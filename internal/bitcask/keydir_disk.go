@@ -0,0 +1,542 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	diskIndexFileName     = "KEYDIR"
+	diskIndexMetaFileName = "KEYDIR_META"
+
+	diskIndexMagic      uint32 = 0x464b4958 // "FKIX"
+	diskIndexVersion    uint16 = 1
+	diskIndexHeaderSize        = 72
+
+	// DefaultIndexCacheSize 磁盘索引页缓存默认的最大字节数
+	DefaultIndexCacheSize = 8 << 20
+)
+
+// diskIndexHeader 磁盘索引的文件头，保存在第 0 页
+// 布局: checksum(4) + magic(4) + version(2) + flags(2) + pageSize(4) + root(8) + pageCount(8) + keys(8) + keyBytes(8) + generation(8) + fileID(8) + offset(8)
+type diskIndexHeader struct {
+	clean      bool // 文件中的页面与检查点一致，为 false 时打开需要重建索引
+	root       uint64
+	pageCount  uint64
+	keys       uint64
+	keyBytes   uint64
+	generation uint64 // 检查点的序号，与元数据文件中的序号相同时两者才属于同一个检查点
+	fileID     int64  // 检查点时的活跃文件
+	offset     int64  // 活跃文件中已经写入索引的位置
+}
+
+func (h *diskIndexHeader) encode() []byte {
+	buf := make([]byte, diskIndexHeaderSize)
+	binary.BigEndian.PutUint32(buf[4:8], diskIndexMagic)
+	binary.BigEndian.PutUint16(buf[8:10], diskIndexVersion)
+	if h.clean {
+		binary.BigEndian.PutUint16(buf[10:12], 1)
+	}
+	binary.BigEndian.PutUint32(buf[12:16], diskPageSize)
+	binary.BigEndian.PutUint64(buf[16:24], h.root)
+	binary.BigEndian.PutUint64(buf[24:32], h.pageCount)
+	binary.BigEndian.PutUint64(buf[32:40], h.keys)
+	binary.BigEndian.PutUint64(buf[40:48], h.keyBytes)
+	binary.BigEndian.PutUint64(buf[48:56], h.generation)
+	binary.BigEndian.PutUint64(buf[56:64], uint64(h.fileID))
+	binary.BigEndian.PutUint64(buf[64:72], uint64(h.offset))
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+func decodeIndexHeader(buf []byte) (*diskIndexHeader, error) {
+	if binary.BigEndian.Uint32(buf[0:4]) != crc32.ChecksumIEEE(buf[4:diskIndexHeaderSize]) ||
+		binary.BigEndian.Uint32(buf[4:8]) != diskIndexMagic ||
+		binary.BigEndian.Uint16(buf[8:10]) != diskIndexVersion ||
+		binary.BigEndian.Uint32(buf[12:16]) != diskPageSize {
+		return nil, ErrInvalidIndex
+	}
+	return &diskIndexHeader{
+		clean:      binary.BigEndian.Uint16(buf[10:12])&1 != 0,
+		root:       binary.BigEndian.Uint64(buf[16:24]),
+		pageCount:  binary.BigEndian.Uint64(buf[24:32]),
+		keys:       binary.BigEndian.Uint64(buf[32:40]),
+		keyBytes:   binary.BigEndian.Uint64(buf[40:48]),
+		generation: binary.BigEndian.Uint64(buf[48:56]),
+		fileID:     int64(binary.BigEndian.Uint64(buf[56:64])),
+		offset:     int64(binary.BigEndian.Uint64(buf[64:72])),
+	}, nil
+}
+
+// indexCheckpoint 磁盘索引的检查点，索引包含了检查点时所有数据文件中的记录
+type indexCheckpoint struct {
+	fileID int64
+	offset int64
+	files  map[int64]int64 // 检查点时的数据文件及其失效字节数
+	free   []uint64
+}
+
+// writeIndexMeta 写入检查点的元数据文件: checksum(4) + generation(8) + 文件数(4) + (fileID(8) + deadBytes(8))* + 空闲页数(4) + pageID(8)*
+// 先写入临时文件再重命名
+func writeIndexMeta(dir string, generation uint64, files map[int64]int64, free []uint64) error {
+	buf := make([]byte, 4+8+4+16*len(files)+4+8*len(free))
+	binary.BigEndian.PutUint64(buf[4:12], generation)
+	binary.BigEndian.PutUint32(buf[12:16], uint32(len(files)))
+	offset := 16
+	for fileID, dead := range files {
+		binary.BigEndian.PutUint64(buf[offset:], uint64(fileID))
+		binary.BigEndian.PutUint64(buf[offset+8:], uint64(dead))
+		offset += 16
+	}
+	binary.BigEndian.PutUint32(buf[offset:], uint32(len(free)))
+	offset += 4
+	for _, id := range free {
+		binary.BigEndian.PutUint64(buf[offset:], id)
+		offset += 8
+	}
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	filename := filepath.Join(dir, diskIndexMetaFileName)
+	tmpName := filename + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// readIndexMeta 读取检查点的元数据文件，序号与文件头不一致或文件损坏时返回 ErrInvalidIndex
+func readIndexMeta(dir string, generation uint64) (*indexCheckpoint, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, diskIndexMetaFileName))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < 20 || binary.BigEndian.Uint32(buf[0:4]) != crc32.ChecksumIEEE(buf[4:]) ||
+		binary.BigEndian.Uint64(buf[4:12]) != generation {
+		return nil, ErrInvalidIndex
+	}
+	count := int(binary.BigEndian.Uint32(buf[12:16]))
+	if len(buf) < 20+16*count {
+		return nil, ErrInvalidIndex
+	}
+	cp := &indexCheckpoint{files: make(map[int64]int64, count)}
+	offset := 16
+	for i := 0; i < count; i++ {
+		cp.files[int64(binary.BigEndian.Uint64(buf[offset:]))] = int64(binary.BigEndian.Uint64(buf[offset+8:]))
+		offset += 16
+	}
+	count = int(binary.BigEndian.Uint32(buf[offset:]))
+	offset += 4
+	if len(buf) != offset+8*count {
+		return nil, ErrInvalidIndex
+	}
+	for i := 0; i < count; i++ {
+		cp.free = append(cp.free, binary.BigEndian.Uint64(buf[offset:]))
+		offset += 8
+	}
+	return cp, nil
+}
+
+// diskKeydir 保存在数据目录中的 B+ 树索引，只有页缓存中的节点常驻内存，键的数量可以超过内存容量
+//
+// 索引在检查点时与数据文件保持一致：检查点写回所有脏页，记录活跃文件和已经写入索引的位置，并将文件头标记为干净
+// 检查点之后第一次写回页面前文件头被标记为未完成，此时崩溃会在下次打开时从数据文件重建索引；
+// 文件头干净时只需要从检查点位置开始重放之后写入的记录。只读模式下索引建立在临时文件中，关闭时删除
+type diskKeydir struct {
+	lock       sync.Mutex
+	dir        string
+	file       *os.File
+	tree       *bpTree
+	pages      int
+	temp       bool
+	clean      bool // 文件中的文件头是否标记为干净
+	generation uint64
+	restored   *indexCheckpoint // 打开时恢复的检查点，为 nil 时需要从所有数据文件重建索引
+	err        error            // 第一次读写索引文件失败的错误，之后的操作都返回该错误
+}
+
+// openDiskKeydir 打开数据目录中的磁盘索引，索引文件缺失、损坏或没有干净地关闭时创建一个空索引，由调用方从数据文件重建
+func openDiskKeydir(dir string, readWrite bool, cacheSize int64) (*diskKeydir, error) {
+	kd := &diskKeydir{dir: dir, pages: int(cacheSize / diskPageSize)}
+	if !readWrite {
+		file, err := os.CreateTemp("", "finnkv-keydir-*")
+		if err != nil {
+			return nil, err
+		}
+		kd.file, kd.temp = file, true
+		kd.reinit()
+		return kd, nil
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, diskIndexFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	kd.file = file
+	if err := kd.restore(); err != nil {
+		if err := kd.reset(); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+	}
+	return kd, nil
+}
+
+// restore 从干净的文件头和元数据文件恢复索引
+func (kd *diskKeydir) restore() error {
+	buf := make([]byte, diskIndexHeaderSize)
+	if _, err := kd.file.ReadAt(buf, 0); err != nil {
+		return err
+	}
+	header, err := decodeIndexHeader(buf)
+	if err != nil {
+		return err
+	}
+	if !header.clean {
+		return ErrInvalidIndex
+	}
+	cp, err := readIndexMeta(kd.dir, header.generation)
+	if err != nil {
+		return err
+	}
+	cp.fileID, cp.offset = header.fileID, header.offset
+
+	kd.tree = newBPTree(kd.file, kd.pages)
+	kd.tree.beforeWrite = kd.markDirty
+	kd.tree.root = header.root
+	kd.tree.pageCount = header.pageCount
+	kd.tree.length = int(header.keys)
+	kd.tree.keyBytes = int64(header.keyBytes)
+	kd.tree.free = cp.free
+	kd.clean = true
+	kd.generation = header.generation
+	kd.restored = cp
+	return nil
+}
+
+// reset 清空索引文件，之后由调用方从数据文件重建
+func (kd *diskKeydir) reset() error {
+	if err := kd.file.Truncate(0); err != nil {
+		return err
+	}
+	// 写入未完成的文件头，重建期间崩溃时下次打开不会误用旧的页面
+	header := &diskIndexHeader{}
+	if _, err := kd.file.WriteAt(header.encode(), 0); err != nil {
+		return err
+	}
+	if err := kd.file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(kd.dir, diskIndexMetaFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	kd.reinit()
+	return nil
+}
+
+// reinit 以一棵空树替换内存中的索引状态
+func (kd *diskKeydir) reinit() {
+	kd.tree = newBPTree(kd.file, kd.pages)
+	kd.tree.beforeWrite = kd.markDirty
+	kd.tree.init()
+	kd.clean = false
+	kd.restored = nil
+}
+
+// resume 检查打开时恢复的检查点是否与现有的数据文件一致，返回需要重放的起点，不一致时清空索引并返回 nil
+// 检查点之后合并过的文件（例如打开时完成的中断的合并）会使检查点失效
+func (kd *diskKeydir) resume(fileIDs []int64) (*indexCheckpoint, error) {
+	if kd == nil || kd.restored == nil {
+		return nil, nil
+	}
+	cp := kd.restored
+	kd.restored = nil
+
+	count := 0
+	valid := true
+	for _, fileID := range fileIDs {
+		if fileID > cp.fileID {
+			continue
+		}
+		if _, ok := cp.files[fileID]; !ok {
+			valid = false
+			break
+		}
+		count++
+	}
+	if valid && count == len(cp.files) {
+		info, err := os.Stat(dataFileName(kd.dir, cp.fileID))
+		if err == nil && info.Size() >= cp.offset {
+			return cp, nil
+		}
+	}
+	return nil, kd.reset()
+}
+
+// markDirty 在写回页面前将文件头标记为未完成，并同步到磁盘
+func (kd *diskKeydir) markDirty() error {
+	if !kd.clean {
+		return nil
+	}
+	header := &diskIndexHeader{generation: kd.generation}
+	if _, err := kd.file.WriteAt(header.encode(), 0); err != nil {
+		return err
+	}
+	if err := kd.file.Sync(); err != nil {
+		return err
+	}
+	kd.clean = false
+	return nil
+}
+
+// fail 记录第一次读写索引文件失败的错误，并尽量将文件头标记为未完成，下次打开时重建索引
+func (kd *diskKeydir) fail(err error) error {
+	if kd.err == nil {
+		kd.err = err
+		_ = kd.markDirty()
+	}
+	return kd.err
+}
+
+// checkpoint 写回所有脏页并记录检查点，fileID 和 offset 为活跃文件以及其中已经同步到磁盘的位置
+func (kd *diskKeydir) checkpoint(fileID, offset int64, files map[int64]int64) error {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	if kd.temp {
+		return nil
+	}
+	if kd.err != nil {
+		return kd.err
+	}
+	if err := kd.markDirty(); err != nil {
+		return kd.fail(err)
+	}
+	if err := kd.tree.flush(); err != nil {
+		return kd.fail(err)
+	}
+	if err := kd.file.Sync(); err != nil {
+		return kd.fail(err)
+	}
+	generation := kd.generation + 1
+	if err := writeIndexMeta(kd.dir, generation, files, kd.tree.free); err != nil {
+		return kd.fail(err)
+	}
+	header := &diskIndexHeader{
+		clean:      true,
+		root:       kd.tree.root,
+		pageCount:  kd.tree.pageCount,
+		keys:       uint64(kd.tree.length),
+		keyBytes:   uint64(kd.tree.keyBytes),
+		generation: generation,
+		fileID:     fileID,
+		offset:     offset,
+	}
+	if _, err := kd.file.WriteAt(header.encode(), 0); err != nil {
+		return kd.fail(err)
+	}
+	if err := kd.file.Sync(); err != nil {
+		return kd.fail(err)
+	}
+	kd.generation = generation
+	kd.clean = true
+	return nil
+}
+
+// failed 返回读写索引文件时发生的错误，没有启用磁盘索引时返回 nil
+func (kd *diskKeydir) failed() error {
+	if kd == nil {
+		return nil
+	}
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	return kd.err
+}
+
+// checkKey 检查键的长度是否超过磁盘索引的限制，没有启用磁盘索引时不限制
+func (kd *diskKeydir) checkKey(key []byte) error {
+	if kd != nil && len(key) > MaxDiskKeySize {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// close 关闭索引文件，只读模式下删除临时文件
+func (kd *diskKeydir) close() error {
+	if kd == nil {
+		return nil
+	}
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	err := kd.file.Close()
+	if kd.temp {
+		_ = os.Remove(kd.file.Name())
+	}
+	return err
+}
+
+func (kd *diskKeydir) Get(key string) (*EntryMetadata, bool) {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	if kd.err != nil {
+		return nil, false
+	}
+	meta, ok, err := kd.tree.get(key)
+	if err == nil {
+		err = kd.tree.evict()
+	}
+	if err != nil {
+		_ = kd.fail(err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	return &meta, true
+}
+
+func (kd *diskKeydir) Put(key string, meta *EntryMetadata) {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	if kd.err != nil {
+		return
+	}
+	_, err := kd.tree.put(key, *meta)
+	if err == nil {
+		err = kd.tree.evict()
+	}
+	if err != nil {
+		_ = kd.fail(err)
+	}
+}
+
+func (kd *diskKeydir) Delete(key string) bool {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	if kd.err != nil {
+		return false
+	}
+	deleted, err := kd.tree.delete(key)
+	if err == nil {
+		err = kd.tree.evict()
+	}
+	if err != nil {
+		_ = kd.fail(err)
+		return false
+	}
+	return deleted
+}
+
+func (kd *diskKeydir) Len() int {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	return kd.tree.length
+}
+
+// Size 返回页缓存占用的内存，不包括磁盘上的页面
+func (kd *diskKeydir) Size() int64 {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	return kd.tree.cacheSize()
+}
+
+func (kd *diskKeydir) KeyBytes() int64 {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	return kd.tree.keyBytes
+}
+
+// walk 在锁内遍历索引，出错时记录错误并停止遍历
+func (kd *diskKeydir) walk(fn func() error) {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	if kd.err != nil {
+		return
+	}
+	if err := fn(); err == nil {
+		err = kd.tree.evict()
+		if err != nil {
+			_ = kd.fail(err)
+		}
+	} else {
+		_ = kd.fail(err)
+	}
+}
+
+func (kd *diskKeydir) ascend(key string, fn func(string, *EntryMetadata) bool) {
+	kd.walk(func() error { return kd.tree.ascend(key, fn) })
+}
+
+func (kd *diskKeydir) descend(key string, fn func(string, *EntryMetadata) bool) {
+	kd.walk(func() error { return kd.tree.descend(key, false, fn) })
+}
+
+func (kd *diskKeydir) descendAll(fn func(string, *EntryMetadata) bool) {
+	kd.walk(func() error { return kd.tree.descend("", true, fn) })
+}
+
+func (kd *diskKeydir) Iterator() func() (string, *EntryMetadata, bool) {
+	return kd.Seek("")
+}
+
+func (kd *diskKeydir) Seek(key string) func() (string, *EntryMetadata, bool) {
+	return batchIterator(func(fn func(string, *EntryMetadata) bool) {
+		kd.ascend(key, fn)
+	}, kd.ascend)
+}
+
+func (kd *diskKeydir) ReverseIterator() func() (string, *EntryMetadata, bool) {
+	return batchIterator(kd.descendAll, kd.descend)
+}
+
+func (kd *diskKeydir) SeekReverse(key string) func() (string, *EntryMetadata, bool) {
+	return batchIterator(func(fn func(string, *EntryMetadata) bool) {
+		kd.descend(key, fn)
+	}, kd.descend)
+}
+
+// checkpointIndex 同步活跃文件并为磁盘索引写入检查点，没有启用磁盘索引时什么也不做，调用方需持有写锁
+func (bc *Bitcask) checkpointIndex() error {
+	if bc.disk == nil || bc.currFile == nil {
+		return nil
+	}
+	if err := bc.currFile.Sync(); err != nil {
+		return err
+	}
+	files := make(map[int64]int64)
+	iter := bc.dataFiles.Iterator()
+	for {
+		fileID, df, ok := iter()
+		if !ok {
+			break
+		}
+		files[fileID] = df.DeadBytes()
+	}
+	return bc.disk.checkpoint(bc.currFile.FileID, bc.currFile.WriteOff, files)
+}
@@ -0,0 +1,229 @@
+package bitcask
+
+import "sync"
+
+const (
+	hashInitialSlots = 16
+
+	slotEmpty   uint8 = 0
+	slotUsed    uint8 = 1
+	slotDeleted uint8 = 2
+)
+
+// hashSlot 开放寻址哈希表中的一个槽，共 40 字节
+type hashSlot struct {
+	entry compactEntry
+	hash  uint32
+	state uint8
+}
+
+// hashKeydir 紧凑的哈希索引，使用线性探测的开放寻址哈希表，键保存在分配区中
+// 不维护键的顺序：Iterator 的顺序不确定，范围扫描时需要收集并排序范围内的所有键
+type hashKeydir struct {
+	lock       sync.RWMutex
+	arena      *keyArena
+	slots      []hashSlot
+	length     int
+	tombstones int
+}
+
+func newHashKeydir() *hashKeydir {
+	return &hashKeydir{arena: newKeyArena()}
+}
+
+// hashString 计算键的 FNV-1a 哈希
+func hashString(key string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return h
+}
+
+// find 返回键所在的槽，不存在时返回可以插入的槽，调用方需持有锁且哈希表不为空
+func (kd *hashKeydir) find(key string, h uint32) (int, bool) {
+	mask := len(kd.slots) - 1
+	tombstone := -1
+	for i := int(h) & mask; ; i = (i + 1) & mask {
+		s := &kd.slots[i]
+		switch s.state {
+		case slotEmpty:
+			if tombstone >= 0 {
+				return tombstone, false
+			}
+			return i, false
+		case slotDeleted:
+			if tombstone < 0 {
+				tombstone = i
+			}
+		default:
+			if s.hash == h && string(kd.arena.bytes(s.entry.key)) == key {
+				return i, true
+			}
+		}
+	}
+}
+
+func (kd *hashKeydir) Get(key string) (*EntryMetadata, bool) {
+	kd.lock.RLock()
+	defer kd.lock.RUnlock()
+
+	if kd.length == 0 {
+		return nil, false
+	}
+	i, found := kd.find(key, hashString(key))
+	if !found {
+		return nil, false
+	}
+	return kd.slots[i].entry.meta(), true
+}
+
+func (kd *hashKeydir) Put(key string, meta *EntryMetadata) {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	if kd.slots == nil {
+		kd.slots = make([]hashSlot, hashInitialSlots)
+	}
+	h := hashString(key)
+	i, found := kd.find(key, h)
+	s := &kd.slots[i]
+	if found {
+		s.entry = packEntry(s.entry.key, meta)
+		return
+	}
+	if s.state == slotDeleted {
+		kd.tombstones--
+	}
+	*s = hashSlot{entry: packEntry(kd.arena.alloc(key), meta), hash: h, state: slotUsed}
+	kd.length++
+
+	// 已用和已删除的槽超过四分之三时扩容或原地重建
+	if (kd.length+kd.tombstones)*4 > len(kd.slots)*3 {
+		size := len(kd.slots)
+		if kd.length*2 > size {
+			size *= 2
+		}
+		kd.rehash(size)
+	}
+}
+
+func (kd *hashKeydir) Delete(key string) bool {
+	kd.lock.Lock()
+	defer kd.lock.Unlock()
+
+	if kd.length == 0 {
+		return false
+	}
+	i, found := kd.find(key, hashString(key))
+	if !found {
+		return false
+	}
+	s := &kd.slots[i]
+	kd.arena.free(s.entry.key)
+	s.state = slotDeleted
+	kd.tombstones++
+	kd.length--
+
+	if kd.arena.needsCompaction() {
+		kd.rehash(len(kd.slots))
+	}
+	return true
+}
+
+// rehash 以 size 个槽重建哈希表，清除删除标记，需要时同时重建分配区，调用方需持有写锁
+func (kd *hashKeydir) rehash(size int) {
+	arena := kd.arena
+	if arena.needsCompaction() {
+		arena = newKeyArena()
+	}
+	slots := make([]hashSlot, size)
+	mask := size - 1
+	for _, s := range kd.slots {
+		if s.state != slotUsed {
+			continue
+		}
+		if arena != kd.arena {
+			s.entry.key = arena.alloc(string(kd.arena.bytes(s.entry.key)))
+		}
+		i := int(s.hash) & mask
+		for slots[i].state != slotEmpty {
+			i = (i + 1) & mask
+		}
+		slots[i] = s
+	}
+	kd.slots = slots
+	kd.arena = arena
+	kd.tombstones = 0
+}
+
+func (kd *hashKeydir) Len() int {
+	kd.lock.RLock()
+	defer kd.lock.RUnlock()
+
+	return kd.length
+}
+
+func (kd *hashKeydir) Size() int64 {
+	kd.lock.RLock()
+	defer kd.lock.RUnlock()
+
+	return int64(len(kd.slots))*40 + kd.arena.size()
+}
+
+func (kd *hashKeydir) KeyBytes() int64 {
+	kd.lock.RLock()
+	defer kd.lock.RUnlock()
+
+	return kd.arena.used
+}
+
+// Iterator 按槽的顺序遍历，顺序不确定；遍历期间哈希表被重建时可能遗漏或重复返回键
+func (kd *hashKeydir) Iterator() func() (string, *EntryMetadata, bool) {
+	i := 0
+	return func() (string, *EntryMetadata, bool) {
+		kd.lock.RLock()
+		defer kd.lock.RUnlock()
+
+		for i < len(kd.slots) {
+			s := &kd.slots[i]
+			i++
+			if s.state == slotUsed {
+				return string(kd.arena.bytes(s.entry.key)), s.entry.meta(), true
+			}
+		}
+		return "", nil, false
+	}
+}
+
+// collect 收集满足 match 的所有键并排序
+func (kd *hashKeydir) collect(match func(key string) bool, reverse bool) []string {
+	kd.lock.RLock()
+	var keys []string
+	for i := range kd.slots {
+		s := &kd.slots[i]
+		if s.state != slotUsed {
+			continue
+		}
+		if key := string(kd.arena.bytes(s.entry.key)); match(key) {
+			keys = append(keys, key)
+		}
+	}
+	kd.lock.RUnlock()
+
+	sortKeys(keys, reverse)
+	return keys
+}
+
+func (kd *hashKeydir) Seek(key string) func() (string, *EntryMetadata, bool) {
+	return sortedIterator(kd, kd.collect(func(k string) bool { return k >= key }, false))
+}
+
+func (kd *hashKeydir) ReverseIterator() func() (string, *EntryMetadata, bool) {
+	return sortedIterator(kd, kd.collect(func(string) bool { return true }, true))
+}
+
+func (kd *hashKeydir) SeekReverse(key string) func() (string, *EntryMetadata, bool) {
+	return sortedIterator(kd, kd.collect(func(k string) bool { return k <= key }, true))
+}
@@ -0,0 +1,146 @@
+package bitcask
+
+import (
+	"sync/atomic"
+
+	"FinnKV/internal/algo"
+)
+
+const (
+	// btreeEntrySize B 树索引中每个键的估算开销：节点中的字符串头和元数据指针（按三分之二的填充率）以及堆上的 EntryMetadata
+	btreeEntrySize = (16+8)*3/2 + 48
+	// artEntrySize 自适应基数树索引中每个键的估算开销：叶子节点、叶子中的键值对、平摊的内部节点以及堆上的 EntryMetadata
+	artEntrySize = 88 + 24 + 32 + 48
+
+	// treeIteratorBatch 有序树索引的迭代器每次加锁遍历的键的数量
+	treeIteratorBatch = 64
+)
+
+// orderedTree 支持按范围遍历的有序树，algo.BTree 和 algo.ART 都满足该接口
+// 遍历期间树持有读锁，回调中不能修改树
+type orderedTree interface {
+	Get(key string) (*EntryMetadata, bool)
+	Put(key string, meta *EntryMetadata) bool
+	Delete(key string) bool
+	Len() int
+	Ascend(key string, fn func(key string, meta *EntryMetadata) bool)
+	Descend(key string, fn func(key string, meta *EntryMetadata) bool)
+	DescendAll(fn func(key string, meta *EntryMetadata) bool)
+}
+
+// treeKeydir 基于有序树的索引
+type treeKeydir struct {
+	tree      orderedTree
+	entrySize int64
+	keyBytes  int64
+}
+
+func newBTreeKeydir() *treeKeydir {
+	return &treeKeydir{
+		tree:      algo.NewBTree[string, *EntryMetadata](algo.BTreeDegree, func(a, b string) bool { return a < b }),
+		entrySize: btreeEntrySize,
+	}
+}
+
+func newARTKeydir() *treeKeydir {
+	return &treeKeydir{
+		tree:      algo.NewART[*EntryMetadata](),
+		entrySize: artEntrySize,
+	}
+}
+
+func (kd *treeKeydir) Get(key string) (*EntryMetadata, bool) {
+	return kd.tree.Get(key)
+}
+
+func (kd *treeKeydir) Put(key string, meta *EntryMetadata) {
+	if !kd.tree.Put(key, meta) {
+		atomic.AddInt64(&kd.keyBytes, int64(len(key)))
+	}
+}
+
+func (kd *treeKeydir) Delete(key string) bool {
+	if !kd.tree.Delete(key) {
+		return false
+	}
+	atomic.AddInt64(&kd.keyBytes, -int64(len(key)))
+	return true
+}
+
+func (kd *treeKeydir) Len() int {
+	return kd.tree.Len()
+}
+
+func (kd *treeKeydir) Size() int64 {
+	return int64(kd.tree.Len())*kd.entrySize + kd.KeyBytes()
+}
+
+func (kd *treeKeydir) KeyBytes() int64 {
+	return atomic.LoadInt64(&kd.keyBytes)
+}
+
+// batchIterator 返回分批遍历的迭代器：每批在树的锁内收集若干个键，下一批从上一批的最后一个键重新定位并跳过它
+// 这样迭代期间不会长时间持有树的锁，批与批之间的修改对迭代器可见
+func batchIterator(first func(fn func(string, *EntryMetadata) bool), next func(prev string, fn func(string, *EntryMetadata) bool)) func() (string, *EntryMetadata, bool) {
+	type item struct {
+		key  string
+		meta *EntryMetadata
+	}
+	var batch []item
+	var last string
+	started, done := false, false
+	return func() (string, *EntryMetadata, bool) {
+		if len(batch) == 0 && !done {
+			skip, full := started, false
+			batch = make([]item, 0, treeIteratorBatch)
+			collect := func(key string, meta *EntryMetadata) bool {
+				if skip {
+					skip = false
+					if key == last {
+						return true
+					}
+				}
+				batch = append(batch, item{key, meta})
+				full = len(batch) == treeIteratorBatch
+				return !full
+			}
+			if started {
+				next(last, collect)
+			} else {
+				first(collect)
+				started = true
+			}
+			// 批没有填满说明树已经遍历完
+			done = !full
+			if full {
+				last = batch[len(batch)-1].key
+			}
+		}
+		if len(batch) == 0 {
+			return "", nil, false
+		}
+		it := batch[0]
+		batch = batch[1:]
+		return it.key, it.meta, true
+	}
+}
+
+func (kd *treeKeydir) Iterator() func() (string, *EntryMetadata, bool) {
+	return kd.Seek("")
+}
+
+func (kd *treeKeydir) Seek(key string) func() (string, *EntryMetadata, bool) {
+	return batchIterator(func(fn func(string, *EntryMetadata) bool) {
+		kd.tree.Ascend(key, fn)
+	}, kd.tree.Ascend)
+}
+
+func (kd *treeKeydir) ReverseIterator() func() (string, *EntryMetadata, bool) {
+	return batchIterator(kd.tree.DescendAll, kd.tree.Descend)
+}
+
+func (kd *treeKeydir) SeekReverse(key string) func() (string, *EntryMetadata, bool) {
+	return batchIterator(func(fn func(string, *EntryMetadata) bool) {
+		kd.tree.Descend(key, fn)
+	}, kd.tree.Descend)
+}
@@ -0,0 +1,80 @@
+package bitcask
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+// appliedLSNFileName 记录已经应用并持久化的最大日志序列号的文件
+const appliedLSNFileName = "APPLIED_LSN"
+
+// AppliedLSN 返回已经应用到存储中的最大日志序列号
+func (bc *Bitcask) AppliedLSN() uint64 {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	return bc.appliedLSN
+}
+
+// SetAppliedLSN 记录不大于 lsn 的日志记录都已经写入存储
+// 序列号在下一次 Sync 或 Close 同步数据之后才会持久化，保证持久化的序列号不会超过已经落盘的数据
+func (bc *Bitcask) SetAppliedLSN(lsn uint64) {
+	bc.Lock()
+	defer bc.Unlock()
+
+	if lsn > bc.appliedLSN {
+		bc.appliedLSN = lsn
+	}
+}
+
+// persistAppliedLSN 在数据同步之后持久化日志序列号，调用方需持有写锁
+func (bc *Bitcask) persistAppliedLSN() error {
+	if bc.appliedLSN == bc.persistedLSN {
+		return nil
+	}
+	if err := writeAppliedLSN(bc.dir, bc.appliedLSN); err != nil {
+		return err
+	}
+	bc.persistedLSN = bc.appliedLSN
+	return nil
+}
+
+// readAppliedLSN 读取持久化的日志序列号，文件不存在或损坏时返回 0，之后会重放所有日志
+func readAppliedLSN(dir string) uint64 {
+	data, err := os.ReadFile(filepath.Join(dir, appliedLSNFileName))
+	if err != nil || len(data) != 12 || binary.BigEndian.Uint32(data[0:4]) != crc32.ChecksumIEEE(data[4:]) {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data[4:])
+}
+
+// writeAppliedLSN 持久化日志序列号，先写入临时文件再重命名
+func writeAppliedLSN(dir string, lsn uint64) error {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint64(buf[4:], lsn)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	filename := filepath.Join(dir, appliedLSNFileName)
+	tmpName := filename + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
@@ -0,0 +1,483 @@
+package bitcask
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	mergeDirName    = "merge"        // 合并过程中写入新数据文件的子目录
+	mergeCommitName = "MERGE_COMMIT" // 合并完成的标记文件，记录被合并的数据文件 ID
+)
+
+// relocation 记录合并时被移动的一条记录，meta 为 nil 表示记录已过期，需要从索引中删除
+type relocation struct {
+	key    string
+	oldFID int64
+	oldOff int64
+	meta   *EntryMetadata
+}
+
+// mergeWriter 将合并后的记录写入合并目录，按 MaxFileSize 切分数据文件
+type mergeWriter struct {
+	dir     string
+	maxSize int64
+	nextID  int64
+	lastID  int64 // 预留的最大文件 ID
+	curr    *DataFile
+	hints   []*hintRecord
+	files   []*DataFile
+
+	version         uint16
+	codec           Compression
+	compressMinSize int
+}
+
+// write 写入一条记录并返回它在合并后文件中的元数据
+func (w *mergeWriter) write(entry *Entry) (*EntryMetadata, error) {
+	if w.curr == nil || w.curr.WriteOff >= w.maxSize {
+		if err := w.seal(); err != nil {
+			return nil, err
+		}
+		if w.nextID > w.lastID {
+			return nil, errors.New("merge ran out of reserved file ids")
+		}
+		df, err := newDataFile(w.dir, w.nextID, true, w.version)
+		if err != nil {
+			return nil, err
+		}
+		df.setCompression(w.codec, w.compressMinSize)
+		w.nextID++
+		w.curr = df
+		w.files = append(w.files, df)
+	}
+
+	offset, err := w.curr.Write(entry)
+	if err != nil {
+		return nil, err
+	}
+	meta := &EntryMetadata{
+		FileID:    w.curr.FileID,
+		Offset:    offset,
+		Size:      w.curr.WriteOff - offset,
+		Timestamp: entry.Timestamp,
+		ExpiresAt: entry.ExpiresAt,
+	}
+	w.hints = append(w.hints, &hintRecord{Key: entry.Key, Type: entry.Type, Meta: *meta})
+	return meta, nil
+}
+
+// seal 同步当前文件并写入 hint 文件
+func (w *mergeWriter) seal() error {
+	if w.curr == nil {
+		return nil
+	}
+	if err := w.curr.Sync(); err != nil {
+		return err
+	}
+	if err := writeHintFile(w.dir, w.curr.FileID, w.hints); err != nil {
+		return err
+	}
+	w.curr = nil
+	w.hints = nil
+	return nil
+}
+
+// close 关闭所有合并后的文件
+func (w *mergeWriter) close() {
+	for _, df := range w.files {
+		_ = df.Close()
+	}
+}
+
+// Merge 在后台合并所有封存的数据文件，清理冗余数据
+// 合并期间只在开始和结束时短暂持有写锁，Put 和 Get 可以正常进行
+func (bc *Bitcask) Merge() error {
+	return bc.merge(nil)
+}
+
+// merge 合并数据文件，selectFn 为 nil 时合并所有封存的数据文件
+// 否则只合并 selectFn 从封存文件中挑选出来的文件
+func (bc *Bitcask) merge(selectFn func(sealed []*DataFile) []*DataFile) error {
+	if !bc.options.ReadWrite {
+		return errors.New("bitcask is read-only")
+	}
+	if !bc.mergeLock.TryLock() {
+		return ErrMergeInProgress
+	}
+	defer bc.mergeLock.Unlock()
+	if atomic.LoadInt32(&bc.closed) == 1 {
+		return ErrClosed
+	}
+
+	inputs, retained, writer, err := bc.prepareMerge(selectFn)
+	if err != nil || len(inputs) == 0 {
+		return err
+	}
+
+	mergeDir := filepath.Join(bc.dir, mergeDirName)
+	relocations, err := bc.rewrite(inputs, retained, writer)
+	writer.close()
+	if err != nil {
+		_ = os.RemoveAll(mergeDir)
+		return err
+	}
+	if err := writeMergeCommit(mergeDir, inputs); err != nil {
+		_ = os.RemoveAll(mergeDir)
+		return err
+	}
+
+	bc.Lock()
+	defer bc.Unlock()
+	return bc.installMerge(inputs, writer, relocations)
+}
+
+// prepareMerge 在写锁内封存活跃文件（为空时直接替换），挑选要合并的文件，并为合并后的文件预留文件 ID
+// 预留的 ID 大于所有封存文件、小于新的活跃文件，保证重建索引时的先后顺序不变
+// 同时返回没有参与合并的最早的文件 ID，所有文件都参与合并时为 math.MaxInt64
+func (bc *Bitcask) prepareMerge(selectFn func(sealed []*DataFile) []*DataFile) ([]*DataFile, int64, *mergeWriter, error) {
+	bc.Lock()
+	defer bc.Unlock()
+
+	mergeDir := filepath.Join(bc.dir, mergeDirName)
+	if err := os.RemoveAll(mergeDir); err != nil {
+		return nil, 0, nil, err
+	}
+
+	var sealed []*DataFile
+	iter := bc.dataFiles.Iterator()
+	for {
+		_, df, ok := iter()
+		if !ok {
+			break
+		}
+		sealed = append(sealed, df)
+	}
+
+	// 空的活跃文件不参与合并，稍后直接由新的活跃文件替换
+	empty := bc.currFile.WriteOff == bc.currFile.dataStart
+	inputs := sealed
+	if selectFn != nil {
+		// 活跃文件不参与挑选，没有文件头的旧格式文件总是参与合并，重写为当前格式
+		inputs = withLegacy(selectFn(sealed[:len(sealed)-1]), sealed[:len(sealed)-1])
+	} else if empty {
+		inputs = sealed[:len(sealed)-1]
+	}
+	if len(inputs) == 0 {
+		return nil, 0, nil, nil
+	}
+	chosen := make(map[int64]bool, len(inputs))
+	for _, df := range inputs {
+		chosen[df.FileID] = true
+	}
+	retained := int64(math.MaxInt64)
+	for _, df := range sealed {
+		if !chosen[df.FileID] {
+			retained = df.FileID
+			break
+		}
+	}
+
+	var totalSize int64
+	for _, df := range inputs {
+		totalSize += df.WriteOff
+	}
+	reserved := totalSize/bc.options.MaxFileSize + 2
+
+	if err := os.MkdirAll(mergeDir, 0755); err != nil {
+		return nil, 0, nil, err
+	}
+	writer := &mergeWriter{
+		dir:     mergeDir,
+		maxSize: bc.options.MaxFileSize,
+		nextID:  bc.maxFileID + 1,
+		lastID:  bc.maxFileID + reserved,
+
+		version:         bc.options.FormatVersion,
+		codec:           bc.options.Compression,
+		compressMinSize: bc.options.CompressMinSize,
+	}
+	nextID := bc.maxFileID + reserved + 1
+	seal := bc.sealActiveFile
+	if empty {
+		seal = bc.replaceActiveFile
+	}
+	if err := seal(nextID); err != nil {
+		return nil, 0, nil, err
+	}
+	return inputs, retained, writer, nil
+}
+
+// withLegacy 将旧格式的文件加入挑选出的文件，按文件 ID 排序返回
+func withLegacy(selected, sealed []*DataFile) []*DataFile {
+	chosen := make(map[int64]bool, len(selected))
+	for _, df := range selected {
+		chosen[df.FileID] = true
+	}
+	added := false
+	for _, df := range sealed {
+		if df.Legacy() && !chosen[df.FileID] {
+			selected = append(selected, df)
+			added = true
+		}
+	}
+	if added {
+		sort.Slice(selected, func(i, j int) bool {
+			return selected[i].FileID < selected[j].FileID
+		})
+	}
+	return selected
+}
+
+// rewrite 将输入文件中仍然有效的记录写入合并目录，不持有 Bitcask 的锁
+// 比 retained 更早的输入文件之前没有保留下来的文件，其中的删除标记和过期的记录可以直接丢弃
+// 其余的删除标记仍然要遮蔽 retained 等更早的文件中的旧版本，因此保留最新的删除标记，并将过期的记录改写为删除标记
+func (bc *Bitcask) rewrite(inputs []*DataFile, retained int64, writer *mergeWriter) ([]*relocation, error) {
+	var relocations []*relocation
+	tombstones := make(map[string]struct{})
+	now := time.Now().UnixNano()
+
+	for _, df := range inputs {
+		records, err := readHintFile(bc.dir, df.FileID, df.dataStart, df.WriteOff)
+		if err != nil {
+			records, err = bc.buildIndex(df)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range records {
+			if atomic.LoadInt32(&bc.closed) == 1 {
+				return nil, ErrClosed
+			}
+			key := string(r.Key)
+			curr, live := bc.index.Get(key)
+			live = live && curr.FileID == r.Meta.FileID && curr.Offset == r.Meta.Offset
+			if r.Type == EntryTypePut && live && curr.Expired(now) {
+				relocations = append(relocations, &relocation{key: key, oldFID: r.Meta.FileID, oldOff: r.Meta.Offset})
+			}
+
+			switch {
+			case r.Type == EntryTypePut && live && !curr.Expired(now):
+				// 压缩过的值不解压，合并后的文件不会比输入更大，预留的文件 ID 总是足够
+				entry, err := df.readRaw(r.Meta.Offset, r.Meta.Size)
+				if err != nil {
+					return nil, err
+				}
+				meta, err := writer.write(entry)
+				if err != nil {
+					return nil, err
+				}
+				relocations = append(relocations, &relocation{
+					key:    key,
+					oldFID: r.Meta.FileID,
+					oldOff: r.Meta.Offset,
+					meta:   meta,
+				})
+			case r.Meta.FileID < retained:
+				// 没有更早的文件可能保存这个键的旧版本，删除标记和过期的记录都可以丢弃
+			case r.Type == EntryTypeDelete && curr == nil, r.Type == EntryTypePut && live:
+				if _, ok := tombstones[key]; ok {
+					continue
+				}
+				tombstones[key] = struct{}{}
+				// 保留的删除标记仍然有用，不计入失效字节数，避免合并后的文件被反复挑选
+				if _, err := writer.write(&Entry{
+					Key:       r.Key,
+					Value:     []byte{},
+					Timestamp: r.Meta.Timestamp,
+					Type:      EntryTypeDelete,
+				}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err := writer.seal(); err != nil {
+		return nil, err
+	}
+	return relocations, nil
+}
+
+// installMerge 在写锁内用合并后的文件替换输入文件，并更新内存索引
+// 调用前合并目录中已经写入了标记文件，中途崩溃时下次打开会继续完成替换
+func (bc *Bitcask) installMerge(inputs []*DataFile, writer *mergeWriter, relocations []*relocation) error {
+	mergeDir := filepath.Join(bc.dir, mergeDirName)
+	for _, out := range writer.files {
+		if err := moveMergedFile(mergeDir, bc.dir, out.FileID); err != nil {
+			return err
+		}
+		df, err := NewDataFile(bc.dir, out.FileID, false)
+		if err != nil {
+			return err
+		}
+		if err := bc.mapFile(df); err != nil {
+			_ = df.Close()
+			return err
+		}
+		bc.dataFiles.Add(out.FileID, df)
+	}
+
+	// 只更新在合并期间没有被覆盖或删除的键，其余被移动的记录在合并后的文件中已经失效
+	for _, r := range relocations {
+		curr, ok := bc.index.Get(r.key)
+		if !ok || curr.FileID != r.oldFID || curr.Offset != r.oldOff {
+			if r.meta != nil {
+				bc.markDead(r.meta)
+			}
+			continue
+		}
+		if r.meta == nil {
+			bc.index.Delete(r.key)
+		} else {
+			bc.index.Put(r.key, r.meta)
+		}
+		bc.cache.invalidate([]byte(r.key))
+	}
+
+	for _, df := range inputs {
+		bc.dataFiles.Del(df.FileID)
+		if err := df.Close(); err != nil {
+			return err
+		}
+		if err := removeDataFile(bc.dir, df.FileID); err != nil {
+			return err
+		}
+	}
+	// 磁盘索引的检查点记录合并后的文件，合并目录删除前崩溃时检查点与重新完成的合并一致
+	if err := bc.checkpointIndex(); err != nil {
+		return err
+	}
+	return os.RemoveAll(mergeDir)
+}
+
+// writeMergeCommit 写入合并完成的标记文件
+func writeMergeCommit(mergeDir string, inputs []*DataFile) error {
+	var sb strings.Builder
+	for _, df := range inputs {
+		sb.WriteString(strconv.FormatInt(df.FileID, 10))
+		sb.WriteByte('\n')
+	}
+	filename := filepath.Join(mergeDir, mergeCommitName)
+	tmpName := filename + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(sb.String()); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	return syncDir(mergeDir)
+}
+
+// readMergeCommit 读取标记文件中记录的被合并的数据文件 ID
+func readMergeCommit(mergeDir string) ([]int64, error) {
+	file, err := os.Open(filepath.Join(mergeDir, mergeCommitName))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var fileIDs []int64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fileID, err := strconv.ParseInt(scanner.Text(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid merge commit file: %w", err)
+		}
+		fileIDs = append(fileIDs, fileID)
+	}
+	return fileIDs, scanner.Err()
+}
+
+// recoverMerge 在加载数据文件之前处理上次未完成的合并
+// 标记文件存在时继续完成替换，否则丢弃合并目录中写了一半的文件
+func (bc *Bitcask) recoverMerge() error {
+	mergeDir := filepath.Join(bc.dir, mergeDirName)
+	if _, err := os.Stat(mergeDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	inputs, err := readMergeCommit(mergeDir)
+	if os.IsNotExist(err) {
+		return os.RemoveAll(mergeDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	files, err := os.ReadDir(mergeDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), dataFileSuffix) {
+			continue
+		}
+		fileID, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), dataFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		if err := moveMergedFile(mergeDir, bc.dir, fileID); err != nil {
+			return err
+		}
+	}
+	for _, fileID := range inputs {
+		if err := removeDataFile(bc.dir, fileID); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(mergeDir)
+}
+
+// moveMergedFile 将合并目录中的数据文件和 hint 文件移动到数据目录
+func moveMergedFile(mergeDir, dir string, fileID int64) error {
+	if err := os.Rename(hintFileName(mergeDir, fileID), hintFileName(dir, fileID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(dataFileName(mergeDir, fileID), dataFileName(dir, fileID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeDataFile 删除数据文件及其 hint 文件，文件不存在时忽略
+func removeDataFile(dir string, fileID int64) error {
+	if err := removeHintFile(dir, fileID); err != nil {
+		return err
+	}
+	err := os.Remove(dataFileName(dir, fileID))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// syncDir 同步目录，保证目录项的修改落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
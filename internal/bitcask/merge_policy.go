@@ -0,0 +1,137 @@
+package bitcask
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"FinnKV/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// MergePolicy 自动合并策略
+// 后台定期检查封存文件中失效数据的占比，只合并占比最高的若干文件
+type MergePolicy struct {
+	DeadRatio   float64       // 失效数据占比达到该阈值的文件才会参与合并
+	MinFiles    int           // 达到阈值的文件数不少于该值时才触发合并
+	MaxFiles    int           // 单次合并最多处理的文件数，0 表示不限制
+	Interval    time.Duration // 检查的时间间隔
+	WindowStart int           // 允许合并的时间窗口起点（一天中的小时，包含）
+	WindowEnd   int           // 允许合并的时间窗口终点（一天中的小时，不包含），与起点相等表示不限制
+}
+
+// DefaultMergePolicy 返回默认的自动合并策略
+func DefaultMergePolicy() MergePolicy {
+	return MergePolicy{
+		DeadRatio: 0.5,
+		MinFiles:  1,
+		MaxFiles:  8,
+		Interval:  time.Minute,
+	}
+}
+
+// inWindow 返回 t 是否在允许合并的时间窗口内，窗口可以跨越午夜
+func (p *MergePolicy) inWindow(t time.Time) bool {
+	if p.WindowStart == p.WindowEnd {
+		return true
+	}
+	hour := t.Hour()
+	if p.WindowStart < p.WindowEnd {
+		return hour >= p.WindowStart && hour < p.WindowEnd
+	}
+	return hour >= p.WindowStart || hour < p.WindowEnd
+}
+
+// selectFiles 挑选失效数据占比达到阈值的封存文件，按占比从高到低排列
+func (p *MergePolicy) selectFiles(sealed []*DataFile) []*DataFile {
+	var candidates []*DataFile
+	for _, df := range sealed {
+		if df.WriteOff > 0 && df.DeadRatio() >= p.DeadRatio {
+			candidates = append(candidates, df)
+		}
+	}
+	if len(candidates) == 0 || len(candidates) < p.MinFiles {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].DeadRatio() > candidates[j].DeadRatio()
+	})
+	if p.MaxFiles > 0 && len(candidates) > p.MaxFiles {
+		candidates = candidates[:p.MaxFiles]
+	}
+	// 合并时按文件 ID 顺序处理
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].FileID < candidates[j].FileID
+	})
+	return candidates
+}
+
+// FileStat 数据文件的空间使用情况
+type FileStat struct {
+	FileID    int64
+	Size      int64
+	DeadBytes int64
+	Version   uint16 // 数据文件的格式版本，0 表示没有文件头的旧格式
+}
+
+// FileStats 返回所有数据文件的空间使用情况
+func (bc *Bitcask) FileStats() []FileStat {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	var stats []FileStat
+	iter := bc.dataFiles.Iterator()
+	for {
+		_, df, ok := iter()
+		if !ok {
+			break
+		}
+		stats = append(stats, FileStat{
+			FileID:    df.FileID,
+			Size:      df.WriteOff,
+			DeadBytes: df.DeadBytes(),
+			Version:   df.Version,
+		})
+	}
+	return stats
+}
+
+// MergeByPolicy 按照自动合并策略挑选文件并合并，没有需要合并的文件时直接返回
+func (bc *Bitcask) MergeByPolicy(policy MergePolicy) error {
+	return bc.merge(policy.selectFiles)
+}
+
+// startAutoMerge 启动后台自动合并
+func (bc *Bitcask) startAutoMerge(policy MergePolicy) {
+	bc.stopMerge = make(chan struct{})
+	bc.mergeDone = make(chan struct{})
+	go func() {
+		defer close(bc.mergeDone)
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bc.stopMerge:
+				return
+			case now := <-ticker.C:
+				if !policy.inWindow(now) {
+					continue
+				}
+				err := bc.MergeByPolicy(policy)
+				if err != nil && !errors.Is(err, ErrMergeInProgress) && !errors.Is(err, ErrClosed) {
+					logger.Error("auto merge failed", zap.String("dir", bc.dir), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// stopAutoMerge 停止后台自动合并并等待其退出
+func (bc *Bitcask) stopAutoMerge() {
+	if bc.stopMerge == nil {
+		return
+	}
+	close(bc.stopMerge)
+	<-bc.mergeDone
+	bc.stopMerge = nil
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package bitcask
+
+// mmap 在不支持 mmap 的平台上不映射，读取回退到 pread
+func mmap(df *DataFile, size int64) ([]byte, error) {
+	return nil, nil
+}
+
+// munmap 解除内存映射
+func munmap(data []byte) error {
+	return nil
+}
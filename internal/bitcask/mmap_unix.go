@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package bitcask
+
+import "syscall"
+
+// mmap 将数据文件的 [0, size) 以只读方式映射到内存
+func mmap(df *DataFile, size int64) ([]byte, error) {
+	return syscall.Mmap(int(df.File.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+// munmap 解除内存映射
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -3,16 +3,36 @@ package bitcask
 type Option func(*Options)
 
 type Options struct {
-	ReadWrite   bool
-	SyncOnPut   bool
-	MaxFileSize int64
+	ReadWrite    bool
+	SyncOnPut    bool
+	MaxFileSize  int64
+	RecoveryMode RecoveryMode
+	MergePolicy  *MergePolicy
+	CacheSize    int64 // 值缓存的最大字节数，0 表示不启用缓存
+	CacheK       int   // 值缓存使用的 LRU-K 中的 K
+
+	Compression     Compression // 写入数据文件时值的压缩算法
+	CompressMinSize int         // 值不小于该大小时才会压缩
+
+	MMap bool // 将封存的数据文件映射到内存，读取时不需要系统调用
+
+	IndexMode      IndexMode // 内存索引的实现方式
+	IndexCacheSize int64     // 磁盘索引页缓存的最大字节数
+
+	FormatVersion uint16       // 新数据文件使用的格式版本
+	ScrubPolicy   *ScrubPolicy // 后台校验策略，nil 表示不启用
 }
 
 func defaultOptions() *Options {
 	return &Options{
-		ReadWrite:   false,
-		SyncOnPut:   false,
-		MaxFileSize: 2 << 20, // 2 MB
+		ReadWrite:    false,
+		SyncOnPut:    false,
+		MaxFileSize:  2 << 20, // 2 MB
+		RecoveryMode: RecoveryModeNone,
+
+		CompressMinSize: DefaultCompressMinSize,
+		IndexCacheSize:  DefaultIndexCacheSize,
+		FormatVersion:   DataFileVersion,
 	}
 }
 
@@ -33,3 +53,88 @@ func WithMaxFileSize(size int64) Option {
 		opts.MaxFileSize = size
 	}
 }
+
+func WithRecoveryMode(mode RecoveryMode) Option {
+	return func(opts *Options) {
+		opts.RecoveryMode = mode
+	}
+}
+
+func WithMergePolicy(policy MergePolicy) Option {
+	return func(opts *Options) {
+		if policy.Interval <= 0 {
+			policy.Interval = DefaultMergePolicy().Interval
+		}
+		opts.MergePolicy = &policy
+	}
+}
+
+// WithValueCache 启用基于 LRU-K 的值缓存，size 为缓存的最大字节数
+func WithValueCache(size int64, k int) Option {
+	return func(opts *Options) {
+		if k <= 0 {
+			k = DefaultCacheK
+		}
+		opts.CacheSize = size
+		opts.CacheK = k
+	}
+}
+
+// WithCompression 写入数据文件时压缩不小于 minSize 的值，minSize 不大于 0 时使用 DefaultCompressMinSize
+// 压缩只影响新写入的记录，已有的数据文件无论是否压缩都可以读取
+func WithCompression(codec Compression, minSize int) Option {
+	return func(opts *Options) {
+		if minSize <= 0 {
+			minSize = DefaultCompressMinSize
+		}
+		opts.Compression = codec
+		opts.CompressMinSize = minSize
+	}
+}
+
+// WithMMap 将封存的数据文件映射到内存，Get 和 Fold 从映射中读取，View 可以不复制地访问值
+// 活跃文件仍然通过 pread 读取；映射在合并替换文件和关闭时解除
+func WithMMap() Option {
+	return func(opts *Options) {
+		opts.MMap = true
+	}
+}
+
+// WithIndexMode 选择内存索引的实现方式，键很多时可以使用 IndexCompact 或 IndexHash 减少内存占用
+// 这两种模式用 32 位保存记录的位置，MaxFileSize 不能超过 2GB；IndexBTree 和 IndexART 与默认的跳表一样保存完整的元数据
+func WithIndexMode(mode IndexMode) Option {
+	return func(opts *Options) {
+		opts.IndexMode = mode
+	}
+}
+
+// WithDiskIndex 使用保存在数据目录中的 B+ 树索引，cacheSize 为页缓存的最大字节数，不大于 0 时使用 DefaultIndexCacheSize
+// 索引在 Sync、Close 和合并完成时写入检查点，崩溃后从检查点重放之后的记录，索引文件丢失或损坏时从数据文件重建
+// 键的长度不能超过 MaxDiskKeySize；只读模式下索引建立在临时文件中
+func WithDiskIndex(cacheSize int64) Option {
+	return func(opts *Options) {
+		if cacheSize <= 0 {
+			cacheSize = DefaultIndexCacheSize
+		}
+		opts.IndexMode = IndexDisk
+		opts.IndexCacheSize = cacheSize
+	}
+}
+
+// WithFormatVersion 指定新数据文件使用的格式版本，只能是 1 到 DataFileVersion
+// 版本 1 的记录使用 IEEE 校验和，可以被旧版本的程序读取；默认的版本 2 使用更快的 CRC32C
+func WithFormatVersion(version uint16) Option {
+	return func(opts *Options) {
+		opts.FormatVersion = version
+	}
+}
+
+// WithScrubber 启用后台校验，按照策略定期以限定的速率读取封存文件并校验每一条记录
+func WithScrubber(policy ScrubPolicy) Option {
+	return func(opts *Options) {
+		if policy.Interval <= 0 {
+			policy.Interval = DefaultScrubPolicy().Interval
+		}
+		opts.ScrubPolicy = &policy
+	}
+}
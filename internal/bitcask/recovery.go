@@ -0,0 +1,148 @@
+package bitcask
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// quarantineDir 存放被隔离的损坏数据文件的子目录
+const quarantineDir = "quarantine"
+
+// RecoveryMode 打开时遇到损坏数据文件的处理方式
+type RecoveryMode int
+
+const (
+	RecoveryModeNone     RecoveryMode = iota // 遇到损坏的记录直接返回错误
+	RecoveryModeTruncate                     // 截断活跃文件尾部不完整或损坏的记录
+	RecoveryModeStrict                       // 截断活跃文件尾部，并隔离损坏的封存文件
+)
+
+// CorruptionError 表示数据文件中从 Offset 开始的记录不完整或已损坏
+type CorruptionError struct {
+	FileID int64
+	Offset int64
+	Size   int64 // 损坏范围的字节数，打开时为从 Offset 到文件末尾的字节数
+	Err    error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("data file %d corrupted at offset %d: %v", e.FileID, e.Offset, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+// TruncatedFile 记录被截断的活跃文件尾部
+type TruncatedFile struct {
+	FileID       int64
+	Offset       int64 // 截断后的文件大小
+	DroppedBytes int64 // 被丢弃的字节数
+	Applied      bool  // 只读模式下不会修改文件，仅在索引中忽略尾部
+	Err          error // 导致截断的原因
+}
+
+// QuarantinedFile 记录被隔离的封存文件
+type QuarantinedFile struct {
+	FileID int64
+	Path   string // 隔离后的文件路径，只读模式下为原路径
+	Offset int64  // 第一条损坏记录的偏移量
+	Err    error
+
+	// ResurrectedKeys 在被隔离的文件中被删除或覆盖、现在却读到更早版本的键
+	// 被删除的键重新出现，被覆盖的键回到旧值；只包含损坏位置之前能读取的记录
+	ResurrectedKeys [][]byte
+}
+
+// RecoveryReport 打开 Bitcask 时的恢复结果
+type RecoveryReport struct {
+	TruncatedFiles   []TruncatedFile
+	QuarantinedFiles []QuarantinedFile
+}
+
+// Clean 返回本次打开是否没有丢弃任何数据
+func (r RecoveryReport) Clean() bool {
+	return len(r.TruncatedFiles) == 0 && len(r.QuarantinedFiles) == 0
+}
+
+// RecoveryReport 返回打开时的恢复结果
+func (bc *Bitcask) RecoveryReport() RecoveryReport {
+	bc.RLock()
+	defer bc.RUnlock()
+	return *bc.report
+}
+
+// truncateTail 截断活跃文件中从损坏记录开始的尾部
+func (bc *Bitcask) truncateTail(df *DataFile, corruptErr *CorruptionError) error {
+	truncated := TruncatedFile{
+		FileID:       df.FileID,
+		Offset:       corruptErr.Offset,
+		DroppedBytes: corruptErr.Size,
+		Err:          corruptErr.Err,
+	}
+	if bc.options.ReadWrite {
+		if err := os.Truncate(df.File.Name(), corruptErr.Offset); err != nil {
+			return err
+		}
+		if err := df.File.Sync(); err != nil {
+			return err
+		}
+		truncated.Applied = true
+	}
+	df.WriteOff = corruptErr.Offset
+	bc.report.TruncatedFiles = append(bc.report.TruncatedFiles, truncated)
+	return nil
+}
+
+// quarantine 将损坏的封存文件移动到隔离目录，不再加载其中的记录
+// records 为损坏位置之前能读取的记录，其中的键在所有文件加载完成后由 findResurrected 检查
+func (bc *Bitcask) quarantine(df *DataFile, corruptErr *CorruptionError, records []*hintRecord) error {
+	if err := df.Close(); err != nil {
+		return err
+	}
+	quarantined := QuarantinedFile{
+		FileID: df.FileID,
+		Path:   df.File.Name(),
+		Offset: corruptErr.Offset,
+		Err:    corruptErr.Err,
+	}
+	seen := make(map[string]struct{}, len(records))
+	for _, r := range records {
+		if _, ok := seen[string(r.Key)]; !ok {
+			seen[string(r.Key)] = struct{}{}
+			quarantined.ResurrectedKeys = append(quarantined.ResurrectedKeys, r.Key)
+		}
+	}
+	if bc.options.ReadWrite {
+		dir := filepath.Join(bc.dir, quarantineDir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.Base(df.File.Name()))
+		if err := os.Rename(df.File.Name(), path); err != nil {
+			return err
+		}
+		if err := removeHintFile(bc.dir, df.FileID); err != nil {
+			return err
+		}
+		quarantined.Path = path
+	}
+	bc.report.QuarantinedFiles = append(bc.report.QuarantinedFiles, quarantined)
+	return nil
+}
+
+// findResurrected 在所有数据文件加载完成后，只保留被隔离文件中现在指向更早文件的键
+// 之后的文件中又写入过的键不受影响，在被隔离的文件之外没有任何版本的键仍然是删除状态
+func (bc *Bitcask) findResurrected() {
+	for i := range bc.report.QuarantinedFiles {
+		quarantined := &bc.report.QuarantinedFiles[i]
+		var keys [][]byte
+		for _, key := range quarantined.ResurrectedKeys {
+			if meta, ok := bc.index.Get(string(key)); ok && meta.FileID < quarantined.FileID {
+				keys = append(keys, key)
+			}
+		}
+		quarantined.ResurrectedKeys = keys
+	}
+}
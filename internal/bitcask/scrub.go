@@ -0,0 +1,349 @@
+package bitcask
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"FinnKV/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// ScrubPolicy 后台校验策略
+// 后台定期以限定的速率读取所有封存文件，校验其中的每一条记录，及早发现冷数据的损坏
+type ScrubPolicy struct {
+	Interval     time.Duration              // 两轮校验之间的时间间隔
+	RateLimit    int64                      // 每秒最多读取的字节数，不大于 0 表示不限速
+	Quarantine   bool                       // 隔离损坏的范围，并删除记录位于其中的键，只在读写模式下生效
+	OnCorruption func(err *CorruptionError) // 发现损坏的范围时调用，后台校验时在后台的 goroutine 中执行
+}
+
+// DefaultScrubPolicy 返回默认的后台校验策略
+func DefaultScrubPolicy() ScrubPolicy {
+	return ScrubPolicy{
+		Interval:  time.Hour,
+		RateLimit: 8 << 20, // 8 MB/s
+	}
+}
+
+// ScrubStats 校验的累计统计
+type ScrubStats struct {
+	Runs            int64     // 完成的校验轮数
+	FilesScanned    int64     // 校验过的文件数
+	BytesScanned    int64     // 校验过的字节数
+	EntriesVerified int64     // 校验通过的记录数
+	CorruptRanges   int64     // 发现的损坏范围数
+	CorruptBytes    int64     // 损坏范围的总字节数
+	QuarantinedKeys int64     // 因记录损坏而被删除的键数
+	LastRun         time.Time // 最近一轮校验完成的时间
+}
+
+// ScrubStats 返回校验的累计统计
+func (bc *Bitcask) ScrubStats() ScrubStats {
+	bc.scrubMu.Lock()
+	defer bc.scrubMu.Unlock()
+	return bc.scrubStats
+}
+
+// rateLimiter 按照每秒字节数限制读取速率
+type rateLimiter struct {
+	rate  int64
+	start time.Time
+	bytes int64
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, start: time.Now()}
+}
+
+// wait 记录读取了 n 字节，读取速度超过限制时等待，stop 被关闭时返回 false
+func (l *rateLimiter) wait(n int64, stop <-chan struct{}) bool {
+	if l.rate <= 0 {
+		return true
+	}
+	l.bytes += n
+	delay := time.Duration(float64(l.bytes)/float64(l.rate)*float64(time.Second)) - time.Since(l.start)
+	if delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// Scrub 按照策略校验所有封存文件中的记录，返回发现的损坏范围
+// 损坏不会作为错误返回，而是通过返回值、OnCorruption 回调和 ScrubStats 报告；校验期间被合并替换的文件会被跳过
+func (bc *Bitcask) Scrub(policy ScrubPolicy) ([]*CorruptionError, error) {
+	return bc.scrub(policy, nil)
+}
+
+// scrub 校验所有封存文件，stop 被关闭时中止并返回 ErrClosed
+func (bc *Bitcask) scrub(policy ScrubPolicy, stop <-chan struct{}) ([]*CorruptionError, error) {
+	if atomic.LoadInt32(&bc.closed) == 1 {
+		return nil, ErrClosed
+	}
+
+	bc.RLock()
+	var sealed []*DataFile
+	iter := bc.dataFiles.Iterator()
+	for {
+		_, df, ok := iter()
+		if !ok {
+			break
+		}
+		if df != bc.currFile {
+			sealed = append(sealed, df)
+		}
+	}
+	bc.RUnlock()
+
+	limiter := newRateLimiter(policy.RateLimit)
+	var found []*CorruptionError
+	for _, df := range sealed {
+		corrupt, err := bc.scrubFile(df, policy, limiter, stop)
+		found = append(found, corrupt...)
+		if err != nil {
+			return found, err
+		}
+	}
+
+	bc.scrubMu.Lock()
+	bc.scrubStats.Runs++
+	bc.scrubStats.LastRun = time.Now()
+	bc.scrubMu.Unlock()
+	return found, nil
+}
+
+// scrubFile 校验一个封存文件中的每一条记录，返回发现的损坏范围
+// 有 hint 文件时按照 hint 记录逐条校验，一条记录损坏不影响后续记录的定位
+// 否则顺序扫描，头部损坏导致无法定位下一条记录时，从该记录到文件末尾整体视为损坏
+// 校验期间不持有合并锁，文件可能被合并替换并关闭，此时读取失败，跳过该文件
+func (bc *Bitcask) scrubFile(df *DataFile, policy ScrubPolicy, limiter *rateLimiter, stop <-chan struct{}) ([]*CorruptionError, error) {
+	if atomic.LoadInt32(&bc.closed) == 1 {
+		return nil, ErrClosed
+	}
+	if !bc.hasDataFile(df) {
+		return nil, nil
+	}
+
+	var found []*CorruptionError
+	var entries, scanned int64
+	corrupted := func(offset, size int64, err error) {
+		found = append(found, &CorruptionError{FileID: df.FileID, Offset: offset, Size: size, Err: err})
+	}
+	// verify 读取并校验一条记录，返回的错误表示读取失败或校验被中止
+	verify := func(offset, size int64) error {
+		if atomic.LoadInt32(&bc.closed) == 1 {
+			return ErrClosed
+		}
+		buf := make([]byte, size)
+		if _, err := df.File.ReadAt(buf, offset); err != nil {
+			return err
+		}
+		if _, err := df.decode(buf); err != nil {
+			corrupted(offset, size, err)
+		} else {
+			entries++
+		}
+		scanned += size
+		if !limiter.wait(size, stop) {
+			return ErrClosed
+		}
+		return nil
+	}
+
+	var err error
+	records, hintErr := readHintFile(bc.dir, df.FileID, df.dataStart, df.WriteOff)
+	if hintErr == nil {
+		for _, r := range records {
+			if err = verify(r.Meta.Offset, r.Meta.Size); err != nil {
+				break
+			}
+		}
+	} else {
+		err = bc.scrubSequential(df, verify, corrupted)
+	}
+	if err != nil && !errors.Is(err, ErrClosed) {
+		if atomic.LoadInt32(&bc.closed) == 1 {
+			return nil, ErrClosed
+		}
+		if !bc.hasDataFile(df) {
+			return nil, nil
+		}
+	}
+
+	bc.scrubMu.Lock()
+	bc.scrubStats.BytesScanned += scanned
+	bc.scrubStats.EntriesVerified += entries
+	if err == nil {
+		bc.scrubStats.FilesScanned++
+	}
+	for _, c := range found {
+		bc.scrubStats.CorruptRanges++
+		bc.scrubStats.CorruptBytes += c.Size
+	}
+	bc.scrubMu.Unlock()
+
+	if err == nil && len(found) > 0 && policy.Quarantine && bc.options.ReadWrite {
+		err = bc.quarantineFile(df, found)
+	}
+	if policy.OnCorruption != nil {
+		for _, c := range found {
+			policy.OnCorruption(c)
+		}
+	}
+	return found, err
+}
+
+// hasDataFile 返回 df 是否仍然是索引引用的数据文件，没有被合并替换
+func (bc *Bitcask) hasDataFile(df *DataFile) bool {
+	bc.RLock()
+	defer bc.RUnlock()
+	curr, ok := bc.dataFiles.Find(df.FileID)
+	return ok && curr == df
+}
+
+// quarantineFile 持有合并锁隔离校验时发现的损坏范围，文件在校验之后已经被合并替换时不再处理
+func (bc *Bitcask) quarantineFile(df *DataFile, ranges []*CorruptionError) error {
+	bc.mergeLock.Lock()
+	defer bc.mergeLock.Unlock()
+	if atomic.LoadInt32(&bc.closed) == 1 {
+		return ErrClosed
+	}
+	if !bc.hasDataFile(df) {
+		return nil
+	}
+	return bc.quarantineRanges(df, ranges)
+}
+
+// scrubSequential 没有可用的 hint 文件时，从头顺序扫描数据文件并逐条校验
+func (bc *Bitcask) scrubSequential(df *DataFile, verify func(offset, size int64) error, corrupted func(offset, size int64, err error)) error {
+	end := df.WriteOff
+	offset := df.dataStart
+	headerBuf := make([]byte, maxEntryHeaderSize)
+	for offset < end {
+		n := int64(maxEntryHeaderSize)
+		if end-offset < n {
+			n = end - offset
+		}
+		if _, err := df.File.ReadAt(headerBuf[:n], offset); err != nil {
+			return err
+		}
+		header, headerSize, err := decodeEntryHeader(headerBuf[:n])
+		if err != nil {
+			corrupted(offset, end-offset, io.ErrUnexpectedEOF)
+			return nil
+		}
+		entrySize := int64(headerSize) + int64(header.keySize) + int64(header.valueSize)
+		if entrySize > end-offset {
+			corrupted(offset, end-offset, io.ErrUnexpectedEOF)
+			return nil
+		}
+		if err := verify(offset, entrySize); err != nil {
+			return err
+		}
+		offset += entrySize
+	}
+	return nil
+}
+
+// quarantineRanges 将损坏范围的原始数据复制到隔离目录，并为记录位于损坏范围内的键写入删除标记
+// 这些键的值已经无法读取，删除标记保证重新打开后它们不会再指向损坏的记录
+// 文件中的其他记录仍然可以正常读取，损坏的记录不再有效，会在下次合并时被清理
+func (bc *Bitcask) quarantineRanges(df *DataFile, ranges []*CorruptionError) error {
+	dir := filepath.Join(bc.dir, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, r := range ranges {
+		buf := make([]byte, r.Size)
+		if _, err := df.File.ReadAt(buf, r.Offset); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, fmt.Sprintf("%09d-%d.corrupt", df.FileID, r.Offset))
+		if err := os.WriteFile(path, buf, 0644); err != nil {
+			return err
+		}
+	}
+
+	bc.Lock()
+	defer bc.Unlock()
+
+	var keys []string
+	iter := bc.index.Iterator()
+	for {
+		key, meta, ok := iter()
+		if !ok {
+			break
+		}
+		if meta.FileID != df.FileID {
+			continue
+		}
+		for _, r := range ranges {
+			if meta.Offset >= r.Offset && meta.Offset < r.Offset+r.Size {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		entry := &Entry{
+			Key:       []byte(key),
+			Value:     []byte{},
+			Timestamp: time.Now().Unix(),
+			Type:      EntryTypeDelete,
+		}
+		if err := bc.writeEntry(entry); err != nil {
+			return err
+		}
+	}
+
+	bc.scrubMu.Lock()
+	bc.scrubStats.QuarantinedKeys += int64(len(keys))
+	bc.scrubMu.Unlock()
+	return bc.currFile.Sync()
+}
+
+// startScrubber 启动后台校验
+func (bc *Bitcask) startScrubber(policy ScrubPolicy) {
+	bc.stopScrub = make(chan struct{})
+	bc.scrubDone = make(chan struct{})
+	go func() {
+		defer close(bc.scrubDone)
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bc.stopScrub:
+				return
+			case <-ticker.C:
+				_, err := bc.scrub(policy, bc.stopScrub)
+				if err != nil && !errors.Is(err, ErrClosed) {
+					logger.Error("scrub failed", zap.String("dir", bc.dir), zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// stopScrubber 停止后台校验并等待其退出
+func (bc *Bitcask) stopScrubber() {
+	if bc.stopScrub == nil {
+		return
+	}
+	close(bc.stopScrub)
+	<-bc.scrubDone
+	bc.stopScrub = nil
+}
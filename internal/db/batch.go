@@ -0,0 +1,155 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"FinnKV/internal/bitcask"
+)
+
+const (
+	DefaultMaxBatchSize  = 64 << 20 // WriteBatch 默认的最大字节数
+	DefaultMaxBatchCount = 100000   // WriteBatch 默认的最大记录数
+)
+
+// WriteBatch 收集一组写入和删除，提交时作为一个原子的分组写入
+// 整个分组只同步一次 WAL，恢复时要么全部重放，要么全部丢弃，适合批量导入
+type WriteBatch struct {
+	db        *DB
+	entries   []*bitcask.Entry
+	size      int64
+	committed bool
+	lock      sync.Mutex
+}
+
+// NewWriteBatch 创建一个新的 WriteBatch
+func (db *DB) NewWriteBatch() *WriteBatch {
+	return &WriteBatch{db: db}
+}
+
+// Put 在批次中写入键值对
+func (wb *WriteBatch) Put(key, value []byte) error {
+	return wb.add(key, value, bitcask.EntryTypePut, 0)
+}
+
+// PutWithTTL 在批次中写入键值对，并在 ttl 之后过期
+func (wb *WriteBatch) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return bitcask.ErrInvalidTTL
+	}
+	return wb.add(key, value, bitcask.EntryTypePut, time.Now().Add(ttl).UnixNano())
+}
+
+// Delete 在批次中删除键
+func (wb *WriteBatch) Delete(key []byte) error {
+	return wb.add(key, nil, bitcask.EntryTypeDelete, 0)
+}
+
+// add 将一条记录加入批次，超出大小或数量限制时返回 ErrBatchTooLarge
+func (wb *WriteBatch) add(key, value []byte, entryType byte, expiresAt int64) error {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+
+	if wb.committed {
+		return errors.New("batch already committed")
+	}
+	size := int64(len(key) + len(value))
+	if len(wb.entries)+1 > wb.db.options.maxBatchCount() || wb.size+size > wb.db.options.maxBatchSize() {
+		return ErrBatchTooLarge
+	}
+
+	// 复制键值，调用方在提交前可以复用传入的切片
+	var v []byte
+	if entryType == bitcask.EntryTypePut {
+		v = append([]byte{}, value...)
+	}
+	wb.entries = append(wb.entries, &bitcask.Entry{
+		Key:       append([]byte(nil), key...),
+		Value:     v,
+		Type:      entryType,
+		ExpiresAt: expiresAt,
+	})
+	wb.size += size
+	return nil
+}
+
+// Len 返回批次中的记录数
+func (wb *WriteBatch) Len() int {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+
+	return len(wb.entries)
+}
+
+// Commit 提交批次，同一个键的多次写入以最后一次为准
+func (wb *WriteBatch) Commit() error {
+	wb.lock.Lock()
+	defer wb.lock.Unlock()
+
+	if wb.committed {
+		return errors.New("batch already committed")
+	}
+	wb.committed = true
+	if len(wb.entries) == 0 {
+		return nil
+	}
+
+	txnID, err := wb.db.oracle.Next()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	keys := make([]string, 0, len(wb.entries))
+	for _, entry := range wb.entries {
+		entry.Timestamp = now
+		entry.TxnID = txnID
+		keys = append(keys, string(entry.Key))
+	}
+
+	// 与事务一样登记正在提交的键，保证同一个键的写入按 WAL 中的顺序应用
+	wb.db.prepareBatch(txnID, keys)
+	err = wb.db.commit(txnID, wb.entries)
+	wb.db.endCommit(keys)
+	return err
+}
+
+// prepareBatch 等待批次写入的键都不再被其他提交写入，然后将它们登记为正在提交
+// 批次是盲写，不读取任何值，无需像事务那样检查冲突，只需要与正在提交的写入错开
+func (db *DB) prepareBatch(txnID int64, keys []string) {
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	for db.anyCommitting(keys) {
+		db.commitEnd.Wait()
+	}
+	for _, k := range keys {
+		db.committing[k] = txnID
+	}
+}
+
+// anyCommitting 返回是否有键正被其他提交写入，调用方需持有提交锁
+func (db *DB) anyCommitting(keys []string) bool {
+	for _, k := range keys {
+		if _, ok := db.committing[k]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// maxBatchSize 返回 WriteBatch 的最大字节数
+func (opts *Options) maxBatchSize() int64 {
+	if opts.MaxBatchSize > 0 {
+		return opts.MaxBatchSize
+	}
+	return DefaultMaxBatchSize
+}
+
+// maxBatchCount 返回 WriteBatch 的最大记录数
+func (opts *Options) maxBatchCount() int {
+	if opts.MaxBatchCount > 0 {
+		return opts.MaxBatchCount
+	}
+	return DefaultMaxBatchCount
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"FinnKV/internal/algo"
+	"FinnKV/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	bloomFileName = "BLOOM"    // 持久化布隆过滤器的文件
+	bloomMagic    = 0x424c4d31 // "BLM1"
+
+	bloomKindCounting byte = 1 // 计数布隆过滤器
+	bloomKindScalable byte = 2 // 可扩展布隆过滤器
+
+	// bloomHeaderSize 文件头部大小: magic(4) + kind(1) + appliedLSN(8)
+	bloomHeaderSize = 4 + 1 + 8
+)
+
+// newFilter 按配置创建布隆过滤器
+func newFilter(opts *Options) (algo.Filter, byte) {
+	if opts.ScalableBloomFilter {
+		return algo.NewScalableBloomFilter(opts.BloomFilterSize, opts.BloomFilterFP), bloomKindScalable
+	}
+	return algo.NewBloomFilter(opts.BloomFilterSize, opts.BloomFilterFP), bloomKindCounting
+}
+
+// loadBloom 打开时加载持久化的布隆过滤器
+// 只有文件完整、类型和参数与配置一致，并且保存时的日志序列号与底层存储持久化的序列号相同时才会使用，
+// 之后的写入由 WAL 重放补齐；否则从底层存储的键重新构建
+func (db *DB) loadBloom() error {
+	if db.bitcask.Writable() {
+		err := db.readBloom()
+		if err == nil {
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			logger.Warn("rebuild bloom filter", zap.Error(err))
+		}
+	}
+
+	// 只读模式下写入进程可能已经写入了新的键，总是重新构建
+	db.bloom, db.bloomKind = newFilter(db.options)
+	keys, err := db.bitcask.ListKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		db.bloom.Add(key)
+	}
+	return nil
+}
+
+// readBloom 读取并校验持久化的布隆过滤器
+func (db *DB) readBloom() error {
+	data, err := os.ReadFile(filepath.Join(db.dir, bloomFileName))
+	if err != nil {
+		return err
+	}
+	if len(data) < bloomHeaderSize+4 {
+		return ErrInvalidBloom
+	}
+	body, checksum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != checksum || binary.BigEndian.Uint32(body[0:4]) != bloomMagic {
+		return ErrInvalidBloom
+	}
+	filter, kind := newFilter(db.options)
+	if body[4] != kind {
+		return ErrInvalidBloom
+	}
+	if binary.BigEndian.Uint64(body[5:13]) != db.bitcask.AppliedLSN() {
+		return ErrInvalidBloom
+	}
+	if err := filter.UnmarshalBinary(body[bloomHeaderSize:]); err != nil {
+		return err
+	}
+	db.bloom, db.bloomKind = filter, kind
+	return nil
+}
+
+// saveBloom 持久化布隆过滤器，lsn 为布隆过滤器对应的已应用日志序列号
+func (db *DB) saveBloom(payload []byte, lsn uint64) error {
+	buf := make([]byte, bloomHeaderSize, bloomHeaderSize+len(payload)+4)
+	binary.BigEndian.PutUint32(buf[0:4], bloomMagic)
+	buf[4] = db.bloomKind
+	binary.BigEndian.PutUint64(buf[5:13], lsn)
+	buf = append(buf, payload...)
+	checksum := make([]byte, 4)
+	binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(buf))
+	buf = append(buf, checksum...)
+	return writeFileAtomic(filepath.Join(db.dir, bloomFileName), buf)
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"time"
+
+	"FinnKV/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// DefaultCheckpointInterval 后台检查点默认的时间间隔
+const DefaultCheckpointInterval = time.Minute
+
+// Checkpoint 将已经写入 WAL 的提交持久化到底层存储，记录检查点并删除不再需要的 WAL 段
+// 检查点之前的段在恢复时不会被重放，同时持久化布隆过滤器，下次打开时无需从所有键重新构建
+func (db *DB) Checkpoint() error {
+	// 切换段并同步底层存储时阻止新的提交，并等待已经写入 WAL 的提交应用到底层存储
+	// 这样保存的布隆过滤器与底层存储持久化的日志序列号一致
+	db.checkpointLock.Lock()
+	segmentID, lastLSN, err := db.wal.Rotate()
+	if err != nil {
+		db.checkpointLock.Unlock()
+		return err
+	}
+
+	// 有分组写入了 WAL 但应用失败时，保留 WAL 段，下次打开时重放
+	db.commitLock.Lock()
+	applied := db.appliedLSN
+	db.commitLock.Unlock()
+	if applied < lastLSN {
+		db.checkpointLock.Unlock()
+		return ErrWALNotApplied
+	}
+
+	if err := db.bitcask.Sync(); err != nil {
+		db.checkpointLock.Unlock()
+		return err
+	}
+	bloom, err := db.bloom.MarshalBinary()
+	db.checkpointLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := db.wal.Checkpoint(segmentID); err != nil {
+		return err
+	}
+	return db.saveBloom(bloom, applied)
+}
+
+// checkpointInterval 返回后台检查点的时间间隔
+func (opts *Options) checkpointInterval() time.Duration {
+	if opts.CheckpointInterval == 0 {
+		return DefaultCheckpointInterval
+	}
+	return opts.CheckpointInterval
+}
+
+// startAutoCheckpoint 启动后台检查点，interval 不大于 0 时不启动
+func (db *DB) startAutoCheckpoint(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	db.stopCheckpoint = make(chan struct{})
+	db.checkpointDone = make(chan struct{})
+	go func() {
+		defer close(db.checkpointDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-db.stopCheckpoint:
+				return
+			case <-ticker.C:
+				if err := db.Checkpoint(); err != nil {
+					logger.Error("checkpoint failed", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// stopAutoCheckpoint 停止后台检查点并等待其退出
+func (db *DB) stopAutoCheckpoint() {
+	if db.stopCheckpoint == nil {
+		return
+	}
+	close(db.stopCheckpoint)
+	<-db.checkpointDone
+	db.stopCheckpoint = nil
+}
@@ -2,29 +2,56 @@ package db
 
 import (
 	"errors"
+	"math"
 	"path/filepath"
 	"sync"
 	"time"
 
 	"FinnKV/internal/algo"
 	"FinnKV/internal/bitcask"
-	"FinnKV/pkg/logger"
 )
 
 // DB 封装了 Bitcask、布隆过滤器、WAL 和 MVCC
 type DB struct {
-	bitcask *bitcask.Bitcask
-	bloom   *algo.BloomFilter
-	wal     *WAL
-	mvcc    *MVCC
-	lock    sync.RWMutex
-	options *Options
+	dir       string
+	bitcask   *bitcask.Bitcask
+	bloom     algo.Filter
+	bloomKind byte
+	wal       *WAL
+	mvcc      *MVCC
+	oracle    *Oracle
+	lock      sync.RWMutex
+	options   *Options
+
+	commitLock sync.Mutex       // 保护提交时的冲突检测、活跃读取的登记以及 MVCC 版本的写入和清理
+	committing map[string]int64 // 正在提交的事务写入的键，值为事务 ID
+	commitEnd  *sync.Cond       // 有提交结束、释放了登记的键时广播，基于 commitLock
+	readers    map[int64]int    // 活跃的事务和快照的读取时间戳及其数量
+	appliedLSN uint64           // 不大于它的 WAL 分组都已经应用到底层存储
+	doneLSNs   map[uint64]bool  // 已经应用、但之前还有分组没有应用完成的日志序列号
+	applyLock  sync.RWMutex     // 保证按时间戳读取时不会看到写入了底层存储、但还没有记录到 MVCC 中的数据
+
+	checkpointLock sync.RWMutex // 提交持有读锁，检查点切换 WAL 段时持有写锁，等待已经写入 WAL 的提交应用完成
+	stopCheckpoint chan struct{}
+	checkpointDone chan struct{}
 }
 
+// oracleFileName 时间戳分配器持久化预留上界的文件
+const oracleFileName = "TIMESTAMP"
+
 // Options 配置项
 type Options struct {
 	BloomFilterSize uint
 	BloomFilterFP   float64
+	MaxBatchSize    int64         // WriteBatch 的最大字节数，0 表示使用 DefaultMaxBatchSize
+	MaxBatchCount   int           // WriteBatch 的最大记录数，0 表示使用 DefaultMaxBatchCount
+	MaxSyncWait     time.Duration // WAL 组提交收集请求的最长等待时间
+	MaxSyncBatch    int           // WAL 一次组提交最多合并的请求数，0 表示使用默认值
+	MaxWALSegment   int64         // WAL 段文件的大小上限，0 表示使用默认值
+	// 后台检查点的时间间隔，0 表示使用 DefaultCheckpointInterval，负数表示不在后台执行检查点
+	CheckpointInterval time.Duration
+	// 使用可扩展布隆过滤器，键的数量超过 BloomFilterSize 时自动添加新层，误判率不会随之上升
+	ScalableBloomFilter bool
 	// 其他配置项
 }
 
@@ -35,35 +62,59 @@ func Open(dir string, bitcaskOptions []bitcask.Option, dbOptions *Options) (*DB,
 		return nil, err
 	}
 
-	bf := algo.NewBloomFilter(dbOptions.BloomFilterSize, dbOptions.BloomFilterFP)
-	wal, err := NewWAL(filepath.Join(dir, "wal"))
+	walOptions := []WALOption{
+		WithMaxSyncWait(dbOptions.MaxSyncWait),
+		WithMaxSyncBatch(dbOptions.MaxSyncBatch),
+		WithMaxSegmentSize(dbOptions.MaxWALSegment),
+	}
+	if !bc.Writable() {
+		walOptions = append(walOptions, WithWALReadOnly())
+	}
+	wal, err := NewWAL(filepath.Join(dir, "wal"), walOptions...)
+	if err != nil {
+		_ = bc.Close()
+		return nil, err
+	}
+
+	oracle, err := OpenOracle(filepath.Join(dir, oracleFileName), !bc.Writable())
 	if err != nil {
+		_ = wal.Close()
+		_ = bc.Close()
 		return nil, err
 	}
 
 	mvcc := NewMVCC()
 
 	db := &DB{
+		dir:     dir,
 		bitcask: bc,
-		bloom:   bf,
 		wal:     wal,
 		mvcc:    mvcc,
+		oracle:  oracle,
 		options: dbOptions,
+
+		committing: make(map[string]int64),
+		readers:    make(map[int64]int),
+		doneLSNs:   make(map[uint64]bool),
 	}
+	db.commitEnd = sync.NewCond(&db.commitLock)
 
-	// 从现有的键加载布隆过滤器
-	keys, err := bc.ListKeys()
-	if err != nil {
+	// 加载持久化的布隆过滤器，无法使用时从现有的键重新构建
+	if err := db.loadBloom(); err != nil {
+		_ = wal.Close()
+		_ = bc.Close()
 		return nil, err
 	}
-	for _, key := range keys {
-		db.bloom.Add(key)
-	}
 
-	// 恢复未提交的事务
-	err = db.Recover()
-	if err != nil {
-		return nil, err
+	// 恢复未提交的事务，只读模式下 WAL 属于正在写入的进程，不能重放或清空
+	if bc.Writable() {
+		err = db.Recover()
+		if err != nil {
+			_ = wal.Close()
+			_ = bc.Close()
+			return nil, err
+		}
+		db.startAutoCheckpoint(dbOptions.checkpointInterval())
 	}
 
 	return db, nil
@@ -71,14 +122,9 @@ func Open(dir string, bitcaskOptions []bitcask.Option, dbOptions *Options) (*DB,
 
 // Put 写入键值对
 func (db *DB) Put(key, value []byte) error {
-	txn := db.BeginTransaction()
-	defer func(txn *Transaction) {
-		err := txn.Commit()
-		if err != nil {
-			logger.Fatal(err.Error())
-		}
-	}(txn)
-	return txn.Put(key, value)
+	return db.update(func(txn *Transaction) error {
+		return txn.Put(key, value)
+	})
 }
 
 // Get 获取键对应的值
@@ -90,58 +136,243 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, errors.New("key not found")
 	}
 
-	ts := time.Now().UnixNano()
+	return db.getAt(key, math.MaxInt64)
+}
+
+// getAt 读取键在指定时间戳时的值
+// MVCC 中没有版本链的键自从所有活跃的读取开始以来都没有被修改过，直接读取底层存储
+func (db *DB) getAt(key []byte, ts int64) ([]byte, error) {
+	db.applyLock.RLock()
+	defer db.applyLock.RUnlock()
+
 	if value, ok := db.mvcc.Read(key, ts); ok {
+		if value == nil {
+			return nil, bitcask.ErrKeyNotFound
+		}
 		return value, nil
 	}
-
 	return db.bitcask.Get(key)
 }
 
+// PutWithTTL 写入键值对，并在 ttl 之后过期
+func (db *DB) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	return db.update(func(txn *Transaction) error {
+		return txn.PutWithTTL(key, value, ttl)
+	})
+}
+
+// TTL 返回键的剩余存活时间，永不过期的键返回 bitcask.NoTTL
+func (db *DB) TTL(key []byte) (time.Duration, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if !db.bloom.Contains(key) {
+		return 0, errors.New("key not found")
+	}
+	return db.bitcask.TTL(key)
+}
+
 // Delete 删除键
 func (db *DB) Delete(key []byte) error {
-	txn := db.BeginTransaction()
-	defer func(txn *Transaction) {
-		err := txn.Commit()
+	return db.update(func(txn *Transaction) error {
+		return txn.Delete(key)
+	})
+}
+
+// update 在一个新事务中执行 fn 并提交，提交遇到写写冲突时重试
+func (db *DB) update(fn func(txn *Transaction) error) error {
+	for {
+		txn, err := db.BeginTransaction()
 		if err != nil {
-			logger.Fatal(err.Error())
+			return err
+		}
+		if err := fn(txn); err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+		err = txn.Commit()
+		if !errors.Is(err, ErrTxnConflict) {
+			return err
 		}
-	}(txn)
-	return txn.Delete(key)
+	}
 }
 
-// BeginTransaction 开始一个事务
-func (db *DB) BeginTransaction() *Transaction {
-	return &Transaction{
-		db:      db,
-		writes:  make(map[string][]byte),
-		startTs: time.Now().UnixNano(),
+// BeginTransaction 开始一个事务，默认使用快照隔离，分配开始时间戳失败时返回错误
+func (db *DB) BeginTransaction(opts ...TxnOption) (*Transaction, error) {
+	options := &TxnOptions{Isolation: SnapshotIsolation}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	startTs, err := db.acquireReader()
+	if err != nil {
+		return nil, err
+	}
+	txn := &Transaction{
+		db:        db,
+		writes:    make(map[string][]byte),
+		expires:   make(map[string]int64),
+		startTs:   startTs,
+		isolation: options.Isolation,
 	}
+	if options.Isolation == Serializable {
+		txn.reads = newReadSet()
+	}
+	return txn, nil
 }
 
-// Recover 从 WAL 中恢复未提交的事务
+// Recover 从 WAL 中重放上一个检查点之后、底层存储还没有应用的已提交事务，完成后记录新的检查点
+// 重放按日志序列号的顺序重新执行写入和删除，中途崩溃后再次重放的结果相同
 func (db *DB) Recover() error {
-	entries, err := db.wal.ReadAll()
+	applied := db.bitcask.AppliedLSN()
+	entries, maxLSN, err := db.wal.ReadAfter(applied)
 	if err != nil {
 		return err
 	}
 
+	// 之后分配的时间戳要大于 WAL 中出现过的事务 ID
+	var maxTxnID int64
+	for _, entry := range entries {
+		if entry.TxnID > maxTxnID {
+			maxTxnID = entry.TxnID
+		}
+	}
+	if err := db.oracle.Observe(maxTxnID); err != nil {
+		return err
+	}
+
+	if err := db.apply(entries); err != nil {
+		return err
+	}
+	if maxLSN > applied {
+		applied = maxLSN
+	}
+	db.wal.ObserveLSN(applied)
+	db.appliedLSN = applied
+	db.bitcask.SetAppliedLSN(applied)
+	return db.Checkpoint()
+}
+
+// commit 将一组写入作为一个原子的分组写入 WAL 并同步，再应用到底层存储并在 MVCC 中记录已提交的版本
+// txnID 由时间戳分配器分配，同时写入 WAL 和底层存储；提交时间戳在应用时单独分配
+// WAL 中的分组以 TxnBegin 开始、以 TxnEnd 结束，恢复时不完整的分组会被整体丢弃
+// 并发的提交通过 WAL 的组提交共享一次 fsync
+func (db *DB) commit(txnID int64, entries []*bitcask.Entry) error {
+	group := make([]*bitcask.Entry, 0, len(entries)+2)
+	group = append(group, &bitcask.Entry{
+		Type:      bitcask.EntryTypeTxnBegin,
+		TxnID:     txnID,
+		Timestamp: time.Now().Unix(),
+	})
+	group = append(group, entries...)
+	group = append(group, &bitcask.Entry{
+		Type:      bitcask.EntryTypeTxnEnd,
+		TxnID:     txnID,
+		Timestamp: time.Now().Unix(),
+	})
+
+	db.checkpointLock.RLock()
+	defer db.checkpointLock.RUnlock()
+	lsn, err := db.wal.Commit(group)
+	if err != nil {
+		if lsn != 0 {
+			// 写入失败的分组不会被应用，也不应该阻止之后的分组推进已应用的序列号
+			db.commitLock.Lock()
+			db.markApplied(lsn)
+			db.commitLock.Unlock()
+		}
+		return err
+	}
+
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+	db.applyLock.Lock()
+	defer db.applyLock.Unlock()
+
+	// 提交时间戳在应用之前分配，分配失败时分组与应用失败一样留在 WAL 中，下次打开时重放
+	commitTs, err := db.oracle.Next()
+	if err != nil {
+		return err
+	}
+	if err := db.preserve(entries); err != nil {
+		return err
+	}
+	if err := db.apply(entries); err != nil {
+		return err
+	}
+	db.markApplied(lsn)
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		var value []byte
+		if entry.Type == bitcask.EntryTypePut {
+			value = entry.Value
+		}
+		db.mvcc.Install(entry.Key, value, entry.ExpiresAt, commitTs)
+		keys = append(keys, string(entry.Key))
+	}
+	db.mvcc.CleanupKeys(keys, db.oldestReader())
+	return nil
+}
+
+// markApplied 记录一个 WAL 分组已经应用完成，并推进连续应用完成的日志序列号，调用方需持有提交锁
+// 并发提交的应用顺序可能与日志序列号的顺序不同，只有之前的分组都应用完成后才能推进
+func (db *DB) markApplied(lsn uint64) {
+	db.doneLSNs[lsn] = true
+	for db.doneLSNs[db.appliedLSN+1] {
+		delete(db.doneLSNs, db.appliedLSN+1)
+		db.appliedLSN++
+	}
+	db.bitcask.SetAppliedLSN(db.appliedLSN)
+}
+
+// preserve 有活跃的读取时，在写入之前把键在底层存储中的当前值记录到 MVCC 中
+// 这样读取时间戳早于本次提交的事务和快照仍然能读到旧值，调用方需持有提交锁
+func (db *DB) preserve(entries []*bitcask.Entry) error {
+	if len(db.readers) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if db.mvcc.Has(entry.Key) {
+			continue
+		}
+		value, err := db.bitcask.Get(entry.Key)
+		if errors.Is(err, bitcask.ErrKeyNotFound) {
+			db.mvcc.Preserve(entry.Key, nil, 0)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var expiresAt int64
+		if ttl, err := db.bitcask.TTL(entry.Key); err == nil && ttl != bitcask.NoTTL {
+			expiresAt = now.Add(ttl).UnixNano()
+		}
+		db.mvcc.Preserve(entry.Key, value, expiresAt)
+	}
+	return nil
+}
+
+// apply 将一组写入应用到底层存储和布隆过滤器
+// 写入时已经过期的键由底层存储按过期处理
+func (db *DB) apply(entries []*bitcask.Entry) error {
+	if err := db.bitcask.ApplyBatch(entries); err != nil {
+		return err
+	}
 	for _, entry := range entries {
 		switch entry.Type {
 		case bitcask.EntryTypePut:
-			if err := db.bitcask.Put(entry.Key, entry.Value); err != nil {
-				return err
-			}
-			db.bloom.Add(entry.Key)
+			db.bloom.Add(entry.Key) // 添加到布隆过滤器
 		case bitcask.EntryTypeDelete:
-			if err := db.bitcask.Delete(entry.Key); err != nil {
-				return err
-			}
-			db.bloom.Remove(entry.Key)
+			db.bloom.Remove(entry.Key) // 从布隆过滤器中删除
 		}
 	}
+	return nil
+}
 
-	return db.wal.Clear()
+// Merge 合并底层存储的数据文件，合并期间可以正常读写
+func (db *DB) Merge() error {
+	return db.bitcask.Merge()
 }
 
 // Close 关闭数据库
@@ -149,16 +380,19 @@ func (db *DB) Close() error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	// 合并数据文件
-	if err := db.bitcask.Merge(); err != nil {
-		return err
+	// 停止后台检查点，关闭前记录最后一个检查点，下次打开时无需重放
+	db.stopAutoCheckpoint()
+	var err error
+	if db.bitcask.Writable() {
+		err = db.Checkpoint()
 	}
 
-	// 关闭 WAL
-	if err := db.wal.Close(); err != nil {
-		return err
+	// 检查点失败时也要关闭 WAL 和底层存储，停止组提交并释放目录锁，只返回第一个错误
+	if walErr := db.wal.Close(); err == nil {
+		err = walErr
 	}
-
-	// 关闭底层存储
-	return db.bitcask.Close()
+	if bcErr := db.bitcask.Close(); err == nil {
+		err = bcErr
+	}
+	return err
 }
@@ -0,0 +1,16 @@
+package db
+
+import "errors"
+
+var (
+	ErrBatchTooLarge     = errors.New("write batch is too large")
+	ErrWALClosed         = errors.New("wal is closed")
+	ErrTxnConflict       = errors.New("transaction conflict")
+	ErrSnapshotReleased  = errors.New("snapshot already released")
+	ErrInvalidOracle     = errors.New("invalid timestamp oracle file")
+	ErrInvalidCheckpoint = errors.New("invalid wal checkpoint")
+	ErrWALReadOnly       = errors.New("wal is read-only")
+	ErrWALFailed         = errors.New("wal has no usable segment")
+	ErrWALNotApplied     = errors.New("wal contains records that are not applied")
+	ErrInvalidBloom      = errors.New("invalid bloom filter file")
+)
@@ -0,0 +1,195 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// WALOption WAL 的配置函数
+type WALOption func(*WALOptions)
+
+// WALOptions WAL 的配置项
+type WALOptions struct {
+	MaxSyncWait    time.Duration // 组提交收集请求的最长等待时间，0 表示只合并已经在排队的请求
+	MaxSyncBatch   int           // 一次组提交最多合并的请求数
+	MaxSegmentSize int64         // 段文件的大小上限，达到上限后切换到新段
+	ReadOnly       bool          // 只读模式下不创建活跃段，不能写入
+}
+
+func defaultWALOptions() *WALOptions {
+	return &WALOptions{
+		MaxSyncWait:    0,
+		MaxSyncBatch:   128,
+		MaxSegmentSize: 64 << 20, // 64 MB
+	}
+}
+
+// WithMaxSyncWait 设置组提交收集请求的最长等待时间
+func WithMaxSyncWait(d time.Duration) WALOption {
+	return func(opts *WALOptions) {
+		opts.MaxSyncWait = d
+	}
+}
+
+// WithMaxSyncBatch 设置一次组提交最多合并的请求数
+func WithMaxSyncBatch(n int) WALOption {
+	return func(opts *WALOptions) {
+		if n > 0 {
+			opts.MaxSyncBatch = n
+		}
+	}
+}
+
+// WithMaxSegmentSize 设置段文件的大小上限
+func WithMaxSegmentSize(size int64) WALOption {
+	return func(opts *WALOptions) {
+		if size > 0 {
+			opts.MaxSegmentSize = size
+		}
+	}
+}
+
+// WithWALReadOnly 以只读模式打开 WAL
+func WithWALReadOnly() WALOption {
+	return func(opts *WALOptions) {
+		opts.ReadOnly = true
+	}
+}
+
+// commitRequest 表示一个等待持久化的提交请求
+type commitRequest struct {
+	entries []*bitcask.Entry
+	lsn     uint64 // 写入时分配的日志序列号
+	done    chan error
+}
+
+// Commit 将一组以 TxnBegin 开始的 Entry 写入 WAL，并在它们同步到磁盘后返回这一组的日志序列号
+// 并发的提交会被合并为一次写入和一次 fsync，每个调用方都会得到自己这一组的持久化结果
+// 写入失败时，如果已经分配了日志序列号，也会一并返回
+func (wal *WAL) Commit(entries []*bitcask.Entry) (uint64, error) {
+	req := &commitRequest{
+		entries: entries,
+		done:    make(chan error, 1),
+	}
+	select {
+	case wal.commitCh <- req:
+	case <-wal.closeCh:
+		return 0, ErrWALClosed
+	}
+	err := <-req.done
+	return req.lsn, err
+}
+
+// groupCommit 后台收集提交请求，按组写入并同步
+func (wal *WAL) groupCommit() {
+	defer close(wal.commitDone)
+	for {
+		var req *commitRequest
+		select {
+		case req = <-wal.commitCh:
+		case <-wal.closeCh:
+			return
+		}
+		group := wal.collect(req)
+		err := wal.flush(group)
+		for _, r := range group {
+			r.done <- err
+		}
+	}
+}
+
+// collect 以 first 为首收集一组提交请求
+// 在 fsync 期间到达的请求会在下一组中被一次性取走
+func (wal *WAL) collect(first *commitRequest) []*commitRequest {
+	group := []*commitRequest{first}
+	var timeout <-chan time.Time
+	if wal.options.MaxSyncWait > 0 {
+		timer := time.NewTimer(wal.options.MaxSyncWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(group) < wal.options.MaxSyncBatch {
+		if timeout == nil {
+			select {
+			case req := <-wal.commitCh:
+				group = append(group, req)
+			default:
+				return group
+			}
+			continue
+		}
+		select {
+		case req := <-wal.commitCh:
+			group = append(group, req)
+		case <-timeout:
+			return group
+		}
+	}
+	return group
+}
+
+// flush 为一组请求按写入顺序分配日志序列号，将数据一次性写入 WAL 并同步，活跃段达到大小上限时切换到新段
+// 同一组的数据总是写入同一个段，事务不会跨越段的边界
+func (wal *WAL) flush(group []*commitRequest) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if err := wal.writable(); err != nil {
+		return err
+	}
+	var buf []byte
+	for _, req := range group {
+		wal.lsn++
+		req.lsn = wal.lsn
+		if len(req.entries) > 0 && req.entries[0].Type == bitcask.EntryTypeTxnBegin {
+			req.entries[0].Value = encodeLSN(req.lsn)
+		}
+		buf = append(buf, encodeEntries(req.entries)...)
+	}
+	start := wal.size
+	if err := wal.write(buf); err != nil {
+		wal.discard(start)
+		return err
+	}
+	if err := wal.file.Sync(); err != nil {
+		wal.discard(start)
+		return err
+	}
+	// 数据已经持久化，切换段失败不影响这一组的提交结果，WAL 失效时之后的提交会返回错误
+	if wal.size >= wal.options.MaxSegmentSize {
+		if err := wal.rotate(); err != nil {
+			logger.Error("failed to rotate wal segment", zap.String("dir", wal.dir), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// discard 在写入或同步失败后丢弃活跃段中 start 之后的数据，调用方需持有锁
+// 读取段时遇到残缺的记录就会停止，不丢弃的话之后成功提交的组在重放时都会被跳过
+// 截断失败时切换到新段，新段也无法打开时 WAL 失效，之后的提交都返回错误
+func (wal *WAL) discard(start int64) {
+	err := wal.file.Truncate(start)
+	if err == nil {
+		err = wal.file.Sync()
+	}
+	if err == nil {
+		wal.size = start
+		return
+	}
+	logger.Error("failed to truncate wal segment", zap.String("dir", wal.dir), zap.Int64("segment", wal.segmentID), zap.Error(err))
+	_ = wal.file.Close()
+	if err := wal.openSegment(wal.segmentID + 1); err != nil {
+		wal.fail(err)
+	}
+}
+
+// fail 在活跃段已经关闭且无法打开新段时记录失败原因，调用方需持有锁
+func (wal *WAL) fail(err error) {
+	logger.Error("wal has no usable segment", zap.String("dir", wal.dir), zap.Int64("segment", wal.segmentID), zap.Error(err))
+	wal.file = nil
+	wal.failed = fmt.Errorf("%w: %v", ErrWALFailed, err)
+}
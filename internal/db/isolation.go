@@ -0,0 +1,79 @@
+package db
+
+import "bytes"
+
+// IsolationLevel 事务的隔离级别
+type IsolationLevel int
+
+const (
+	// SnapshotIsolation 快照隔离，只检测写写冲突
+	SnapshotIsolation IsolationLevel = iota
+	// Serializable 可串行化，额外记录事务读取的键和范围，提交时发现它们被其他事务修改则中止
+	Serializable
+)
+
+// TxnOption 事务的配置函数
+type TxnOption func(*TxnOptions)
+
+// TxnOptions 事务的配置项
+type TxnOptions struct {
+	Isolation IsolationLevel
+}
+
+// WithIsolation 设置事务的隔离级别
+func WithIsolation(level IsolationLevel) TxnOption {
+	return func(opts *TxnOptions) {
+		opts.Isolation = level
+	}
+}
+
+// keyRange 表示 [start, end) 范围，end 为 nil 表示不限制
+type keyRange struct {
+	start []byte
+	end   []byte
+}
+
+// contains 返回 key 是否在范围内
+func (r *keyRange) contains(key []byte) bool {
+	if bytes.Compare(key, r.start) < 0 {
+		return false
+	}
+	return r.end == nil || bytes.Compare(key, r.end) < 0
+}
+
+// readSet 可串行化事务读取过的键和范围
+type readSet struct {
+	keys   map[string]struct{}
+	ranges []*keyRange
+}
+
+func newReadSet() *readSet {
+	return &readSet{keys: make(map[string]struct{})}
+}
+
+// addKey 记录读取过的键
+func (rs *readSet) addKey(key []byte) {
+	rs.keys[string(key)] = struct{}{}
+}
+
+// addRange 记录遍历过的范围
+func (rs *readSet) addRange(start, end []byte) {
+	r := &keyRange{start: append([]byte(nil), start...)}
+	if end != nil {
+		r.end = append([]byte{}, end...)
+	}
+	rs.ranges = append(rs.ranges, r)
+}
+
+// contains 返回 key 是否被读取过，或者位于遍历过的范围内
+func (rs *readSet) contains(key []byte) bool {
+	if _, ok := rs.keys[string(key)]; ok {
+		return true
+	}
+	for _, r := range rs.ranges {
+		if r.contains(key) {
+			return true
+		}
+	}
+	return false
+}
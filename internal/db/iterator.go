@@ -0,0 +1,212 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"time"
+
+	"FinnKV/internal/bitcask"
+)
+
+// localWrite 表示事务中尚未提交的一次写入，或者键在快照时间戳时的值
+type localWrite struct {
+	key       []byte
+	value     []byte // nil 表示删除
+	expiresAt int64
+}
+
+// Iterator 按键的顺序遍历 DB 中的键值对
+// 在事务或快照中创建时，会将事务本地的写入以及 MVCC 中的版本合并到遍历结果中
+type Iterator struct {
+	base    *bitcask.Iterator
+	local   []*localWrite // 按遍历顺序排列的本地写入
+	pos     int
+	options *bitcask.ScanOptions
+	count   int
+	resolve func(key []byte) ([]byte, error) // 按快照读取底层迭代器中的键，为 nil 时读取最新值
+
+	key      []byte
+	value    []byte // 当前键已经确定的值
+	resolved bool   // 当前键的值是否已经确定
+	err      error  // 读取当前键的值时发生的错误
+	valid    bool
+	advance  bool  // 当前键来自底层迭代器，移动时需要先推进底层迭代器
+	failed   error // 迭代器无法创建的原因，不为 nil 时迭代器始终无效
+}
+
+// newIterator 创建合并了本地写入的迭代器，resolve 不为 nil 时底层迭代器中的键按快照读取
+func newIterator(base *bitcask.Iterator, local []*localWrite, options *bitcask.ScanOptions,
+	resolve func(key []byte) ([]byte, error)) *Iterator {
+	sort.Slice(local, func(i, j int) bool {
+		if options.Reverse {
+			return bytes.Compare(local[i].key, local[j].key) > 0
+		}
+		return bytes.Compare(local[i].key, local[j].key) < 0
+	})
+	it := &Iterator{
+		base:    base,
+		local:   local,
+		options: options,
+		resolve: resolve,
+	}
+	it.next()
+	return it
+}
+
+// failedIterator 返回始终无效的迭代器，Err 和 Value 返回 err
+func failedIterator(err error) *Iterator {
+	return &Iterator{failed: err}
+}
+
+// Scan 返回遍历 [start, end) 范围内键值对的迭代器，start 或 end 为 nil 表示不限制
+func (db *DB) Scan(start, end []byte, opts ...bitcask.ScanOption) *Iterator {
+	options := bitcask.NewScanOptions(opts...)
+	return newIterator(db.scanBase(start, end, options), nil, options, nil)
+}
+
+// scanBase 返回底层存储的迭代器，数量限制由上层迭代器处理
+func (db *DB) scanBase(start, end []byte, options *bitcask.ScanOptions) *bitcask.Iterator {
+	if options.Reverse {
+		return db.bitcask.Scan(start, end, bitcask.WithReverse())
+	}
+	return db.bitcask.Scan(start, end)
+}
+
+// PrefixScan 返回遍历所有以 prefix 为前缀的键值对的迭代器
+func (db *DB) PrefixScan(prefix []byte, opts ...bitcask.ScanOption) *Iterator {
+	return db.Scan(prefix, bitcask.PrefixEnd(prefix), opts...)
+}
+
+// before 返回在遍历顺序中 a 是否位于 b 之前
+func (it *Iterator) before(a, b []byte) bool {
+	if it.options.Reverse {
+		return bytes.Compare(a, b) > 0
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+// next 合并底层迭代器和本地写入，移动到下一个可见的键
+func (it *Iterator) next() {
+	it.valid = false
+	if it.failed != nil {
+		return
+	}
+	if it.advance {
+		it.base.Next()
+		it.advance = false
+	}
+	if it.options.Limit > 0 && it.count >= it.options.Limit {
+		return
+	}
+	now := time.Now().UnixNano()
+	for {
+		var lw *localWrite
+		if it.pos < len(it.local) {
+			lw = it.local[it.pos]
+		}
+		baseValid := it.base.Valid()
+		if lw == nil && !baseValid {
+			return
+		}
+
+		if lw != nil && (!baseValid || !it.before(it.base.Key(), lw.key)) {
+			// 本地写入覆盖底层存储中相同的键
+			if baseValid && bytes.Equal(it.base.Key(), lw.key) {
+				it.base.Next()
+			}
+			it.pos++
+			if lw.value == nil || (lw.expiresAt != 0 && lw.expiresAt <= now) {
+				continue
+			}
+			it.key, it.value, it.resolved, it.err = lw.key, lw.value, true, nil
+		} else if it.resolve != nil {
+			key := it.base.Key()
+			value, err := it.resolve(key)
+			if errors.Is(err, bitcask.ErrKeyNotFound) {
+				// 快照时间戳时该键还不存在
+				it.base.Next()
+				continue
+			}
+			it.key, it.value, it.resolved, it.err = key, value, true, err
+			it.advance = true
+		} else {
+			it.key, it.value, it.resolved, it.err = it.base.Key(), nil, false, nil
+			it.advance = true
+		}
+		it.valid = true
+		it.count++
+		return
+	}
+}
+
+// Next 移动到下一个键
+func (it *Iterator) Next() {
+	it.next()
+}
+
+// Rewind 回到范围的起点（反向遍历时为终点）
+func (it *Iterator) Rewind() {
+	if it.failed != nil {
+		return
+	}
+	it.base.Rewind()
+	it.advance = false
+	it.pos = 0
+	it.count = 0
+	it.next()
+}
+
+// Seek 定位到第一个不小于 key 的键（反向遍历时为最后一个不大于 key 的键）
+func (it *Iterator) Seek(key []byte) {
+	if it.failed != nil {
+		return
+	}
+	it.base.Seek(key)
+	it.advance = false
+	it.pos = sort.Search(len(it.local), func(i int) bool {
+		return !it.before(it.local[i].key, key)
+	})
+	it.count = 0
+	it.next()
+}
+
+// Valid 返回迭代器是否指向一个有效的键
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+// Key 返回当前的键
+func (it *Iterator) Key() []byte {
+	if !it.valid {
+		return nil
+	}
+	return it.key
+}
+
+// Value 返回当前键对应的值
+func (it *Iterator) Value() ([]byte, error) {
+	if it.failed != nil {
+		return nil, it.failed
+	}
+	if !it.valid {
+		return nil, errors.New("iterator is not valid")
+	}
+	if it.resolved {
+		return it.value, it.err
+	}
+	return it.base.Value()
+}
+
+// Err 返回迭代器无法创建的原因，例如快照已经释放
+func (it *Iterator) Err() error {
+	return it.failed
+}
+
+// Close 关闭迭代器
+func (it *Iterator) Close() {
+	if it.base != nil {
+		it.base.Close()
+	}
+	it.valid = false
+}
@@ -2,22 +2,26 @@ package db
 
 import (
 	"sync"
+	"time"
 )
 
-// VersionedValue 表示一个版本的值
+// VersionedValue 表示一个已提交版本的值
 type VersionedValue struct {
-	value     []byte
-	timestamp int64
-	committed bool
+	value     []byte // nil 表示删除
+	commitTs  int64  // 提交时间戳
+	expiresAt int64  // 过期时间（Unix 纳秒），0 表示永不过期
 }
 
 // VersionedValues 包含一个键的所有版本，以及一个局部锁
+// 版本按提交时间戳从旧到新排列
 type VersionedValues struct {
 	values []*VersionedValue
 	lock   sync.RWMutex
 }
 
 // MVCC 实现多版本并发控制，使用 sync.Map 和局部锁优化
+// 只记录已提交的版本，事务尚未提交的写入保存在事务自身中
+// 没有版本链的键自从所有活跃的读取开始以来都没有被修改过，直接读取底层存储即可
 type MVCC struct {
 	versions sync.Map // map[string]*VersionedValues
 }
@@ -27,7 +31,7 @@ func NewMVCC() *MVCC {
 	return &MVCC{}
 }
 
-// Read 读取指定时间戳之前的最新已提交版本
+// Read 读取指定时间戳之前提交的最新版本
 func (mvcc *MVCC) Read(key []byte, ts int64) ([]byte, bool) {
 	rawValues, ok := mvcc.versions.Load(string(key))
 	if !ok {
@@ -43,91 +47,138 @@ func (mvcc *MVCC) Read(key []byte, ts int64) ([]byte, bool) {
 	// 从最新版本开始遍历
 	for i := len(versions) - 1; i >= 0; i-- {
 		vv := versions[i]
-		if vv.timestamp <= ts && vv.committed {
+		if vv.commitTs <= ts {
+			// 已过期的版本视为已删除
+			if vv.expiresAt != 0 && vv.expiresAt <= time.Now().UnixNano() {
+				return nil, true
+			}
 			return vv.value, true
 		}
 	}
 	return nil, false
 }
 
-// Write 写入新的版本
-func (mvcc *MVCC) Write(key, value []byte, txnID int64) {
-	vv := &VersionedValue{
+// Install 为键追加一个以 commitTs 提交的版本，commitTs 必须大于已有版本的提交时间戳
+func (mvcc *MVCC) Install(key, value []byte, expiresAt int64, commitTs int64) {
+	rawValues, _ := mvcc.versions.LoadOrStore(string(key), &VersionedValues{})
+	versionedValues := rawValues.(*VersionedValues)
+	versionedValues.lock.Lock()
+	defer versionedValues.lock.Unlock()
+
+	versionedValues.values = append(versionedValues.values, &VersionedValue{
 		value:     value,
-		timestamp: txnID,
-		committed: false,
-	}
+		commitTs:  commitTs,
+		expiresAt: expiresAt,
+	})
+}
 
+// Preserve 在键被修改之前记录它在底层存储中的当前值，作为所有读取都能看到的最早版本
+// 键已经有版本链时不做任何事情
+func (mvcc *MVCC) Preserve(key, value []byte, expiresAt int64) {
 	rawValues, _ := mvcc.versions.LoadOrStore(string(key), &VersionedValues{})
 	versionedValues := rawValues.(*VersionedValues)
 	versionedValues.lock.Lock()
 	defer versionedValues.lock.Unlock()
 
-	versionedValues.values = append(versionedValues.values, vv)
+	if len(versionedValues.values) > 0 {
+		return
+	}
+	versionedValues.values = append(versionedValues.values, &VersionedValue{
+		value:     value,
+		commitTs:  0,
+		expiresAt: expiresAt,
+	})
 }
 
-// Commit 提交指定事务 ID 的版本
-func (mvcc *MVCC) Commit(txnID int64) error {
-	mvcc.versions.Range(func(key, value interface{}) bool {
-		versionedValues := value.(*VersionedValues)
+// Has 返回键是否有版本链
+func (mvcc *MVCC) Has(key []byte) bool {
+	_, ok := mvcc.versions.Load(string(key))
+	return ok
+}
 
-		versionedValues.lock.Lock()
-		for _, vv := range versionedValues.values {
-			if vv.timestamp == txnID && !vv.committed {
-				vv.committed = true
-			}
-		}
-		versionedValues.lock.Unlock()
+// Conflict 返回键是否有在 startTs 之后提交的版本
+func (mvcc *MVCC) Conflict(key []byte, startTs int64) bool {
+	rawValues, ok := mvcc.versions.Load(string(key))
+	if !ok {
+		return false
+	}
 
-		return true
-	})
-	return nil
+	versionedValues := rawValues.(*VersionedValues)
+	versionedValues.lock.RLock()
+	defer versionedValues.lock.RUnlock()
+
+	n := len(versionedValues.values)
+	return n > 0 && versionedValues.values[n-1].commitTs > startTs
 }
 
-// Abort 回滚指定事务 ID 的版本
-func (mvcc *MVCC) Abort(txnID int64) {
+// ConflictIn 返回是否有满足 match 的键在 startTs 之后提交了新版本
+func (mvcc *MVCC) ConflictIn(match func(key []byte) bool, startTs int64) bool {
+	conflict := false
 	mvcc.versions.Range(func(key, value interface{}) bool {
-		versionedValues := value.(*VersionedValues)
-
-		versionedValues.lock.Lock()
-		var newVersions []*VersionedValue
-		for _, vv := range versionedValues.values {
-			if vv.timestamp != txnID {
-				newVersions = append(newVersions, vv)
-			}
+		k := []byte(key.(string))
+		if !match(k) {
+			return true
 		}
-		versionedValues.values = newVersions
-		empty := len(newVersions) == 0
-		versionedValues.lock.Unlock()
+		conflict = mvcc.Conflict(k, startTs)
+		return !conflict
+	})
+	return conflict
+}
 
-		if empty {
-			mvcc.versions.Delete(key)
+// Snapshot 返回满足 match 的键在指定时间戳时的值，值为 nil 表示该键在此时不存在
+func (mvcc *MVCC) Snapshot(match func(key []byte) bool, ts int64) map[string][]byte {
+	result := make(map[string][]byte)
+	mvcc.versions.Range(func(key, value interface{}) bool {
+		k := []byte(key.(string))
+		if !match(k) {
+			return true
+		}
+		if v, ok := mvcc.Read(k, ts); ok {
+			result[string(k)] = v
 		}
-
 		return true
 	})
+	return result
 }
 
-// Cleanup 清理早于指定时间戳的未提交版本
-func (mvcc *MVCC) Cleanup(ts int64) {
+// Cleanup 清理所有键不再需要的旧版本，oldest 为最早的活跃读取的时间戳
+func (mvcc *MVCC) Cleanup(oldest int64) {
 	mvcc.versions.Range(func(key, value interface{}) bool {
-		versionedValues := value.(*VersionedValues)
+		mvcc.cleanup(key.(string), value.(*VersionedValues), oldest)
+		return true
+	})
+}
 
-		versionedValues.lock.Lock()
-		var newVersions []*VersionedValue
-		for _, vv := range versionedValues.values {
-			if vv.timestamp > ts || vv.committed {
-				newVersions = append(newVersions, vv)
-			}
+// CleanupKeys 清理指定键不再需要的旧版本
+func (mvcc *MVCC) CleanupKeys(keys []string, oldest int64) {
+	for _, k := range keys {
+		if rawValues, ok := mvcc.versions.Load(k); ok {
+			mvcc.cleanup(k, rawValues.(*VersionedValues), oldest)
 		}
-		versionedValues.values = newVersions
-		empty := len(newVersions) == 0
-		versionedValues.lock.Unlock()
+	}
+}
 
-		if empty {
-			mvcc.versions.Delete(key)
+// cleanup 只保留 oldest 能看到的版本以及之后提交的版本
+// 剩下的唯一版本对所有读取都可见时，它与底层存储中的值相同，整个版本链可以删除
+// 调用方需要保证清理期间没有新的版本写入
+func (mvcc *MVCC) cleanup(key string, versionedValues *VersionedValues, oldest int64) {
+	versionedValues.lock.Lock()
+	versions := versionedValues.values
+	visible := -1
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].commitTs <= oldest {
+			visible = i
+			break
 		}
+	}
+	if visible > 0 {
+		versionedValues.values = append([]*VersionedValue(nil), versions[visible:]...)
+	}
+	empty := len(versionedValues.values) == 0 ||
+		(len(versionedValues.values) == 1 && versionedValues.values[0].commitTs <= oldest)
+	versionedValues.lock.Unlock()
 
-		return true
-	})
+	if empty {
+		mvcc.versions.Delete(key)
+	}
 }
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// oracleLease 每次持久化时预留的时间戳数量
+const oracleLease = 10000
+
+// Oracle 单调递增的时间戳分配器，用于事务的开始时间戳、提交时间戳和事务 ID
+// 分配器按批预留时间戳并持久化预留的上界，重启后从上界继续分配，
+// 因此即使进程崩溃也不会重复使用已经分配过的时间戳
+type Oracle struct {
+	mutex    sync.Mutex
+	path     string
+	next     int64 // 下一个要分配的时间戳
+	limit    int64 // 已经持久化的上界（不包含）
+	readOnly bool  // 只读模式下不持久化，只在内存中分配
+}
+
+// OpenOracle 打开时间戳分配器，文件不存在时从 1 开始分配
+func OpenOracle(path string, readOnly bool) (*Oracle, error) {
+	o := &Oracle{
+		path:     path,
+		next:     1,
+		readOnly: readOnly,
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if len(data) != 12 || binary.BigEndian.Uint32(data[0:4]) != crc32.ChecksumIEEE(data[4:]) {
+			return nil, ErrInvalidOracle
+		}
+		o.next = int64(binary.BigEndian.Uint64(data[4:]))
+	}
+	o.limit = o.next
+	return o, nil
+}
+
+// Next 分配一个新的时间戳，持久化预留上界失败时返回错误，不分配时间戳
+func (o *Oracle) Next() (int64, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.next >= o.limit {
+		if err := o.reserve(o.next + oracleLease); err != nil {
+			return 0, fmt.Errorf("persist timestamp oracle %s: %w", o.path, err)
+		}
+	}
+	ts := o.next
+	o.next++
+	return ts, nil
+}
+
+// Observe 保证之后分配的时间戳都大于 ts，用于恢复时跳过 WAL 中已经使用过的时间戳
+func (o *Oracle) Observe(ts int64) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if ts < o.next {
+		return nil
+	}
+	o.next = ts + 1
+	if o.next >= o.limit {
+		return o.reserve(o.next + oracleLease)
+	}
+	return nil
+}
+
+// reserve 将预留上界持久化到磁盘，先写入临时文件再重命名，调用方需持有锁
+func (o *Oracle) reserve(limit int64) error {
+	if o.readOnly {
+		o.limit = limit
+		return nil
+	}
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint64(buf[4:], uint64(limit))
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	if err := writeFileAtomic(o.path, buf); err != nil {
+		return err
+	}
+	o.limit = limit
+	return nil
+}
+
+// writeFileAtomic 先写入临时文件并同步，再重命名为目标文件，保证文件要么是旧内容，要么是完整的新内容
+func writeFileAtomic(path string, data []byte) error {
+	tmpName := path + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir 同步目录，保证目录中的重命名持久化
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
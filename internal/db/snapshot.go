@@ -0,0 +1,132 @@
+package db
+
+import (
+	"errors"
+	"math"
+	"sync/atomic"
+
+	"FinnKV/internal/bitcask"
+)
+
+// Snapshot 数据库在某一时刻的只读视图，看不到之后提交的任何写入
+// 快照在释放之前会阻止 MVCC 清理它需要的旧版本；这些旧版本保存在内存中，
+// 底层存储的合并只会搬移最新的记录，因此也不会影响快照
+type Snapshot struct {
+	db       *DB
+	ts       int64
+	released int32
+}
+
+// Snapshot 创建当前时刻的快照，使用完毕后必须调用 Release
+func (db *DB) Snapshot() (*Snapshot, error) {
+	ts, err := db.acquireReader()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{db: db, ts: ts}, nil
+}
+
+// Timestamp 返回快照的时间戳
+func (s *Snapshot) Timestamp() int64 {
+	return s.ts
+}
+
+// Get 获取键在快照时的值
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if atomic.LoadInt32(&s.released) == 1 {
+		return nil, ErrSnapshotReleased
+	}
+	value, err := s.db.getAt(key, s.ts)
+	if errors.Is(err, bitcask.ErrKeyNotFound) {
+		return nil, errors.New("key not found")
+	}
+	return value, err
+}
+
+// Scan 返回遍历快照中 [start, end) 范围内键值对的迭代器，start 或 end 为 nil 表示不限制
+// 快照已经释放时返回的迭代器始终无效，Err 返回 ErrSnapshotReleased
+func (s *Snapshot) Scan(start, end []byte, opts ...bitcask.ScanOption) *Iterator {
+	if atomic.LoadInt32(&s.released) == 1 {
+		return failedIterator(ErrSnapshotReleased)
+	}
+	options := bitcask.NewScanOptions(opts...)
+	r := &keyRange{start: start, end: end}
+	var local []*localWrite
+	for k, v := range s.db.mvcc.Snapshot(r.contains, s.ts) {
+		local = append(local, &localWrite{key: []byte(k), value: v})
+	}
+	return newIterator(s.db.scanBase(start, end, options), local, options, s.resolve)
+}
+
+// PrefixScan 返回遍历快照中所有以 prefix 为前缀的键值对的迭代器
+func (s *Snapshot) PrefixScan(prefix []byte, opts ...bitcask.ScanOption) *Iterator {
+	return s.Scan(prefix, bitcask.PrefixEnd(prefix), opts...)
+}
+
+// ListKeys 按顺序返回快照中的所有键
+func (s *Snapshot) ListKeys() ([][]byte, error) {
+	if atomic.LoadInt32(&s.released) == 1 {
+		return nil, ErrSnapshotReleased
+	}
+	it := s.Scan(nil, nil)
+	defer it.Close()
+
+	var keys [][]byte
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys, nil
+}
+
+// Release 释放快照，之后 MVCC 可以清理快照需要的旧版本
+func (s *Snapshot) Release() {
+	if atomic.CompareAndSwapInt32(&s.released, 0, 1) {
+		s.db.releaseReader(s.ts)
+	}
+}
+
+// resolve 读取快照时键的值，用于遍历
+func (s *Snapshot) resolve(key []byte) ([]byte, error) {
+	return s.db.getAt(key, s.ts)
+}
+
+// acquireReader 分配一个时间戳并登记为活跃的读取，返回读取的时间戳
+// 登记和提交在同一把锁下进行，保证时间戳早于某次提交的读取一定能在提交之前被看到
+func (db *DB) acquireReader() (int64, error) {
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	ts, err := db.oracle.Next()
+	if err != nil {
+		return 0, err
+	}
+	db.readers[ts]++
+	return ts, nil
+}
+
+// releaseReader 取消一个读取的登记，最早的读取结束时清理不再需要的旧版本
+func (db *DB) releaseReader(ts int64) {
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	oldest := db.oldestReader()
+	db.readers[ts]--
+	if db.readers[ts] <= 0 {
+		delete(db.readers, ts)
+	}
+	if ts == oldest {
+		db.mvcc.Cleanup(db.oldestReader())
+	}
+}
+
+// oldestReader 返回最早的活跃读取的时间戳，没有活跃的读取时返回 math.MaxInt64
+// 调用方需持有提交锁
+func (db *DB) oldestReader() int64 {
+	var oldest int64 = math.MaxInt64
+	for ts := range db.readers {
+		if ts < oldest {
+			oldest = ts
+		}
+	}
+	return oldest
+}
@@ -4,14 +4,20 @@ import (
 	"FinnKV/internal/bitcask"
 	"errors"
 	"sync"
+	"time"
 )
 
 // Transaction 表示一个事务
+// 事务读取开始时的快照，结束时必须调用 Commit 或 Rollback，否则会一直阻止 MVCC 清理旧版本
 type Transaction struct {
 	db        *DB
 	writes    map[string][]byte
+	expires   map[string]int64 // 带有过期时间的写入，值为过期时间（Unix 纳秒）
 	startTs   int64
+	isolation IsolationLevel
+	reads     *readSet // 可串行化事务读取过的键和范围，快照隔离时为 nil
 	committed bool
+	finished  bool // 是否已经取消读取登记
 	lock      sync.Mutex
 }
 
@@ -21,7 +27,21 @@ func (tx *Transaction) Put(key, value []byte) error {
 	defer tx.lock.Unlock()
 
 	tx.writes[string(key)] = value
-	tx.db.mvcc.Write(key, value, tx.startTs)
+	delete(tx.expires, string(key))
+	return nil
+}
+
+// PutWithTTL 在事务中写入键值对，并在 ttl 之后过期
+func (tx *Transaction) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return bitcask.ErrInvalidTTL
+	}
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+
+	expiresAt := time.Now().Add(ttl).UnixNano()
+	tx.writes[string(key)] = value
+	tx.expires[string(key)] = expiresAt
 	return nil
 }
 
@@ -31,14 +51,50 @@ func (tx *Transaction) Get(key []byte) ([]byte, error) {
 	defer tx.lock.Unlock()
 
 	if value, ok := tx.writes[string(key)]; ok {
+		expiresAt := tx.expires[string(key)]
+		if value == nil || (expiresAt != 0 && expiresAt <= time.Now().UnixNano()) {
+			return nil, errors.New("key not found")
+		}
 		return value, nil
 	}
 
-	if value, ok := tx.db.mvcc.Read(key, tx.startTs); ok {
-		return value, nil
+	if tx.reads != nil {
+		tx.reads.addKey(key)
 	}
+	// 读取事务开始时的值，不会看到之后其他事务提交的写入
+	return tx.db.getAt(key, tx.startTs)
+}
 
-	return tx.db.Get(key)
+// Scan 在事务中遍历 [start, end) 范围内的键值对，结果包含事务本地尚未提交的写入
+// 其余的键读取事务开始时的值
+func (tx *Transaction) Scan(start, end []byte, opts ...bitcask.ScanOption) *Iterator {
+	tx.lock.Lock()
+	defer tx.lock.Unlock()
+
+	options := bitcask.NewScanOptions(opts...)
+	if tx.reads != nil {
+		tx.reads.addRange(start, end)
+	}
+	r := &keyRange{start: start, end: end}
+	var local []*localWrite
+	for k, v := range tx.writes {
+		key := []byte(k)
+		if !r.contains(key) {
+			continue
+		}
+		local = append(local, &localWrite{key: key, value: v, expiresAt: tx.expires[k]})
+	}
+	for k, v := range tx.db.mvcc.Snapshot(r.contains, tx.startTs) {
+		if _, ok := tx.writes[k]; !ok {
+			local = append(local, &localWrite{key: []byte(k), value: v})
+		}
+	}
+	return newIterator(tx.db.scanBase(start, end, options), local, options, tx.resolve)
+}
+
+// PrefixScan 在事务中遍历所有以 prefix 为前缀的键值对
+func (tx *Transaction) PrefixScan(prefix []byte, opts ...bitcask.ScanOption) *Iterator {
+	return tx.Scan(prefix, bitcask.PrefixEnd(prefix), opts...)
 }
 
 // Delete 在事务中删除键
@@ -47,7 +103,7 @@ func (tx *Transaction) Delete(key []byte) error {
 	defer tx.lock.Unlock()
 
 	tx.writes[string(key)] = nil
-	tx.db.mvcc.Write(key, nil, tx.startTs)
+	delete(tx.expires, string(key))
 	return nil
 }
 
@@ -60,17 +116,7 @@ func (tx *Transaction) Commit() error {
 		return errors.New("transaction already committed")
 	}
 
-	// 写入事务开始的 Entry
-	startEntry := &bitcask.Entry{
-		Type:      bitcask.EntryTypeTxnBegin,
-		TxnID:     tx.startTs,
-		Timestamp: tx.startTs,
-	}
-	if err := tx.db.wal.Write(startEntry); err != nil {
-		return err
-	}
-
-	// 写入所有的 Entry
+	entries := make([]*bitcask.Entry, 0, len(tx.writes))
 	for k, v := range tx.writes {
 		var entryType byte
 		if v == nil {
@@ -79,64 +125,101 @@ func (tx *Transaction) Commit() error {
 			entryType = bitcask.EntryTypePut
 		}
 
-		entry := &bitcask.Entry{
+		entries = append(entries, &bitcask.Entry{
 			Key:       []byte(k),
 			Value:     v,
-			Timestamp: tx.startTs,
+			Timestamp: time.Now().Unix(),
 			Type:      entryType,
 			TxnID:     tx.startTs,
-		}
-		if err := tx.db.wal.Write(entry); err != nil {
-			return err
-		}
+			ExpiresAt: tx.expires[k],
+		})
 	}
 
-	// 写入事务结束的 Entry
-	endEntry := &bitcask.Entry{
-		Type:      bitcask.EntryTypeTxnEnd,
-		TxnID:     tx.startTs,
-		Timestamp: tx.startTs,
+	// 检查写写冲突，可串行化事务还要检查读取过的键和范围
+	keys := make([]string, 0, len(tx.writes))
+	for k := range tx.writes {
+		keys = append(keys, k)
 	}
-	if err := tx.db.wal.Write(endEntry); err != nil {
+	err := tx.db.prepareCommit(tx.startTs, keys, tx.reads)
+	// 无论是否冲突，事务之后都不再按 startTs 读取
+	tx.finish()
+	if err != nil {
+		tx.abort()
 		return err
 	}
 
-	// 同步 WAL
-	if err := tx.db.wal.Sync(); err != nil {
+	// 写入 WAL 并将数据写入底层存储、布隆过滤器和 MVCC
+	// 数据已经通过 WAL 持久化，底层存储不需要逐个事务同步，重启时会从 WAL 重放
+	err = tx.db.commit(tx.startTs, entries)
+	tx.db.endCommit(keys)
+	if err != nil {
 		return err
 	}
 
-	// 将数据写入底层存储和布隆过滤器
-	for k, v := range tx.writes {
-		key := []byte(k)
-		if v == nil {
-			if err := tx.db.bitcask.Delete(key); err != nil {
-				return err
-			}
-			tx.db.bloom.Remove(key) // 从布隆过滤器中删除
-		} else {
-			if err := tx.db.bitcask.Put(key, v); err != nil {
-				return err
+	tx.committed = true
+	return nil
+}
+
+// prepareCommit 检查事务写入的键是否与其他事务冲突，没有冲突时将这些键登记为正在提交
+// 键在 startTs 之后有其他事务提交的版本，或者正被其他事务提交时，返回 ErrTxnConflict
+// reads 不为 nil 且事务有写入时，对读取过的键和范围做同样的检查
+func (db *DB) prepareCommit(startTs int64, keys []string, reads *readSet) error {
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	for _, k := range keys {
+		if txnID, ok := db.committing[k]; ok && txnID != startTs {
+			return ErrTxnConflict
+		}
+		if db.mvcc.Conflict([]byte(k), startTs) {
+			return ErrTxnConflict
+		}
+	}
+	// 只读事务不会造成写偏斜，无需检查读集合
+	if reads != nil && len(keys) > 0 {
+		for k, txnID := range db.committing {
+			if txnID != startTs && reads.contains([]byte(k)) {
+				return ErrTxnConflict
 			}
-			tx.db.bloom.Add(key) // 添加到布隆过滤器
+		}
+		if db.mvcc.ConflictIn(reads.contains, startTs) {
+			return ErrTxnConflict
 		}
 	}
-
-	// 同步底层存储
-	if err := tx.db.bitcask.Sync(); err != nil {
-		return err
+	for _, k := range keys {
+		db.committing[k] = startTs
 	}
+	return nil
+}
 
-	// 清理 MVCC 中的版本
-	if err := tx.db.mvcc.Commit(tx.startTs); err != nil {
-		return err
+// endCommit 结束提交，取消键的正在提交状态
+func (db *DB) endCommit(keys []string) {
+	db.commitLock.Lock()
+	defer db.commitLock.Unlock()
+
+	for _, k := range keys {
+		delete(db.committing, k)
 	}
+	db.commitEnd.Broadcast()
+}
+
+// resolve 读取事务开始时键的值，用于遍历
+func (tx *Transaction) resolve(key []byte) ([]byte, error) {
+	return tx.db.getAt(key, tx.startTs)
+}
 
-	// 清理过期的版本
-	tx.db.mvcc.Cleanup(tx.startTs)
+// abort 丢弃事务的写入，调用方需持有事务的锁
+func (tx *Transaction) abort() {
+	tx.writes = make(map[string][]byte)
+	tx.expires = make(map[string]int64)
+}
 
-	tx.committed = true
-	return nil
+// finish 取消事务的读取登记，之后 MVCC 可以清理事务需要的旧版本，调用方需持有事务的锁
+func (tx *Transaction) finish() {
+	if !tx.finished {
+		tx.db.releaseReader(tx.startTs)
+		tx.finished = true
+	}
 }
 
 // Rollback 回滚事务
@@ -148,8 +231,7 @@ func (tx *Transaction) Rollback() error {
 		return errors.New("transaction already committed")
 	}
 
-	// 清理未提交的版本
-	tx.db.mvcc.Abort(tx.startTs)
-	tx.writes = make(map[string][]byte)
+	tx.abort()
+	tx.finish()
 	return nil
 }
@@ -1,106 +1,359 @@
 package db
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"FinnKV/internal/bitcask"
 )
 
+const (
+	walSegmentSuffix   = ".wal"
+	walCheckpointName  = "CHECKPOINT"
+	legacyWALFileName  = "wal.log"
+	walCheckpointBytes = 4 + 8 // checksum(4) + segmentID(8)
+)
+
 // WAL 表示写前日志
+// 日志由若干个按 ID 递增的段文件组成，活跃段达到大小上限后切换到新段
+// 检查点记录了一个段 ID，在它之前的段都已经应用到底层存储并持久化，可以删除
 type WAL struct {
-	file  *os.File
-	mutex sync.Mutex
+	dir        string
+	file       *os.File // 活跃段，只读模式下或失效后为 nil
+	failed     error    // 活跃段失效的原因，之后的写入都返回该错误
+	segmentID  int64    // 活跃段的 ID
+	size       int64    // 活跃段的大小
+	checkpoint int64    // 检查点，小于它的段都已经不再需要
+	lsn        uint64   // 最后分配的日志序列号
+	mutex      sync.Mutex
+	options    *WALOptions
+
+	commitCh   chan *commitRequest // 等待组提交的请求
+	closeCh    chan struct{}
+	commitDone chan struct{}
+	closeOnce  sync.Once
+}
+
+// segmentFileName 返回段文件的路径
+func segmentFileName(dir string, segmentID int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%09d%s", segmentID, walSegmentSuffix))
 }
 
 // NewWAL 创建新的 WAL 实例
-func NewWAL(dir string) (*WAL, error) {
+// 每次打开都会创建一个新的活跃段，上次运行时崩溃留下的不完整记录只会出现在旧段的末尾
+func NewWAL(dir string, opts ...WALOption) (*WAL, error) {
+	options := defaultWALOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	err := os.MkdirAll(dir, 0755)
 	if err != nil {
 		return nil, err
 	}
 
-	walFilePath := filepath.Join(dir, "wal.log")
+	wal := &WAL{
+		dir:        dir,
+		options:    options,
+		commitCh:   make(chan *commitRequest),
+		closeCh:    make(chan struct{}),
+		commitDone: make(chan struct{}),
+	}
+	if !options.ReadOnly {
+		if err := wal.migrateLegacy(); err != nil {
+			return nil, err
+		}
+	}
+	if wal.checkpoint, err = readCheckpoint(dir); err != nil {
+		return nil, err
+	}
 
-	walFile, err := os.OpenFile(walFilePath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if !options.ReadOnly {
+		segments, err := wal.segments()
+		if err != nil {
+			return nil, err
+		}
+		next := wal.checkpoint
+		if len(segments) > 0 && segments[len(segments)-1] >= next {
+			next = segments[len(segments)-1] + 1
+		}
+		if err := wal.openSegment(next); err != nil {
+			return nil, err
+		}
+	}
+	go wal.groupCommit()
+	return wal, nil
+}
+
+// migrateLegacy 将旧版本的单文件 WAL 作为第一个段
+func (wal *WAL) migrateLegacy() error {
+	legacy := filepath.Join(wal.dir, legacyWALFileName)
+	if _, err := os.Stat(legacy); os.IsNotExist(err) {
+		return nil
+	}
+	segments, err := wal.segments()
+	if err != nil {
+		return err
+	}
+	if len(segments) > 0 {
+		return os.Remove(legacy)
+	}
+	return os.Rename(legacy, segmentFileName(wal.dir, 0))
+}
+
+// segments 按 ID 从小到大返回所有段的 ID
+func (wal *WAL) segments() ([]int64, error) {
+	files, err := os.ReadDir(wal.dir)
 	if err != nil {
 		return nil, err
 	}
+	var ids []int64
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), walSegmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), walSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// openSegment 创建并切换到新的活跃段，调用方需持有锁
+func (wal *WAL) openSegment(segmentID int64) error {
+	file, err := os.OpenFile(segmentFileName(wal.dir, segmentID), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	wal.file = file
+	wal.segmentID = segmentID
+	wal.size = stat.Size()
+	return nil
+}
 
-	return &WAL{
-		file: walFile,
-	}, nil
+// rotate 同步并关闭活跃段，切换到下一个段，调用方需持有锁
+// 关闭活跃段后无法打开新段时 WAL 失效，不会留下已经关闭的文件
+func (wal *WAL) rotate() error {
+	if err := wal.file.Sync(); err != nil {
+		return err
+	}
+	closeErr := wal.file.Close()
+	if err := wal.openSegment(wal.segmentID + 1); err != nil {
+		wal.fail(err)
+		return err
+	}
+	return closeErr
 }
 
-// Write 将 Entry 写入 WAL
-func (wal *WAL) Write(entry *bitcask.Entry) error {
+// writable 返回活跃段不能写入的原因，调用方需持有锁
+func (wal *WAL) writable() error {
+	if wal.failed != nil {
+		return wal.failed
+	}
+	if wal.file == nil {
+		return ErrWALReadOnly
+	}
+	return nil
+}
+
+// Rotate 切换到新的活跃段，返回新段的 ID 以及最后分配的日志序列号，之前写入的记录都位于更小的段中
+// 活跃段为空时不切换，直接返回它的 ID
+func (wal *WAL) Rotate() (int64, uint64, error) {
 	wal.mutex.Lock()
 	defer wal.mutex.Unlock()
 
-	data := entry.Encode()
-	length := uint32(len(data))
-	buf := make([]byte, 4)
-	binary.BigEndian.PutUint32(buf, length)
-	_, err := wal.file.Write(buf)
-	if err != nil {
+	if err := wal.writable(); err != nil {
+		return 0, 0, err
+	}
+	if wal.size > 0 {
+		if err := wal.rotate(); err != nil {
+			return 0, 0, err
+		}
+	}
+	return wal.segmentID, wal.lsn, nil
+}
+
+// ObserveLSN 保证之后分配的日志序列号都大于 lsn
+func (wal *WAL) ObserveLSN(lsn uint64) {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if lsn > wal.lsn {
+		wal.lsn = lsn
+	}
+}
+
+// encodeLSN 将日志序列号编码为 TxnBegin 记录的值
+func encodeLSN(lsn uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, lsn)
+	return buf
+}
+
+// decodeLSN 从 TxnBegin 记录的值中解析日志序列号，旧版本写入的记录没有序列号，返回 0
+func decodeLSN(value []byte) uint64 {
+	if len(value) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(value)
+}
+
+// write 将数据追加到活跃段，调用方需持有锁
+func (wal *WAL) write(data []byte) error {
+	if err := wal.writable(); err != nil {
 		return err
 	}
-	_, err = wal.file.Write(data)
+	n, err := wal.file.Write(data)
+	wal.size += int64(n)
 	return err
 }
 
+// Write 将 Entry 写入 WAL
+func (wal *WAL) Write(entry *bitcask.Entry) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	return wal.write(encodeEntries([]*bitcask.Entry{entry}))
+}
+
+// WriteBatch 将一组 Entry 一次性写入 WAL
+// 所有记录先编码到同一个缓冲区，再通过一次写操作追加到文件末尾
+func (wal *WAL) WriteBatch(entries []*bitcask.Entry) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	return wal.write(encodeEntries(entries))
+}
+
+// encodeEntries 将一组 Entry 按 WAL 的格式编码到同一个缓冲区
+func encodeEntries(entries []*bitcask.Entry) []byte {
+	var buf bytes.Buffer
+	lengthBuf := make([]byte, 4)
+	for _, entry := range entries {
+		data := entry.Encode()
+		binary.BigEndian.PutUint32(lengthBuf, uint32(len(data)))
+		buf.Write(lengthBuf)
+		buf.Write(data)
+	}
+	return buf.Bytes()
+}
+
 // Sync 同步 WAL 到磁盘
 func (wal *WAL) Sync() error {
 	wal.mutex.Lock()
 	defer wal.mutex.Unlock()
 
+	if wal.file == nil {
+		return wal.failed
+	}
 	return wal.file.Sync()
 }
 
-// ReadAll 读取所有未提交的 Entry
+// ReadAll 读取检查点之后所有已提交的 Entry
+// 段末尾不完整或校验失败的记录视为写入时崩溃留下的残缺数据，该段读取到此为止，
+// 缺少 TxnEnd 的事务整体丢弃
 func (wal *WAL) ReadAll() ([]*bitcask.Entry, error) {
+	entries, _, err := wal.ReadAfter(0)
+	return entries, err
+}
+
+// ReadAfter 读取检查点之后日志序列号大于 lsn 的已提交的 Entry，同时返回读到的最大日志序列号
+// 旧版本写入的没有序列号的记录总是会被返回
+func (wal *WAL) ReadAfter(lsn uint64) ([]*bitcask.Entry, uint64, error) {
 	wal.mutex.Lock()
 	defer wal.mutex.Unlock()
 
-	if _, err := wal.file.Seek(0, io.SeekStart); err != nil {
-		return nil, err
+	segments, err := wal.segments()
+	if err != nil {
+		return nil, 0, err
+	}
+	var entries []*bitcask.Entry
+	var maxLSN uint64
+	for _, id := range segments {
+		if id < wal.checkpoint {
+			continue
+		}
+		segmentEntries, segmentLSN, err := readSegment(segmentFileName(wal.dir, id), lsn)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, segmentEntries...)
+		if segmentLSN > maxLSN {
+			maxLSN = segmentLSN
+		}
 	}
+	return entries, maxLSN, nil
+}
+
+// readSegment 读取一个段中日志序列号大于 lsn 的已提交的 Entry，同时返回段中最大的日志序列号
+func readSegment(filename string, lsn uint64) ([]*bitcask.Entry, uint64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
 
 	var entries []*bitcask.Entry
 	var txnEntries []*bitcask.Entry
 	var inTransaction bool
+	var txnLSN, maxLSN uint64
 
 	for {
 		lengthBuf := make([]byte, 4)
-		_, err := io.ReadFull(wal.file, lengthBuf)
-		if err == io.EOF {
+		_, err := io.ReadFull(r, lengthBuf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			break
 		}
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		length := binary.BigEndian.Uint32(lengthBuf)
 		data := make([]byte, length)
-		_, err = io.ReadFull(wal.file, data)
+		_, err = io.ReadFull(r, data)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 		entry, err := bitcask.DecodeEntry(data)
 		if err != nil {
-			return nil, err
+			break
 		}
 
 		switch entry.Type {
 		case bitcask.EntryTypeTxnBegin:
 			inTransaction = true
 			txnEntries = []*bitcask.Entry{}
+			txnLSN = decodeLSN(entry.Value)
+			if txnLSN > maxLSN {
+				maxLSN = txnLSN
+			}
 		case bitcask.EntryTypeTxnEnd:
 			if inTransaction {
-				entries = append(entries, txnEntries...)
+				if txnLSN == 0 || txnLSN > lsn {
+					entries = append(entries, txnEntries...)
+				}
 				inTransaction = false
 			}
 		default:
@@ -112,19 +365,77 @@ func (wal *WAL) ReadAll() ([]*bitcask.Entry, error) {
 			}
 		}
 	}
-	return entries, nil
+	return entries, maxLSN, nil
 }
 
-// Clear 清空 WAL 文件
-func (wal *WAL) Clear() error {
+// Checkpoint 记录检查点并删除 segmentID 之前的段
+// 调用方需要保证这些段中的记录都已经应用到底层存储并持久化
+func (wal *WAL) Checkpoint(segmentID int64) error {
 	wal.mutex.Lock()
 	defer wal.mutex.Unlock()
 
-	if err := wal.file.Truncate(0); err != nil {
+	if err := wal.writable(); err != nil {
 		return err
 	}
-	_, err := wal.file.Seek(0, io.SeekStart)
-	return err
+	if segmentID <= wal.checkpoint {
+		return nil
+	}
+	if segmentID > wal.segmentID {
+		return fmt.Errorf("checkpoint %d is beyond the active segment %d", segmentID, wal.segmentID)
+	}
+	if err := writeCheckpoint(wal.dir, segmentID); err != nil {
+		return err
+	}
+	wal.checkpoint = segmentID
+	return wal.removeObsolete()
+}
+
+// removeObsolete 删除检查点之前的段，调用方需持有锁
+func (wal *WAL) removeObsolete() error {
+	segments, err := wal.segments()
+	if err != nil {
+		return err
+	}
+	for _, id := range segments {
+		if id >= wal.checkpoint {
+			break
+		}
+		if err := os.Remove(segmentFileName(wal.dir, id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SegmentID 返回活跃段的 ID
+func (wal *WAL) SegmentID() int64 {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	return wal.segmentID
+}
+
+// readCheckpoint 读取检查点，文件不存在时返回 0
+func readCheckpoint(dir string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, walCheckpointName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != walCheckpointBytes || binary.BigEndian.Uint32(data[0:4]) != crc32.ChecksumIEEE(data[4:]) {
+		return 0, ErrInvalidCheckpoint
+	}
+	return int64(binary.BigEndian.Uint64(data[4:])), nil
+}
+
+// writeCheckpoint 持久化检查点，先写入临时文件再重命名
+func writeCheckpoint(dir string, segmentID int64) error {
+	buf := make([]byte, walCheckpointBytes)
+	binary.BigEndian.PutUint64(buf[4:], uint64(segmentID))
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+	return writeFileAtomic(filepath.Join(dir, walCheckpointName), buf)
 }
 
 func safeClose(file *os.File) error {
@@ -140,7 +451,17 @@ func safeClose(file *os.File) error {
 	return nil
 }
 
-// Close 关闭 WAL 文件
+// Close 停止组提交并关闭 WAL 文件
 func (wal *WAL) Close() error {
+	wal.closeOnce.Do(func() {
+		close(wal.closeCh)
+		<-wal.commitDone
+	})
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if wal.file == nil {
+		return nil
+	}
 	return safeClose(wal.file)
 }
@@ -0,0 +1,132 @@
+package algo
+
+import (
+	"FinnKV/internal/algo"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestART(t *testing.T) {
+	tree := algo.NewART[int]()
+	ref := make(map[string]int)
+	rng := rand.New(rand.NewSource(1))
+
+	// 键之间有较长的公共前缀，并且有的键是其他键的前缀，覆盖前缀拆分和节点在四种大小之间的切换
+	randomKey := func() string {
+		switch rng.Intn(3) {
+		case 0:
+			return fmt.Sprintf("user:%d", rng.Intn(500))
+		case 1:
+			return fmt.Sprintf("user:%d:name", rng.Intn(500))
+		default:
+			return string([]byte{byte(rng.Intn(256)), byte(rng.Intn(4))})
+		}
+	}
+	for i := 0; i < 30000; i++ {
+		key := randomKey()
+		_, existed := ref[key]
+		if rng.Intn(3) == 0 {
+			if deleted := tree.Delete(key); deleted != existed {
+				t.Fatalf("delete %q: expected %v, got %v", key, existed, deleted)
+			}
+			delete(ref, key)
+			continue
+		}
+		if replaced := tree.Put(key, i); replaced != existed {
+			t.Fatalf("put %q: expected %v, got %v", key, existed, replaced)
+		}
+		ref[key] = i
+	}
+
+	if tree.Len() != len(ref) {
+		t.Errorf("expected length %d, got %d", len(ref), tree.Len())
+	}
+	for i := 0; i < 5000; i++ {
+		key := randomKey()
+		value, found := tree.Get(key)
+		expected, ok := ref[key]
+		if found != ok || value != expected {
+			t.Fatalf("get %q: expected (%d, %v), got (%d, %v)", key, expected, ok, value, found)
+		}
+	}
+
+	expected := make([]string, 0, len(ref))
+	for key := range ref {
+		expected = append(expected, key)
+	}
+	sort.Strings(expected)
+
+	// 从任意位置开始的正向和反向遍历都与排序后的结果一致
+	for i := 0; i < 200; i++ {
+		start := randomKey()
+		var keys []string
+		tree.Ascend(start, func(key string, value int) bool {
+			keys = append(keys, key)
+			return true
+		})
+		pos := sort.SearchStrings(expected, start)
+		if len(keys)+len(expected[pos:]) > 0 && !reflect.DeepEqual(keys, expected[pos:]) {
+			t.Fatalf("ascend from %q returned wrong keys", start)
+		}
+
+		keys = keys[:0]
+		tree.Descend(start, func(key string, value int) bool {
+			keys = append(keys, key)
+			return true
+		})
+		end := sort.Search(len(expected), func(j int) bool { return expected[j] > start })
+		var want []string
+		for j := end - 1; j >= 0; j-- {
+			want = append(want, expected[j])
+		}
+		if len(keys)+len(want) > 0 && !reflect.DeepEqual(keys, want) {
+			t.Fatalf("descend from %q returned wrong keys", start)
+		}
+	}
+
+	for _, key := range expected {
+		tree.Delete(key)
+	}
+	if tree.Len() != 0 {
+		t.Errorf("expected empty tree, got %d keys", tree.Len())
+	}
+	tree.DescendAll(func(key string, value int) bool {
+		t.Errorf("unexpected key %q in empty tree", key)
+		return true
+	})
+}
+
+func TestARTPrefixKeys(t *testing.T) {
+	tree := algo.NewART[int]()
+	keys := []string{"", "a", "ab", "abc", "abd", "b"}
+	for i, key := range keys {
+		tree.Put(key, i)
+	}
+	for i, key := range keys {
+		if value, found := tree.Get(key); !found || value != i {
+			t.Errorf("expected %q to map to %d, got %d", key, i, value)
+		}
+	}
+
+	var got []string
+	tree.Ascend("ab", func(key string, value int) bool {
+		got = append(got, key)
+		return true
+	})
+	if !reflect.DeepEqual(got, []string{"ab", "abc", "abd", "b"}) {
+		t.Errorf("unexpected ascend result %q", got)
+	}
+
+	tree.Delete("ab")
+	got = got[:0]
+	tree.Descend("abc", func(key string, value int) bool {
+		got = append(got, key)
+		return true
+	})
+	if !reflect.DeepEqual(got, []string{"abc", "a", ""}) {
+		t.Errorf("unexpected descend result %q", got)
+	}
+}
@@ -0,0 +1,108 @@
+package algo
+
+import (
+	"FinnKV/internal/algo"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// sortedKeys 返回 map 中的键，reverse 为 true 时从大到小
+func sortedKeys(m map[int]int, reverse bool) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	if reverse {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	}
+	return keys
+}
+
+func TestBTree(t *testing.T) {
+	tree := algo.NewBTree[int, int](3, func(a, b int) bool { return a < b })
+	ref := make(map[int]int)
+	rng := rand.New(rand.NewSource(1))
+
+	// 小的度数让分裂、借键和合并都频繁发生
+	for i := 0; i < 20000; i++ {
+		key := rng.Intn(2000)
+		if rng.Intn(3) == 0 {
+			_, existed := ref[key]
+			if deleted := tree.Delete(key); deleted != existed {
+				t.Fatalf("delete %d: expected %v, got %v", key, existed, deleted)
+			}
+			delete(ref, key)
+			continue
+		}
+		_, existed := ref[key]
+		if replaced := tree.Put(key, i); replaced != existed {
+			t.Fatalf("put %d: expected %v, got %v", key, existed, replaced)
+		}
+		ref[key] = i
+	}
+
+	if tree.Len() != len(ref) {
+		t.Errorf("expected length %d, got %d", len(ref), tree.Len())
+	}
+	for key := -1; key <= 2000; key++ {
+		value, found := tree.Get(key)
+		expected, ok := ref[key]
+		if found != ok || value != expected {
+			t.Fatalf("get %d: expected (%d, %v), got (%d, %v)", key, expected, ok, value, found)
+		}
+	}
+
+	var keys []int
+	tree.Ascend(-1, func(key, value int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if !reflect.DeepEqual(keys, sortedKeys(ref, false)) {
+		t.Errorf("ascend returned keys out of order")
+	}
+	keys = keys[:0]
+	tree.DescendAll(func(key, value int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if !reflect.DeepEqual(keys, sortedKeys(ref, true)) {
+		t.Errorf("descend returned keys out of order")
+	}
+}
+
+func TestBTreeRange(t *testing.T) {
+	tree := algo.NewBTree[int, int](2, func(a, b int) bool { return a < b })
+	for i := 0; i < 100; i += 2 {
+		tree.Put(i, i)
+	}
+
+	var keys []int
+	tree.Ascend(41, func(key, value int) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	if !reflect.DeepEqual(keys, []int{42, 44, 46}) {
+		t.Errorf("expected [42 44 46], got %v", keys)
+	}
+
+	keys = keys[:0]
+	tree.Descend(42, func(key, value int) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	if !reflect.DeepEqual(keys, []int{42, 40, 38}) {
+		t.Errorf("expected [42 40 38], got %v", keys)
+	}
+
+	keys = keys[:0]
+	tree.Descend(-1, func(key, value int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 0 {
+		t.Errorf("expected no keys before -1, got %v", keys)
+	}
+}
@@ -2,6 +2,8 @@ package algo_test
 
 import (
 	"FinnKV/internal/algo"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -41,3 +43,113 @@ func TestLRUKCache(t *testing.T) {
 		t.Errorf("Expected 'a' to be in cache")
 	}
 }
+
+func TestSizedLRUKCache(t *testing.T) {
+	cache := algo.NewSizedLRUKCache(10, 2)
+
+	cache.SetWithSize("a", 1, 4)
+	cache.SetWithSize("b", 2, 4)
+	cache.Get("a")
+
+	// 超出字节数限制，淘汰只访问过一次的 "b"
+	cache.SetWithSize("c", 3, 4)
+	if _, ok := cache.Get("b"); ok {
+		t.Errorf("Expected 'b' to be evicted")
+	}
+	if cache.Size() != 8 {
+		t.Errorf("Expected size 8, got %d", cache.Size())
+	}
+
+	// 超过整个缓存容量的值不会被缓存
+	cache.SetWithSize("d", 4, 11)
+	if _, ok := cache.Get("d"); ok {
+		t.Errorf("Expected 'd' not to be cached")
+	}
+
+	cache.Delete("a")
+	if cache.Len() != 1 || cache.Size() != 4 {
+		t.Errorf("Expected 1 entry of size 4, got %d entries of size %d", cache.Len(), cache.Size())
+	}
+}
+
+func TestLRUKCacheHistoryAndEvictCallback(t *testing.T) {
+	var evicted []string
+	cache := algo.NewLRUKCache(10, 2, algo.WithHistorySize(2), algo.WithEvictCallback(func(key string, value interface{}) {
+		evicted = append(evicted, key)
+	}))
+
+	cache.Set("a", 1)
+	cache.Get("a")
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+
+	// 历史队列只保留两个访问不足 K 次的条目，"a" 已经访问过两次，不受影响
+	cache.Set("d", 4)
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("Expected 'b' to be evicted, got %v", evicted)
+	}
+
+	// Peek 不记录访问，"c" 仍然会被淘汰
+	if val, ok := cache.Peek("c"); !ok || val != 3 {
+		t.Errorf("Expected 3, got %v", val)
+	}
+	cache.Set("e", 5)
+	if _, ok := cache.Get("c"); ok {
+		t.Errorf("Expected 'c' to be evicted")
+	}
+
+	// 主动删除不会触发回调
+	if !cache.Delete("a") || cache.Delete("a") {
+		t.Errorf("Expected 'a' to be deleted once")
+	}
+	if len(evicted) != 2 || cache.Len() != 2 {
+		t.Errorf("Expected 2 evictions and 2 entries, got %v and %d", evicted, cache.Len())
+	}
+}
+
+func TestLRUKCacheTTL(t *testing.T) {
+	cache := algo.NewLRUKCache(10, 2)
+	cache.SetWithTTL("a", 1, 0, 20*time.Millisecond)
+	cache.Set("b", 2)
+
+	if _, ok := cache.Get("a"); !ok {
+		t.Errorf("Expected 'a' to be in cache")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := cache.Get("a"); ok {
+		t.Errorf("Expected 'a' to be expired")
+	}
+	if _, ok := cache.Get("b"); !ok {
+		t.Errorf("Expected 'b' to be in cache")
+	}
+}
+
+func TestShardedLRUKCache(t *testing.T) {
+	cache := algo.NewShardedLRUKCache(4, 400, 2)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				cache.Set(key, i)
+				cache.Get(key)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if cache.Len() > 400 {
+		t.Errorf("Expected at most 400 entries, got %d", cache.Len())
+	}
+	cache.Set("x", 1)
+	if val, ok := cache.Peek("x"); !ok || val != 1 {
+		t.Errorf("Expected 1, got %v", val)
+	}
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Errorf("Expected empty cache, got %d", cache.Len())
+	}
+}
@@ -0,0 +1,76 @@
+package algo
+
+import (
+	"fmt"
+	"testing"
+
+	"FinnKV/internal/algo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilterMarshal(t *testing.T) {
+	bf := algo.NewBloomFilter(1000, 0.01)
+	bf.Add([]byte("aaaa"))
+	bf.Add([]byte("bbbb"))
+	data, err := bf.MarshalBinary()
+	assert.NoError(t, err)
+
+	restored := algo.NewBloomFilter(1000, 0.01)
+	assert.NoError(t, restored.UnmarshalBinary(data))
+	assert.True(t, restored.Contains([]byte("aaaa")))
+	assert.True(t, restored.Contains([]byte("bbbb")))
+
+	// 参数不同的过滤器不能加载
+	assert.ErrorIs(t, algo.NewBloomFilter(2000, 0.01).UnmarshalBinary(data), algo.ErrBloomMismatch)
+}
+
+func TestScalableBloomFilter(t *testing.T) {
+	sbf := algo.NewScalableBloomFilter(100, 0.01)
+	for i := 0; i < 1000; i++ {
+		sbf.Add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	assert.Greater(t, sbf.Layers(), 1)
+	for i := 0; i < 1000; i++ {
+		assert.True(t, sbf.Contains([]byte(fmt.Sprintf("key-%d", i))))
+	}
+
+	// 扩展之后误判率仍然不超过初始误判率的两倍
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if sbf.Contains([]byte(fmt.Sprintf("other-%d", i))) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, float64(falsePositives)/10000, 0.02)
+
+	// 不支持删除，删除的元素仍然被判定为存在，不会影响其他元素
+	sbf.Remove([]byte("key-0"))
+	assert.True(t, sbf.Contains([]byte("key-0")))
+
+	data, err := sbf.MarshalBinary()
+	assert.NoError(t, err)
+	restored := algo.NewScalableBloomFilter(100, 0.01)
+	assert.NoError(t, restored.UnmarshalBinary(data))
+	assert.Equal(t, sbf.Layers(), restored.Layers())
+	assert.True(t, restored.Contains([]byte("key-999")))
+}
+
+func TestScalableBloomFilterOverwrite(t *testing.T) {
+	// 反复添加同一个元素不会添加新层
+	sbf := algo.NewScalableBloomFilter(1000, 0.01)
+	for i := 0; i < 100000; i++ {
+		sbf.Add([]byte("hot"))
+	}
+	assert.Equal(t, 1, sbf.Layers())
+
+	// 删除其他元素不会导致已添加的元素漏判
+	for i := 0; i < 5000; i++ {
+		sbf.Add([]byte(fmt.Sprintf("key-%d", i)))
+	}
+	for i := 0; i < 5000; i++ {
+		sbf.Remove([]byte(fmt.Sprintf("other-%d", i)))
+	}
+	for i := 0; i < 5000; i++ {
+		assert.True(t, sbf.Contains([]byte(fmt.Sprintf("key-%d", i))))
+	}
+}
@@ -66,3 +66,35 @@ func BenchmarkSkipListConcurrent(b *testing.B) {
 	}
 	wg.Wait()
 }
+
+func TestSkipListSeek(t *testing.T) {
+	lessFunc := func(a, b int) bool { return a < b }
+	skiplist := algo.NewSkipList[int, string](lessFunc)
+	for i := 0; i < 10; i += 2 {
+		skiplist.Add(i, strconv.Itoa(i))
+	}
+
+	collect := func(iter func() (int, string, bool)) []int {
+		var keys []int
+		for {
+			key, _, ok := iter()
+			if !ok {
+				return keys
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	if keys := collect(skiplist.Seek(3)); len(keys) != 3 || keys[0] != 4 || keys[2] != 8 {
+		t.Errorf("unexpected keys from Seek: %v", keys)
+	}
+	if keys := collect(skiplist.ReverseIterator()); len(keys) != 5 || keys[0] != 8 || keys[4] != 0 {
+		t.Errorf("unexpected keys from ReverseIterator: %v", keys)
+	}
+	if keys := collect(skiplist.SeekReverse(4)); len(keys) != 3 || keys[0] != 4 || keys[2] != 0 {
+		t.Errorf("unexpected keys from SeekReverse: %v", keys)
+	}
+	if keys := collect(skiplist.SeekReverse(-1)); len(keys) != 0 {
+		t.Errorf("unexpected keys from SeekReverse: %v", keys)
+	}
+}
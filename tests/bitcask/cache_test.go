@@ -0,0 +1,58 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueCache(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithValueCache(1024, 2))
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	assert.NoError(t, bc.Put([]byte("a"), []byte("1")))
+	for i := 0; i < 3; i++ {
+		value, err := bc.Get([]byte("a"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("1"), value)
+	}
+	stats := bc.CacheStats()
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(2), stats.Hits)
+
+	// 写入和删除使缓存失效
+	assert.NoError(t, bc.Put([]byte("a"), []byte("2")))
+	value, err := bc.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+	assert.NoError(t, bc.Delete([]byte("a")))
+	_, err = bc.Get([]byte("a"))
+	assert.ErrorIs(t, err, bitcask.ErrKeyNotFound)
+}
+
+func TestValueCacheSizeLimit(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithValueCache(256, 2), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		assert.NoError(t, bc.Put(key, []byte(fmt.Sprintf("value-%03d", i))))
+		_, err := bc.Get(key)
+		assert.NoError(t, err)
+	}
+	assert.LessOrEqual(t, bc.CacheStats().Size, int64(256))
+
+	// 合并移动记录之后仍然读到正确的值
+	assert.NoError(t, bc.Merge())
+	for i := 0; i < 100; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("value-%03d", i)), value)
+	}
+}
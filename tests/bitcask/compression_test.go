@@ -0,0 +1,97 @@
+package bitcask_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+// dataSize 返回目录中所有数据文件的总大小
+func dataSize(t *testing.T, dir string) int64 {
+	files, err := filepath.Glob(filepath.Join(dir, "*.data"))
+	assert.NoError(t, err)
+	var size int64
+	for _, f := range files {
+		info, err := os.Stat(f)
+		assert.NoError(t, err)
+		size += info.Size()
+	}
+	return size
+}
+
+func TestCompression(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"finn","tags":["a","b"]}`), 64)
+	for _, codec := range []bitcask.Compression{bitcask.CompressionSnappy, bitcask.CompressionZstd} {
+		t.Run(fmt.Sprintf("codec-%d", codec), func(t *testing.T) {
+			dir := t.TempDir()
+			bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithCompression(codec, 0))
+			assert.NoError(t, err)
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%d", i)), value))
+			}
+			// 小于阈值的值不压缩
+			assert.NoError(t, bc.Put([]byte("small"), []byte("v")))
+			assert.Less(t, dataSize(t, dir), int64(len(value)*10))
+			assert.NoError(t, bc.Merge())
+			assert.NoError(t, bc.Close())
+
+			bc, err = bitcask.Open(dir)
+			assert.NoError(t, err)
+			defer bc.Close()
+			got, err := bc.Get([]byte("key-3"))
+			assert.NoError(t, err)
+			assert.Equal(t, value, got)
+			got, err = bc.Get([]byte("small"))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("v"), got)
+		})
+	}
+}
+
+func TestCompressionMixedFiles(t *testing.T) {
+	dir := t.TempDir()
+	value := bytes.Repeat([]byte("plain"), 100)
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Put([]byte("raw"), value))
+	assert.NoError(t, bc.Close())
+
+	// 开启压缩后，没有压缩的旧记录仍然可以读取
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithCompression(bitcask.CompressionZstd, 0))
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Put([]byte("packed"), value))
+	for _, key := range []string{"raw", "packed"} {
+		got, err := bc.Get([]byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, value, got)
+	}
+	assert.NoError(t, bc.Close())
+}
+
+func TestMergeKeepsCompressedValues(t *testing.T) {
+	dir := t.TempDir()
+	value := bytes.Repeat([]byte("compressible"), 200)
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024),
+		bitcask.WithCompression(bitcask.CompressionSnappy, 0))
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), value))
+	}
+	assert.NoError(t, bc.Close())
+
+	// 关闭压缩后合并，压缩过的值原样复制，合并后的文件不会超过预留的文件 ID
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+	defer bc.Close()
+	assert.NoError(t, bc.Merge())
+	for i := 0; i < 100; i++ {
+		got, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, value, got)
+	}
+}
@@ -0,0 +1,173 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+// copyDir 复制目录中的文件，用于模拟进程在当前时刻崩溃后留下的数据目录
+func copyDir(t *testing.T, src string) string {
+	dst := t.TempDir()
+	entries, err := os.ReadDir(src)
+	assert.NoError(t, err)
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == "LOCK" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(src, e.Name()))
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(filepath.Join(dst, e.Name()), data, 0644))
+	}
+	return dst
+}
+
+func diskIndexOptions() []bitcask.Option {
+	// 页缓存只有 64 页，远小于索引的大小，读写都需要淘汰和重新读取页面
+	return []bitcask.Option{bitcask.WithReadWrite(), bitcask.WithMaxFileSize(256 << 10), bitcask.WithDiskIndex(64 * 4096)}
+}
+
+// checkDiskKeys 检查 [0, n) 中的键，deleted 返回 true 的键应当不存在
+func checkDiskKeys(t *testing.T, bc *bitcask.Bitcask, n int, deleted func(i int) bool) {
+	for i := 0; i < n; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%06d", i)))
+		if deleted(i) {
+			assert.ErrorIs(t, err, bitcask.ErrKeyNotFound)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%d", i), string(value))
+	}
+}
+
+func TestDiskIndex(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, diskIndexOptions()...)
+	assert.NoError(t, err)
+
+	const n = 20000
+	for i := 0; i < n; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	for i := 0; i < n; i += 3 {
+		assert.NoError(t, bc.Delete([]byte(fmt.Sprintf("key-%06d", i))))
+	}
+	deleted := func(i int) bool { return i%3 == 0 }
+	checkDiskKeys(t, bc, n, deleted)
+
+	stats := bc.IndexStats()
+	assert.Equal(t, n-n/3-1, stats.Keys)
+	assert.LessOrEqual(t, stats.Size, int64(64*4096))
+
+	it := bc.Scan([]byte("key-000100"), []byte("key-000106"), bitcask.WithReverse())
+	assert.Equal(t, []string{"key-000104", "key-000103", "key-000101", "key-000100"}, collectKeys(it))
+	assert.NoError(t, bc.Close())
+
+	// 干净地关闭后重新打开，索引从检查点恢复
+	bc, err = bitcask.Open(dir, diskIndexOptions()...)
+	assert.NoError(t, err)
+	assert.Equal(t, n-n/3-1, bc.IndexStats().Keys)
+	checkDiskKeys(t, bc, n, deleted)
+	keys, err := bc.ListKeys()
+	assert.NoError(t, err)
+	assert.Len(t, keys, n-n/3-1)
+
+	// 合并之后索引指向合并后的文件
+	assert.NoError(t, bc.Merge())
+	checkDiskKeys(t, bc, n, deleted)
+
+	// 删除大部分键，节点被合并，树的高度降低
+	for i := 0; i < 15000; i++ {
+		if !deleted(i) {
+			assert.NoError(t, bc.Delete([]byte(fmt.Sprintf("key-%06d", i))))
+		}
+	}
+	deleted = func(i int) bool { return i < 15000 || i%3 == 0 }
+	checkDiskKeys(t, bc, n, deleted)
+	assert.Equal(t, 3333, bc.IndexStats().Keys)
+	it = bc.Scan(nil, []byte("key-015003"))
+	assert.Equal(t, []string{"key-015001", "key-015002"}, collectKeys(it))
+	assert.NoError(t, bc.Close())
+
+	bc, err = bitcask.Open(dir, diskIndexOptions()...)
+	assert.NoError(t, err)
+	checkDiskKeys(t, bc, n, deleted)
+	assert.NoError(t, bc.Close())
+
+	_, err = os.Stat(filepath.Join(dir, "KEYDIR"))
+	assert.NoError(t, err)
+}
+
+func TestDiskIndexCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, diskIndexOptions()...)
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	for i := 0; i < 5000; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	assert.NoError(t, bc.Sync())
+
+	// 检查点之后只有少量写入，脏页还在缓存中，索引文件仍然是干净的，打开时从检查点重放
+	for i := 5000; i < 5100; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	assert.NoError(t, bc.Delete([]byte("key-000007")))
+	replayed := copyDir(t, dir)
+
+	// 大量写入使脏页被淘汰写回，索引文件被标记为未完成，打开时从数据文件重建
+	for i := 5100; i < 20000; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	rebuilt := copyDir(t, dir)
+
+	crashed, err := bitcask.Open(replayed, diskIndexOptions()...)
+	assert.NoError(t, err)
+	assert.Equal(t, 5099, crashed.IndexStats().Keys)
+	checkDiskKeys(t, crashed, 5100, func(i int) bool { return i == 7 })
+	assert.NoError(t, crashed.Close())
+
+	crashed, err = bitcask.Open(rebuilt, diskIndexOptions()...)
+	assert.NoError(t, err)
+	assert.Equal(t, 19999, crashed.IndexStats().Keys)
+	checkDiskKeys(t, crashed, 20000, func(i int) bool { return i == 7 })
+	assert.NoError(t, crashed.Close())
+}
+
+func TestDiskIndexRebuild(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, diskIndexOptions()...)
+	assert.NoError(t, err)
+	for i := 0; i < 3000; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	assert.NoError(t, bc.Close())
+
+	// 索引文件丢失或损坏时从数据文件重建
+	assert.NoError(t, os.Remove(filepath.Join(dir, "KEYDIR")))
+	bc, err = bitcask.Open(dir, diskIndexOptions()...)
+	assert.NoError(t, err)
+	checkDiskKeys(t, bc, 3000, func(int) bool { return false })
+	assert.NoError(t, bc.Close())
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "KEYDIR"), []byte("garbage"), 0644))
+	bc, err = bitcask.Open(dir, diskIndexOptions()...)
+	assert.NoError(t, err)
+	checkDiskKeys(t, bc, 3000, func(int) bool { return false })
+
+	// 超过长度限制的键在写入数据文件之前被拒绝
+	err = bc.Put(make([]byte, bitcask.MaxDiskKeySize+1), []byte("v"))
+	assert.ErrorIs(t, err, bitcask.ErrKeyTooLarge)
+	assert.NoError(t, bc.Close())
+
+	// 只读模式在临时文件中建立索引
+	reader, err := bitcask.Open(dir, bitcask.WithDiskIndex(0))
+	assert.NoError(t, err)
+	checkDiskKeys(t, reader, 3000, func(int) bool { return false })
+	assert.NoError(t, reader.Close())
+}
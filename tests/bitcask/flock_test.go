@@ -0,0 +1,62 @@
+package bitcask_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectoryLock(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Put([]byte("a"), []byte("1")))
+	assert.NoError(t, bc.Sync())
+
+	// 第二个写入者被拒绝
+	_, err = bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.ErrorIs(t, err, bitcask.ErrDatabaseLocked)
+	_, err = db.Open(dir, []bitcask.Option{bitcask.WithReadWrite()}, &db.Options{BloomFilterSize: 100, BloomFilterFP: 0.01})
+	assert.ErrorIs(t, err, bitcask.ErrDatabaseLocked)
+
+	// 只读模式可以与写入者同时打开
+	reader, err := bitcask.Open(dir)
+	assert.NoError(t, err)
+	value, err := reader.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	assert.NoError(t, reader.Close())
+
+	// 关闭后锁被释放
+	assert.NoError(t, bc.Close())
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Close())
+}
+
+func TestCloseReleasesLockOnError(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Put([]byte("a"), []byte("1")))
+
+	// 在活跃文件的 hint 文件位置放一个非空目录，关闭时写入 hint 文件失败
+	files, _ := filepath.Glob(filepath.Join(dir, "*.data"))
+	hint := strings.TrimSuffix(files[len(files)-1], ".data") + ".hint"
+	assert.NoError(t, os.MkdirAll(filepath.Join(hint, "blocked"), 0755))
+	assert.Error(t, bc.Close())
+
+	// 关闭失败时目录锁仍然被释放
+	assert.NoError(t, os.RemoveAll(hint))
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	value, err := bc.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	assert.NoError(t, bc.Close())
+}
@@ -0,0 +1,122 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeLegacyFiles 以格式版本 1 写入若干个数据文件，旧格式的记录与版本 1 一样使用 IEEE 校验和
+func writeLegacyFiles(t *testing.T, dir string, n int) {
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512), bitcask.WithFormatVersion(1))
+	assert.NoError(t, err)
+	for i := 0; i < n; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i))))
+	}
+	assert.NoError(t, bc.Close())
+}
+
+// stripHeaders 去掉数据文件的文件头并删除 hint 文件，模拟旧格式的数据目录
+func stripHeaders(t *testing.T, dir string) {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.data"))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(f, data[24:], 0644))
+	}
+	hints, _ := filepath.Glob(filepath.Join(dir, "*.hint"))
+	for _, f := range hints {
+		assert.NoError(t, os.Remove(f))
+	}
+}
+
+func TestDataFileHeader(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Put([]byte("a"), []byte("1")))
+	for _, stat := range bc.FileStats() {
+		assert.Equal(t, bitcask.DataFileVersion, stat.Version)
+	}
+	assert.NoError(t, bc.Close())
+
+	// 文件头损坏的数据文件无法打开
+	files, _ := filepath.Glob(filepath.Join(dir, "*.data"))
+	data, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	data[10] ^= 0xff
+	assert.NoError(t, os.WriteFile(files[0], data, 0644))
+	_, err = bitcask.Open(dir)
+	assert.ErrorIs(t, err, bitcask.ErrInvalidDataFile)
+}
+
+func TestLegacyFilesUpgradedByMerge(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyFiles(t, dir, 50)
+	stripHeaders(t, dir)
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512))
+	assert.NoError(t, err)
+	legacy := 0
+	for _, stat := range bc.FileStats() {
+		if stat.Version == 0 {
+			legacy++
+		}
+	}
+	assert.Greater(t, legacy, 1)
+
+	// 按策略合并时，即使没有文件达到失效阈值，旧格式的文件也会被重写
+	assert.NoError(t, bc.MergeByPolicy(bitcask.MergePolicy{DeadRatio: 1, MinFiles: 1}))
+	for _, stat := range bc.FileStats() {
+		assert.Equal(t, bitcask.DataFileVersion, stat.Version)
+	}
+	for i := 0; i < 50; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%03d", i), string(value))
+	}
+	assert.NoError(t, bc.Close())
+}
+
+func TestFormatVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyFiles(t, dir, 20)
+
+	_, err := bitcask.Open(dir, bitcask.WithFormatVersion(bitcask.DataFileVersion+1))
+	assert.ErrorIs(t, err, bitcask.ErrInvalidOptions)
+
+	// 版本 1 的文件使用 IEEE 校验和，新文件使用 CRC32C，两者可以同时读取
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512))
+	assert.NoError(t, err)
+	for i := 20; i < 40; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i))))
+	}
+	versions := make(map[uint16]int)
+	for _, stat := range bc.FileStats() {
+		versions[stat.Version]++
+	}
+	assert.Greater(t, versions[1], 0)
+	assert.Greater(t, versions[bitcask.DataFileVersion], 0)
+	assert.NoError(t, bc.Close())
+
+	// 没有 hint 文件时，重建索引和校验都按照各自的版本顺序扫描数据文件
+	hints, _ := filepath.Glob(filepath.Join(dir, "*.hint"))
+	for _, f := range hints {
+		assert.NoError(t, os.Remove(f))
+	}
+	bc, err = bitcask.Open(dir)
+	assert.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%03d", i), string(value))
+	}
+	found, err := bc.Scrub(bitcask.ScrubPolicy{})
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+	assert.NoError(t, bc.Close())
+}
@@ -0,0 +1,86 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHintFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+	for i := 0; i < 200; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i))))
+	}
+	for i := 0; i < 200; i += 2 {
+		assert.NoError(t, bc.Delete([]byte(fmt.Sprintf("key-%03d", i))))
+	}
+	assert.NoError(t, bc.Close())
+
+	dataFiles, _ := filepath.Glob(filepath.Join(dir, "*.data"))
+	hintFiles, _ := filepath.Glob(filepath.Join(dir, "*.hint"))
+	assert.Greater(t, len(dataFiles), 1)
+	assert.Equal(t, len(dataFiles), len(hintFiles))
+
+	check := func() {
+		bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+		assert.NoError(t, err)
+		defer bc.Close()
+
+		keys, err := bc.ListKeys()
+		assert.NoError(t, err)
+		assert.Len(t, keys, 100)
+		for i := 0; i < 200; i++ {
+			value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+			if i%2 == 0 {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value-%03d", i), string(value))
+			}
+		}
+	}
+	check()
+
+	// 损坏或缺失的 hint 文件应回退到扫描数据文件
+	assert.NoError(t, os.WriteFile(hintFiles[0], []byte("corrupted"), 0644))
+	assert.NoError(t, os.Remove(hintFiles[1]))
+	check()
+}
+
+func TestHintFilesReusedKeyBuffer(t *testing.T) {
+	dir := t.TempDir()
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	key := make([]byte, 7)
+	for i := 0; i < 10; i++ {
+		copy(key, fmt.Sprintf("key-%03d", i))
+		assert.NoError(t, bc.Put(key, []byte(fmt.Sprintf("value-%03d", i))))
+	}
+	copy(key, "key-000")
+	assert.NoError(t, bc.Delete(key))
+	copy(key, "key-xxx")
+	assert.NoError(t, bc.Close())
+
+	// 重新打开时从 hint 文件加载索引，键不能被之后写入缓冲区的内容覆盖
+	bc, err = bitcask.Open(dir)
+	assert.NoError(t, err)
+	defer bc.Close()
+	keys, err := bc.ListKeys()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 9)
+	for i := 1; i < 10; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%03d", i), string(value))
+	}
+	_, err = bc.Get([]byte("key-000"))
+	assert.ErrorIs(t, err, bitcask.ErrKeyNotFound)
+}
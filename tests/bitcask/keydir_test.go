@@ -0,0 +1,199 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+var indexModes = map[string]bitcask.IndexMode{
+	"skiplist": bitcask.IndexSkipList,
+	"compact":  bitcask.IndexCompact,
+	"hash":     bitcask.IndexHash,
+	"btree":    bitcask.IndexBTree,
+	"art":      bitcask.IndexART,
+	"disk":     bitcask.IndexDisk,
+}
+
+func TestIndexModes(t *testing.T) {
+	for name, mode := range indexModes {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := []bitcask.Option{bitcask.WithReadWrite(), bitcask.WithMaxFileSize(64 << 10), bitcask.WithIndexMode(mode)}
+			bc, err := bitcask.Open(dir, opts...)
+			assert.NoError(t, err)
+
+			// 乱序写入，覆盖写入一部分，再删除一部分
+			for i := 0; i < 2000; i++ {
+				n := (i * 7919) % 2000
+				assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%04d", n)), []byte(fmt.Sprintf("v%d", n))))
+			}
+			for i := 0; i < 2000; i += 3 {
+				assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("w%d", i))))
+			}
+			for i := 0; i < 2000; i += 2 {
+				assert.NoError(t, bc.Delete([]byte(fmt.Sprintf("key-%04d", i))))
+			}
+
+			check := func(bc *bitcask.Bitcask) {
+				assert.Equal(t, 1000, bc.IndexStats().Keys)
+				value, err := bc.Get([]byte("key-0003"))
+				assert.NoError(t, err)
+				assert.Equal(t, "w3", string(value))
+				_, err = bc.Get([]byte("key-0004"))
+				assert.ErrorIs(t, err, bitcask.ErrKeyNotFound)
+
+				it := bc.Scan([]byte("key-0100"), []byte("key-0110"))
+				assert.Equal(t, []string{"key-0101", "key-0103", "key-0105", "key-0107", "key-0109"}, collectKeys(it))
+				it = bc.Scan([]byte("key-0100"), []byte("key-0110"), bitcask.WithReverse())
+				assert.Equal(t, []string{"key-0109", "key-0107", "key-0105", "key-0103", "key-0101"}, collectKeys(it))
+				it = bc.Scan(nil, nil, bitcask.WithReverse(), bitcask.WithLimit(2))
+				assert.Equal(t, []string{"key-1999", "key-1997"}, collectKeys(it))
+				keys, err := bc.ListKeys()
+				assert.NoError(t, err)
+				assert.Len(t, keys, 1000)
+			}
+			check(bc)
+			assert.NoError(t, bc.Merge())
+			check(bc)
+			assert.NoError(t, bc.Close())
+
+			bc, err = bitcask.Open(dir, opts...)
+			assert.NoError(t, err)
+			check(bc)
+			assert.NoError(t, bc.Close())
+		})
+	}
+}
+
+func TestIndexStats(t *testing.T) {
+	overhead := make(map[bitcask.IndexMode]float64)
+	for _, mode := range indexModes {
+		bc, err := bitcask.Open(t.TempDir(), bitcask.WithReadWrite(), bitcask.WithIndexMode(mode))
+		assert.NoError(t, err)
+		for i := 0; i < 10000; i++ {
+			assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("v")))
+		}
+		stats := bc.IndexStats()
+		assert.Equal(t, 10000, stats.Keys)
+		assert.Equal(t, int64(90000), stats.KeyBytes)
+		overhead[mode] = stats.OverheadPerKey
+		assert.NoError(t, bc.Close())
+	}
+	assert.Less(t, overhead[bitcask.IndexCompact], overhead[bitcask.IndexSkipList])
+	assert.Less(t, overhead[bitcask.IndexHash], overhead[bitcask.IndexSkipList])
+
+	_, err := bitcask.Open(t.TempDir(), bitcask.WithReadWrite(), bitcask.WithIndexMode(bitcask.IndexHash), bitcask.WithMaxFileSize(4<<30))
+	assert.ErrorIs(t, err, bitcask.ErrInvalidOptions)
+}
+
+func TestIndexArenaCompaction(t *testing.T) {
+	for name, mode := range indexModes {
+		t.Run(name, func(t *testing.T) {
+			bc, err := bitcask.Open(t.TempDir(), bitcask.WithReadWrite(), bitcask.WithIndexMode(mode))
+			assert.NoError(t, err)
+			defer bc.Close()
+
+			// 删除大部分长键后，分配区会被重建，剩下的键仍然可以读取
+			key := func(i int) []byte {
+				return []byte(fmt.Sprintf("%01000d", i))
+			}
+			for i := 0; i < 3000; i++ {
+				assert.NoError(t, bc.Put(key(i), []byte("v")))
+			}
+			for i := 0; i < 3000; i++ {
+				if i%100 != 0 {
+					assert.NoError(t, bc.Delete(key(i)))
+				}
+			}
+			assert.Equal(t, 30, bc.IndexStats().Keys)
+			assert.Equal(t, int64(30000), bc.IndexStats().KeyBytes)
+			for i := 0; i < 3000; i += 100 {
+				_, err := bc.Get(key(i))
+				assert.NoError(t, err)
+			}
+			assert.Len(t, collectKeys(bc.Scan(nil, nil)), 30)
+		})
+	}
+}
+
+func TestIndexerIterators(t *testing.T) {
+	for name, mode := range indexModes {
+		if mode == bitcask.IndexDisk {
+			// 磁盘索引需要数据目录，由 TestIndexModes 覆盖
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			idx := bitcask.NewIndexer(mode)
+			for i := 0; i < 500; i++ {
+				idx.Put(fmt.Sprintf("key-%03d", i), &bitcask.EntryMetadata{FileID: 1, Offset: int64(i)})
+			}
+			assert.Equal(t, 500, idx.Len())
+			meta, ok := idx.Get("key-042")
+			assert.True(t, ok)
+			assert.Equal(t, int64(42), meta.Offset)
+
+			// 迭代期间删除后面的键，迭代器不会返回已经删除的键
+			next := idx.Seek("key-100")
+			var keys []string
+			for key, _, ok := next(); ok && len(keys) < 300; key, _, ok = next() {
+				keys = append(keys, key)
+				if len(keys) == 1 {
+					for i := 200; i < 500; i++ {
+						idx.Delete(fmt.Sprintf("key-%03d", i))
+					}
+				}
+			}
+			if mode != bitcask.IndexHash {
+				assert.Len(t, keys, 100)
+				assert.Equal(t, "key-199", keys[len(keys)-1])
+			}
+
+			next = idx.SeekReverse("key-150")
+			keys = keys[:0]
+			for key, _, ok := next(); ok; key, _, ok = next() {
+				keys = append(keys, key)
+			}
+			assert.Len(t, keys, 151)
+			assert.Equal(t, "key-150", keys[0])
+			assert.Equal(t, "key-000", keys[150])
+		})
+	}
+}
+
+func BenchmarkIndexPut(b *testing.B) {
+	for name, mode := range indexModes {
+		if mode == bitcask.IndexDisk {
+			continue
+		}
+		b.Run(name, func(b *testing.B) {
+			idx := bitcask.NewIndexer(mode)
+			meta := &bitcask.EntryMetadata{FileID: 1}
+			for i := 0; i < b.N; i++ {
+				idx.Put(fmt.Sprintf("key-%09d", (i*7919)%b.N), meta)
+			}
+		})
+	}
+}
+
+func BenchmarkIndexGet(b *testing.B) {
+	const keys = 100000
+	for name, mode := range indexModes {
+		if mode == bitcask.IndexDisk {
+			continue
+		}
+		b.Run(name, func(b *testing.B) {
+			idx := bitcask.NewIndexer(mode)
+			meta := &bitcask.EntryMetadata{FileID: 1}
+			for i := 0; i < keys; i++ {
+				idx.Put(fmt.Sprintf("key-%09d", i), meta)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				idx.Get(fmt.Sprintf("key-%09d", (i*7919)%keys))
+			}
+		})
+	}
+}
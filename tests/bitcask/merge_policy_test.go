@@ -0,0 +1,195 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+func totalDeadBytes(bc *bitcask.Bitcask) int64 {
+	var dead int64
+	for _, stat := range bc.FileStats() {
+		dead += stat.DeadBytes
+	}
+	return dead
+}
+
+func TestDeadBytesTracking(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("value")))
+	}
+	assert.Equal(t, int64(0), totalDeadBytes(bc))
+
+	// 覆盖和删除都会产生失效数据
+	assert.NoError(t, bc.Put([]byte("key-00"), []byte("value")))
+	assert.NoError(t, bc.Delete([]byte("key-01")))
+	dead := totalDeadBytes(bc)
+	assert.Greater(t, dead, int64(0))
+	assert.NoError(t, bc.Close())
+
+	// 重新打开后从 hint 文件恢复相同的统计
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+	assert.Equal(t, dead, totalDeadBytes(bc))
+	assert.NoError(t, bc.Close())
+}
+
+func TestMergeByPolicy(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	// 前半部分的键被反复覆盖，后半部分只写一次
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("cold-%03d", i)), []byte("value")))
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, bc.Put([]byte(fmt.Sprintf("hot-%03d", i)), []byte(fmt.Sprintf("value-%d", round))))
+		}
+	}
+	files := len(bc.FileStats())
+	dead := totalDeadBytes(bc)
+
+	// 达到阈值的文件数不足时不合并
+	policy := bitcask.MergePolicy{DeadRatio: 0.9, MinFiles: 100}
+	assert.NoError(t, bc.MergeByPolicy(policy))
+	assert.Equal(t, files, len(bc.FileStats()))
+	assert.Equal(t, dead, totalDeadBytes(bc))
+
+	policy = bitcask.MergePolicy{DeadRatio: 0.5, MinFiles: 1}
+	assert.NoError(t, bc.MergeByPolicy(policy))
+	assert.Less(t, totalDeadBytes(bc), dead)
+
+	for i := 0; i < 100; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("cold-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, "value", string(value))
+	}
+	for i := 0; i < 20; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("hot-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, "value-2", string(value))
+	}
+}
+
+func TestAutoMerge(t *testing.T) {
+	dir := t.TempDir()
+	policy := bitcask.DefaultMergePolicy()
+	policy.Interval = 10 * time.Millisecond
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512), bitcask.WithMergePolicy(policy))
+	assert.NoError(t, err)
+
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%d", i)), []byte(fmt.Sprintf("value-%d", round))))
+		}
+	}
+	assert.Eventually(t, func() bool {
+		return totalDeadBytes(bc) < 1024
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.NoError(t, bc.Close())
+
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	defer bc.Close()
+	for i := 0; i < 10; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, "value-9", string(value))
+	}
+}
+
+func TestMergeByPolicyConverges(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+
+	// 最早的文件中大部分是不会被删除的键，它不会被挑选，其中被删除的键的删除标记需要保留
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("gone-%02d", i)), []byte("value")))
+	}
+	for i := 0; i < 40; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("cold-%02d", i)), []byte("value")))
+	}
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 5; i++ {
+			key := []byte(fmt.Sprintf("gone-%02d", i))
+			assert.NoError(t, bc.Put(key, []byte(fmt.Sprintf("value-%d", round))))
+			assert.NoError(t, bc.Delete(key))
+		}
+	}
+
+	policy := bitcask.MergePolicy{DeadRatio: 0.5, MinFiles: 1}
+	var size int64
+	for _, stat := range bc.FileStats() {
+		size += stat.Size
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, bc.MergeByPolicy(policy))
+	}
+
+	// 多次合并后没有再需要合并的文件，文件数和总大小保持不变
+	stats := bc.FileStats()
+	var merged int64
+	for _, stat := range stats {
+		merged += stat.Size
+	}
+	assert.Less(t, merged, size)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, bc.MergeByPolicy(policy))
+		assert.Equal(t, stats, bc.FileStats())
+	}
+	assert.NoError(t, bc.Close())
+
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+	defer bc.Close()
+	for i := 0; i < 5; i++ {
+		_, err := bc.Get([]byte(fmt.Sprintf("gone-%02d", i)))
+		assert.ErrorIs(t, err, bitcask.ErrKeyNotFound)
+	}
+	for i := 0; i < 40; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("cold-%02d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, "value", string(value))
+	}
+}
+
+func TestMergeByPolicyEmptyActiveFile(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte(fmt.Sprintf("value-%d", round))))
+		}
+	}
+	assert.NoError(t, bc.Close())
+
+	// 重新打开后活跃文件为空，合并不会把它封存成只有文件头的文件
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(1024))
+	assert.NoError(t, err)
+	defer bc.Close()
+	policy := bitcask.MergePolicy{DeadRatio: 0.5, MinFiles: 1}
+	assert.NoError(t, bc.MergeByPolicy(policy))
+
+	stats := bc.FileStats()
+	empty := stats[len(stats)-1].Size
+	for _, stat := range stats[:len(stats)-1] {
+		assert.Greater(t, stat.Size, empty)
+	}
+	for i := 0; i < 20; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%02d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, "value-4", string(value))
+	}
+}
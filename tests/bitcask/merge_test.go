@@ -0,0 +1,106 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(4096))
+	assert.NoError(t, err)
+
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%d-%03d", round, i))))
+		}
+	}
+	for i := 0; i < 200; i += 3 {
+		assert.NoError(t, bc.Delete([]byte(fmt.Sprintf("key-%03d", i))))
+	}
+
+	// 合并期间继续写入
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i += 2 {
+			assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-new-%03d", i))))
+		}
+	}()
+	assert.NoError(t, bc.Merge())
+	wg.Wait()
+
+	check := func(bc *bitcask.Bitcask) {
+		for i := 0; i < 200; i++ {
+			value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+			switch {
+			case i%2 == 0:
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value-new-%03d", i), string(value))
+			case i%3 == 0:
+				assert.Error(t, err)
+			default:
+				assert.NoError(t, err)
+				assert.Equal(t, fmt.Sprintf("value-4-%03d", i), string(value))
+			}
+		}
+	}
+	check(bc)
+
+	_, err = os.Stat(filepath.Join(dir, "merge"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, bc.Close())
+
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(4096))
+	assert.NoError(t, err)
+	check(bc)
+	assert.NoError(t, bc.Close())
+}
+
+func TestMergeRecoveryOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Put([]byte("a"), []byte("1")))
+	assert.NoError(t, bc.Close())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.data"))
+	assert.Len(t, files, 1)
+	data, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+
+	// 没有标记文件的合并目录会被丢弃
+	mergeDir := filepath.Join(dir, "merge")
+	assert.NoError(t, os.MkdirAll(mergeDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(mergeDir, "000000100.data"), data, 0644))
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	assert.NoError(t, bc.Close())
+	_, err = os.Stat(mergeDir)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "000000100.data"))
+	assert.True(t, os.IsNotExist(err))
+
+	// 带有标记文件的合并目录会在打开时完成替换
+	assert.NoError(t, os.MkdirAll(mergeDir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(mergeDir, "000000100.data"), data, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(mergeDir, "MERGE_COMMIT"), []byte("1\n"), 0644))
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	_, err = os.Stat(files[0])
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "000000100.data"))
+	assert.NoError(t, err)
+	value, err := bc.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+}
@@ -0,0 +1,53 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMMapReads(t *testing.T) {
+	dir := t.TempDir()
+	opts := []bitcask.Option{bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512), bitcask.WithMMap()}
+	bc, err := bitcask.Open(dir, opts...)
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i))))
+	}
+	check := func(bc *bitcask.Bitcask) {
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key-%03d", i))
+			expected := fmt.Sprintf("value-%03d", i)
+			value, err := bc.Get(key)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, string(value))
+			assert.NoError(t, bc.View(key, func(value []byte) error {
+				assert.Equal(t, expected, string(value))
+				return nil
+			}))
+		}
+		count := bc.Fold(func(key, value []byte, acc interface{}) interface{} {
+			return acc.(int) + 1
+		}, 0)
+		assert.Equal(t, 100, count)
+	}
+	check(bc)
+
+	// 合并替换文件后重新映射
+	for i := 0; i < 100; i += 2 {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i))))
+	}
+	assert.NoError(t, bc.Merge())
+	check(bc)
+	assert.NoError(t, bc.Close())
+
+	bc, err = bitcask.Open(dir, opts...)
+	assert.NoError(t, err)
+	check(bc)
+	err = bc.View([]byte("missing"), func(value []byte) error { return nil })
+	assert.ErrorIs(t, err, bitcask.ErrKeyNotFound)
+	assert.NoError(t, bc.Close())
+}
@@ -0,0 +1,170 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeFiles 写入若干数据文件并关闭，返回按文件 ID 排序的数据文件路径
+func writeFiles(t *testing.T, dir string, n int) []string {
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512))
+	assert.NoError(t, err)
+	for i := 0; i < n; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%03d", i))))
+	}
+	assert.NoError(t, bc.Close())
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.data"))
+	return files
+}
+
+func TestRecoveryTruncateTornTail(t *testing.T) {
+	dir := t.TempDir()
+	files := writeFiles(t, dir, 50)
+	last := files[len(files)-1]
+
+	// 模拟崩溃：活跃文件尾部留下半条记录，且没有 hint 文件
+	f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{0x01, 0x02, 0x03, 0x04, 0x00, 0x00})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.NoError(t, os.Remove(last[:len(last)-len(".data")]+".hint"))
+
+	_, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512))
+	assert.Error(t, err)
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512),
+		bitcask.WithRecoveryMode(bitcask.RecoveryModeTruncate))
+	assert.NoError(t, err)
+	report := bc.RecoveryReport()
+	assert.Len(t, report.TruncatedFiles, 1)
+	assert.Equal(t, int64(6), report.TruncatedFiles[0].DroppedBytes)
+	assert.True(t, report.TruncatedFiles[0].Applied)
+	for i := 0; i < 50; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%03d", i), string(value))
+	}
+	assert.NoError(t, bc.Close())
+
+	// 截断后再次打开不需要恢复
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	assert.True(t, bc.RecoveryReport().Clean())
+	assert.NoError(t, bc.Close())
+}
+
+func TestRecoveryStrictQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	files := writeFiles(t, dir, 50)
+	assert.Greater(t, len(files), 2)
+
+	// 损坏第一个封存文件中的一个字节
+	first := files[0]
+	data, err := os.ReadFile(first)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(first, data, 0644))
+	assert.NoError(t, os.Remove(first[:len(first)-len(".data")]+".hint"))
+
+	_, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithRecoveryMode(bitcask.RecoveryModeTruncate))
+	assert.ErrorIs(t, err, bitcask.ErrInvalidChecksum)
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithRecoveryMode(bitcask.RecoveryModeStrict))
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	report := bc.RecoveryReport()
+	assert.Len(t, report.QuarantinedFiles, 1)
+	assert.Equal(t, filepath.Join(dir, "quarantine", filepath.Base(first)), report.QuarantinedFiles[0].Path)
+	_, err = os.Stat(first)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = bc.Get([]byte("key-000"))
+	assert.Error(t, err)
+	value, err := bc.Get([]byte("key-049"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value-049", string(value))
+}
+
+func TestRecoveryResumesActiveFile(t *testing.T) {
+	dir := t.TempDir()
+	files := writeFiles(t, dir, 50)
+	last := files[len(files)-1]
+
+	f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{0x01, 0x02, 0x03})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.NoError(t, os.Remove(last[:len(last)-len(".data")]+".hint"))
+
+	// 截断不完整的尾部后继续写入上次的活跃文件，不创建新文件
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512),
+		bitcask.WithRecoveryMode(bitcask.RecoveryModeTruncate))
+	assert.NoError(t, err)
+	assert.Len(t, bc.FileStats(), len(files))
+	assert.NoError(t, bc.Put([]byte("key-050"), []byte("value-050")))
+	assert.NoError(t, bc.Close())
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.data"))
+	assert.Equal(t, files, matches)
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512))
+	assert.NoError(t, err)
+	defer bc.Close()
+	assert.True(t, bc.RecoveryReport().Clean())
+	for i := 0; i <= 50; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%03d", i), string(value))
+	}
+}
+
+func TestRecoveryStrictReportsResurrectedKeys(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512))
+	assert.NoError(t, err)
+	// fill 写入新的键，直到切换到新的活跃文件
+	filled := 0
+	fill := func() {
+		files := len(bc.FileStats())
+		for len(bc.FileStats()) == files {
+			assert.NoError(t, bc.Put([]byte(fmt.Sprintf("fill-%03d", filled)), []byte("value")))
+			filled++
+		}
+	}
+	assert.NoError(t, bc.Put([]byte("deleted"), []byte("old")))
+	assert.NoError(t, bc.Put([]byte("updated"), []byte("old")))
+	fill()
+	assert.NoError(t, bc.Delete([]byte("deleted")))
+	assert.NoError(t, bc.Put([]byte("updated"), []byte("new")))
+	assert.NoError(t, bc.Delete([]byte("missing")))
+	fill()
+	assert.NoError(t, bc.Close())
+
+	// 损坏删除标记所在文件的最后一条记录，隔离后其中的删除和覆盖都会丢失
+	files, _ := filepath.Glob(filepath.Join(dir, "*.data"))
+	target := files[len(files)-2]
+	data, err := os.ReadFile(target)
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(target, data, 0644))
+	assert.NoError(t, os.Remove(target[:len(target)-len(".data")]+".hint"))
+
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithRecoveryMode(bitcask.RecoveryModeStrict))
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	report := bc.RecoveryReport()
+	assert.Len(t, report.QuarantinedFiles, 1)
+	assert.ElementsMatch(t, [][]byte{[]byte("deleted"), []byte("updated")}, report.QuarantinedFiles[0].ResurrectedKeys)
+	value, err := bc.Get([]byte("deleted"))
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(value))
+}
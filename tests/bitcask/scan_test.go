@@ -0,0 +1,57 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectKeys(it *bitcask.Iterator) []string {
+	var keys []string
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	return keys
+}
+
+func TestScan(t *testing.T) {
+	bc, err := bitcask.Open(t.TempDir(), bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("a%d", i)), []byte(fmt.Sprintf("value-a%d", i))))
+		assert.NoError(t, bc.Put([]byte(fmt.Sprintf("b%d", i)), []byte(fmt.Sprintf("value-b%d", i))))
+	}
+	assert.NoError(t, bc.Delete([]byte("a5")))
+
+	it := bc.Scan([]byte("a3"), []byte("a7"))
+	assert.Equal(t, []string{"a3", "a4", "a6"}, collectKeys(it))
+
+	it = bc.Scan([]byte("a3"), []byte("a7"), bitcask.WithReverse())
+	assert.Equal(t, []string{"a6", "a4", "a3"}, collectKeys(it))
+
+	it = bc.PrefixScan([]byte("b"), bitcask.WithLimit(3))
+	assert.Equal(t, []string{"b0", "b1", "b2"}, collectKeys(it))
+	it.Rewind()
+	value, err := it.Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "value-b0", string(value))
+
+	it = bc.PrefixScan([]byte("b"), bitcask.WithReverse(), bitcask.WithLimit(2))
+	assert.Equal(t, []string{"b9", "b8"}, collectKeys(it))
+
+	it = bc.Scan(nil, nil)
+	it.Seek([]byte("a9"))
+	assert.Equal(t, "a9", string(it.Key()))
+	it.Next()
+	assert.Equal(t, "b0", string(it.Key()))
+
+	it = bc.Scan(nil, []byte("b"), bitcask.WithReverse())
+	it.Seek([]byte("a45"))
+	assert.Equal(t, []string{"a4", "a3", "a2", "a1", "a0"}, collectKeys(it))
+
+	assert.Len(t, collectKeys(bc.Scan(nil, nil)), 19)
+}
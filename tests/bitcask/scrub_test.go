@@ -0,0 +1,160 @@
+package bitcask_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+// corruptLastEntry 翻转第一个数据文件最后一个字节，损坏其中最后一条记录的值，返回被损坏的键
+func corruptLastEntry(t *testing.T, dir string, files []string) string {
+	data, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	assert.NoError(t, os.WriteFile(files[0], data, 0644))
+
+	bc, err := bitcask.Open(dir)
+	assert.NoError(t, err)
+	defer bc.Close()
+	var broken []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		if _, err := bc.Get([]byte(key)); err != nil {
+			assert.ErrorIs(t, err, bitcask.ErrInvalidChecksum)
+			broken = append(broken, key)
+		}
+	}
+	assert.Len(t, broken, 1)
+	return broken[0]
+}
+
+func TestScrubReportsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	files := writeFiles(t, dir, 50)
+	corruptLastEntry(t, dir, files)
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	var reported []*bitcask.CorruptionError
+	found, err := bc.Scrub(bitcask.ScrubPolicy{
+		OnCorruption: func(err *bitcask.CorruptionError) {
+			reported = append(reported, err)
+		},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, found, reported)
+	assert.Equal(t, int64(1), found[0].FileID)
+	assert.ErrorIs(t, found[0], bitcask.ErrInvalidChecksum)
+
+	stats := bc.ScrubStats()
+	assert.Equal(t, int64(1), stats.Runs)
+	// 最后一个文件重新打开后继续作为活跃文件，不参与校验；所有记录的大小相同，除损坏的一条外都校验通过
+	assert.Equal(t, int64(len(files)-1), stats.FilesScanned)
+	assert.Equal(t, stats.BytesScanned/found[0].Size-1, stats.EntriesVerified)
+	assert.Equal(t, int64(1), stats.CorruptRanges)
+	assert.Equal(t, found[0].Size, stats.CorruptBytes)
+	assert.Zero(t, stats.QuarantinedKeys)
+	assert.False(t, stats.LastRun.IsZero())
+}
+
+func TestScrubQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	files := writeFiles(t, dir, 50)
+	broken := corruptLastEntry(t, dir, files)
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512))
+	assert.NoError(t, err)
+	found, err := bc.Scrub(bitcask.ScrubPolicy{Quarantine: true, RateLimit: 1 << 20})
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, int64(1), bc.ScrubStats().QuarantinedKeys)
+
+	// 损坏的原始数据被复制到隔离目录，对应的键被删除，其他键不受影响
+	path := filepath.Join(dir, "quarantine", fmt.Sprintf("%09d-%d.corrupt", found[0].FileID, found[0].Offset))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Len(t, data, int(found[0].Size))
+	_, err = bc.Get([]byte(broken))
+	assert.ErrorIs(t, err, bitcask.ErrKeyNotFound)
+	assert.NoError(t, bc.Close())
+
+	// 删除标记在重新打开后仍然有效，合并会清理损坏的记录
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite(), bitcask.WithMaxFileSize(512))
+	assert.NoError(t, err)
+	defer bc.Close()
+	assert.NoError(t, bc.Merge())
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		value, err := bc.Get([]byte(key))
+		if key == broken {
+			assert.ErrorIs(t, err, bitcask.ErrKeyNotFound)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%03d", i), string(value))
+	}
+	found, err = bc.Scrub(bitcask.ScrubPolicy{})
+	assert.NoError(t, err)
+	assert.Empty(t, found)
+}
+
+func TestBackgroundScrubber(t *testing.T) {
+	dir := t.TempDir()
+	files := writeFiles(t, dir, 50)
+	corruptLastEntry(t, dir, files)
+
+	reported := make(chan *bitcask.CorruptionError, 16)
+	bc, err := bitcask.Open(dir, bitcask.WithScrubber(bitcask.ScrubPolicy{
+		Interval: 10 * time.Millisecond,
+		OnCorruption: func(err *bitcask.CorruptionError) {
+			reported <- err
+		},
+	}))
+	assert.NoError(t, err)
+
+	select {
+	case err := <-reported:
+		assert.Equal(t, int64(1), err.FileID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("scrubber did not report the corrupted entry")
+	}
+	assert.NoError(t, bc.Close())
+}
+
+func TestScrubDoesNotBlockMerge(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, 50)
+
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	defer bc.Close()
+
+	// 限速的校验持续较长时间，期间的合并不会被阻塞，被合并替换的文件会被跳过
+	done := make(chan error, 1)
+	go func() {
+		_, err := bc.Scrub(bitcask.ScrubPolicy{RateLimit: 1024})
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, bc.Merge())
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("scrub did not finish")
+	}
+	for i := 0; i < 50; i++ {
+		value, err := bc.Get([]byte(fmt.Sprintf("key-%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value-%03d", i), string(value))
+	}
+}
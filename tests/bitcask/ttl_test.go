@@ -0,0 +1,72 @@
+package bitcask_test
+
+import (
+	"testing"
+	"time"
+
+	"FinnKV/internal/bitcask"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntryExpireCompatibility(t *testing.T) {
+	// 不带过期时间的 Entry 与旧格式一致
+	plain := &bitcask.Entry{Key: []byte("k"), Value: []byte("v"), Type: bitcask.EntryTypePut}
+	assert.Len(t, plain.Encode(), 29+2)
+
+	expiring := &bitcask.Entry{Key: []byte("k"), Value: []byte("v"), Type: bitcask.EntryTypePut, ExpiresAt: 42}
+	buf := expiring.Encode()
+	assert.Len(t, buf, 29+8+2)
+
+	decoded, err := bitcask.DecodeEntry(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, bitcask.EntryTypePut, decoded.Type)
+	assert.Equal(t, int64(42), decoded.ExpiresAt)
+	assert.Equal(t, "v", string(decoded.Value))
+}
+
+func TestPutWithTTL(t *testing.T) {
+	dir := t.TempDir()
+	bc, err := bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, bc.PutWithTTL([]byte("bad"), []byte("v"), 0), bitcask.ErrInvalidTTL)
+	assert.NoError(t, bc.Put([]byte("persistent"), []byte("p")))
+	assert.NoError(t, bc.PutWithTTL([]byte("short"), []byte("s"), 50*time.Millisecond))
+	assert.NoError(t, bc.PutWithTTL([]byte("long"), []byte("l"), time.Hour))
+
+	value, err := bc.Get([]byte("short"))
+	assert.NoError(t, err)
+	assert.Equal(t, "s", string(value))
+	ttl, err := bc.TTL([]byte("persistent"))
+	assert.NoError(t, err)
+	assert.Equal(t, bitcask.NoTTL, ttl)
+	ttl, err = bc.TTL([]byte("long"))
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, 59*time.Minute)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = bc.Get([]byte("short"))
+	assert.Error(t, err)
+	_, err = bc.TTL([]byte("short"))
+	assert.Error(t, err)
+
+	keys, err := bc.ListKeys()
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2)
+	count := bc.Fold(func(key, value []byte, acc interface{}) interface{} {
+		return acc.(int) + 1
+	}, 0)
+	assert.Equal(t, 2, count)
+
+	// 过期时间通过 hint 文件和合并保留下来
+	assert.NoError(t, bc.Merge())
+	assert.NoError(t, bc.Close())
+	bc, err = bitcask.Open(dir, bitcask.WithReadWrite())
+	assert.NoError(t, err)
+	defer bc.Close()
+	_, err = bc.Get([]byte("short"))
+	assert.Error(t, err)
+	ttl, err = bc.TTL([]byte("long"))
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, 59*time.Minute)
+}
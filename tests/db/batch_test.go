@@ -0,0 +1,162 @@
+package db_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBatch(t *testing.T) {
+	dir := t.TempDir()
+	kvdb := openDB(t, dir)
+
+	assert.NoError(t, kvdb.Put([]byte("old"), []byte("v0")))
+
+	batch := kvdb.NewWriteBatch()
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, batch.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	assert.NoError(t, batch.Put([]byte("old"), []byte("v1")))
+	assert.NoError(t, batch.Delete([]byte("key000")))
+	assert.Equal(t, 102, batch.Len())
+
+	// 提交前批次中的写入不可见
+	_, err := kvdb.Get([]byte("key001"))
+	assert.Error(t, err)
+
+	assert.NoError(t, batch.Commit())
+	assert.Error(t, batch.Commit())
+
+	value, err := kvdb.Get([]byte("key001"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value1", string(value))
+	value, err = kvdb.Get([]byte("old"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", string(value))
+	_, err = kvdb.Get([]byte("key000"))
+	assert.Error(t, err)
+	assert.NoError(t, kvdb.Close())
+
+	kvdb = openDB(t, dir)
+	defer kvdb.Close()
+	value, err = kvdb.Get([]byte("key099"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value99", string(value))
+	_, err = kvdb.Get([]byte("key000"))
+	assert.Error(t, err)
+}
+
+func TestWriteBatchTooLarge(t *testing.T) {
+	kvdb, err := db.Open(t.TempDir(), []bitcask.Option{bitcask.WithReadWrite()}, &db.Options{
+		BloomFilterSize: 10000,
+		BloomFilterFP:   0.01,
+		MaxBatchSize:    1024,
+		MaxBatchCount:   4,
+	})
+	assert.NoError(t, err)
+	defer kvdb.Close()
+
+	batch := kvdb.NewWriteBatch()
+	assert.ErrorIs(t, batch.Put([]byte("big"), make([]byte, 2048)), db.ErrBatchTooLarge)
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, batch.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")))
+	}
+	assert.ErrorIs(t, batch.Put([]byte("key4"), []byte("value")), db.ErrBatchTooLarge)
+	assert.ErrorIs(t, batch.PutWithTTL([]byte("key4"), []byte("value"), 0), bitcask.ErrInvalidTTL)
+	assert.NoError(t, batch.Commit())
+}
+
+func TestRecoverBatchAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	kvdb := openDB(t, dir)
+	assert.NoError(t, kvdb.Close())
+
+	wal, err := db.NewWAL(filepath.Join(dir, "wal"))
+	assert.NoError(t, err)
+	put := func(txnID int64, key string) *bitcask.Entry {
+		return &bitcask.Entry{Key: []byte(key), Value: []byte("value"), Type: bitcask.EntryTypePut, TxnID: txnID}
+	}
+	// 完整的分组
+	assert.NoError(t, wal.WriteBatch([]*bitcask.Entry{
+		{Type: bitcask.EntryTypeTxnBegin, TxnID: 1},
+		put(1, "a"),
+		put(1, "b"),
+		{Type: bitcask.EntryTypeTxnEnd, TxnID: 1},
+	}))
+	// 缺少 TxnEnd 的分组
+	assert.NoError(t, wal.WriteBatch([]*bitcask.Entry{
+		{Type: bitcask.EntryTypeTxnBegin, TxnID: 2},
+		put(2, "c"),
+		put(2, "d"),
+	}))
+	assert.NoError(t, wal.Sync())
+	assert.NoError(t, wal.Close())
+
+	kvdb = openDB(t, dir)
+	defer kvdb.Close()
+	for _, key := range []string{"a", "b"} {
+		value, err := kvdb.Get([]byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, "value", string(value))
+	}
+	for _, key := range []string{"c", "d"} {
+		_, err := kvdb.Get([]byte(key))
+		assert.Error(t, err)
+	}
+
+	// 恢复后写入的数据不受残缺分组影响
+	assert.NoError(t, kvdb.PutWithTTL([]byte("e"), []byte("value"), time.Minute))
+	value, err := kvdb.Get([]byte("e"))
+	assert.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+}
+
+func TestWriteBatchOrderedWithTransactions(t *testing.T) {
+	dir := t.TempDir()
+	kvdb, err := db.Open(dir, []bitcask.Option{bitcask.WithReadWrite()}, &db.Options{
+		BloomFilterSize:    10000,
+		BloomFilterFP:      0.01,
+		CheckpointInterval: -1,
+	})
+	assert.NoError(t, err)
+	defer kvdb.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value := []byte(fmt.Sprintf("value%d", i))
+			if i%2 == 0 {
+				assert.NoError(t, kvdb.Put([]byte("key"), value))
+				return
+			}
+			batch := kvdb.NewWriteBatch()
+			assert.NoError(t, batch.Put([]byte("key"), value))
+			assert.NoError(t, batch.Commit())
+		}(i)
+	}
+	wg.Wait()
+
+	// 批次和事务对同一个键的写入按 WAL 中的顺序应用，重放 WAL 得到相同的最终值
+	wal, err := db.NewWAL(filepath.Join(dir, "wal"), db.WithWALReadOnly())
+	assert.NoError(t, err)
+	entries, err := wal.ReadAll()
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Close())
+	var last []byte
+	for _, entry := range entries {
+		if string(entry.Key) == "key" {
+			last = entry.Value
+		}
+	}
+	value, err := kvdb.Get([]byte("key"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(last), string(value))
+}
@@ -0,0 +1,60 @@
+package db_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilterPersisted(t *testing.T) {
+	dir := t.TempDir()
+	kvdb := openDB(t, dir)
+	assert.NoError(t, kvdb.Put([]byte("a"), []byte("1")))
+	assert.NoError(t, kvdb.Put([]byte("b"), []byte("2")))
+	assert.NoError(t, kvdb.Close())
+	_, err := os.Stat(filepath.Join(dir, "BLOOM"))
+	assert.NoError(t, err)
+
+	kvdb = openDB(t, dir)
+	value, err := kvdb.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+	assert.NoError(t, kvdb.Close())
+}
+
+func TestBloomFilterRebuiltWhenCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	kvdb := openDB(t, dir)
+	assert.NoError(t, kvdb.Put([]byte("a"), []byte("1")))
+	assert.NoError(t, kvdb.Close())
+
+	// 损坏的布隆过滤器文件不能使用，从现有的键重新构建
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "BLOOM"), []byte("corrupt"), 0644))
+	kvdb = openDB(t, dir)
+	value, err := kvdb.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+	assert.NoError(t, kvdb.Close())
+}
+
+func TestScalableBloomFilterOption(t *testing.T) {
+	dir := t.TempDir()
+	opts := &db.Options{BloomFilterSize: 10, BloomFilterFP: 0.01, ScalableBloomFilter: true}
+	kvdb, err := db.Open(dir, []bitcask.Option{bitcask.WithReadWrite()}, opts)
+	assert.NoError(t, err)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		assert.NoError(t, kvdb.Put([]byte(key), []byte(key)))
+	}
+	assert.NoError(t, kvdb.Close())
+
+	kvdb, err = db.Open(dir, []bitcask.Option{bitcask.WithReadWrite()}, opts)
+	assert.NoError(t, err)
+	value, err := kvdb.Get([]byte("l"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("l"), value)
+	assert.NoError(t, kvdb.Close())
+}
@@ -0,0 +1,71 @@
+package db_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxnWriteWriteConflict(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	assert.NoError(t, kvdb.Put([]byte("counter"), []byte("0")))
+
+	txn1, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	txn2, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, txn1.Put([]byte("counter"), []byte("1")))
+	assert.NoError(t, txn2.Put([]byte("counter"), []byte("2")))
+	assert.NoError(t, txn1.Commit())
+	assert.ErrorIs(t, txn2.Commit(), db.ErrTxnConflict)
+
+	value, err := kvdb.Get([]byte("counter"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+
+	// 重试的事务在冲突的提交之后开始，可以成功提交
+	txn3, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, txn3.Put([]byte("counter"), []byte("2")))
+	assert.NoError(t, txn3.Commit())
+	value, err = kvdb.Get([]byte("counter"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+}
+
+func TestTxnDisjointWritesCommit(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	txn1, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	txn2, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, txn1.Put([]byte("a"), []byte("1")))
+	assert.NoError(t, txn2.Put([]byte("b"), []byte("2")))
+	assert.NoError(t, txn2.Commit())
+	assert.NoError(t, txn1.Commit())
+}
+
+func TestConcurrentPutRetriesOnConflict(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, kvdb.Put([]byte("key"), []byte(fmt.Sprintf("value%d", i))))
+		}(i)
+	}
+	wg.Wait()
+
+	_, err := kvdb.Get([]byte("key"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,62 @@
+package db_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupCommitConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	kvdb, err := db.Open(dir, []bitcask.Option{bitcask.WithReadWrite()}, &db.Options{
+		BloomFilterSize: 10000,
+		BloomFilterFP:   0.01,
+		MaxSyncWait:     time.Millisecond,
+		MaxSyncBatch:    16,
+	})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			txn, err := kvdb.BeginTransaction()
+			assert.NoError(t, err)
+			assert.NoError(t, txn.Put([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%d", i))))
+			assert.NoError(t, txn.Commit())
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, kvdb.Close())
+
+	kvdb = openDB(t, dir)
+	defer kvdb.Close()
+	for i := 0; i < 64; i++ {
+		value, err := kvdb.Get([]byte(fmt.Sprintf("key%02d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("value%d", i), string(value))
+	}
+}
+
+func TestWALCommitAfterClose(t *testing.T) {
+	wal, err := db.NewWAL(filepath.Join(t.TempDir(), "wal"))
+	assert.NoError(t, err)
+
+	put := &bitcask.Entry{Key: []byte("a"), Value: []byte("value"), Type: bitcask.EntryTypePut}
+	_, err = wal.Commit([]*bitcask.Entry{put})
+	assert.NoError(t, err)
+	entries, err := wal.ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	assert.NoError(t, wal.Close())
+	_, err = wal.Commit([]*bitcask.Entry{put})
+	assert.ErrorIs(t, err, db.ErrWALClosed)
+}
@@ -0,0 +1,70 @@
+package db_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func commitGroup(t *testing.T, wal *db.WAL, key, value string) uint64 {
+	lsn, err := wal.Commit([]*bitcask.Entry{
+		{Type: bitcask.EntryTypeTxnBegin},
+		{Key: []byte(key), Value: []byte(value), Type: bitcask.EntryTypePut},
+		{Type: bitcask.EntryTypeTxnEnd},
+	})
+	assert.NoError(t, err)
+	return lsn
+}
+
+func TestRecoverSkipsAppliedRecords(t *testing.T) {
+	dir := t.TempDir()
+	kvdb := openDB(t, dir)
+	assert.NoError(t, kvdb.Put([]byte("a"), []byte("1")))
+	assert.NoError(t, kvdb.Put([]byte("b"), []byte("1")))
+	assert.NoError(t, kvdb.Delete([]byte("a")))
+	assert.NoError(t, kvdb.Close())
+
+	// 过期的 WAL 中的记录已经应用过，重放时不能复活已经删除的键
+	wal, err := db.NewWAL(filepath.Join(dir, "wal"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), commitGroup(t, wal, "a", "stale"))
+	// 序列号更大的记录还没有应用，需要重放
+	wal.ObserveLSN(100)
+	assert.Equal(t, uint64(101), commitGroup(t, wal, "b", "2"))
+	assert.NoError(t, wal.Close())
+
+	kvdb = openDB(t, dir)
+	_, err = kvdb.Get([]byte("a"))
+	assert.Error(t, err)
+	value, err := kvdb.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+
+	// 之后的提交继续使用更大的序列号
+	assert.NoError(t, kvdb.Put([]byte("c"), []byte("3")))
+	assert.NoError(t, kvdb.Close())
+
+	kvdb = openDB(t, dir)
+	defer kvdb.Close()
+	value, err = kvdb.Get([]byte("c"))
+	assert.NoError(t, err)
+	assert.Equal(t, "3", string(value))
+}
+
+func TestWALReadAfter(t *testing.T) {
+	wal, err := db.NewWAL(filepath.Join(t.TempDir(), "wal"))
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		commitGroup(t, wal, key, "value")
+	}
+	entries, maxLSN, err := wal.ReadAfter(2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), maxLSN)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "c", string(entries[0].Key))
+}
@@ -0,0 +1,63 @@
+package db_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOracleMonotonicAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "TIMESTAMP")
+	oracle, err := db.OpenOracle(path, false)
+	assert.NoError(t, err)
+
+	next := func() int64 {
+		ts, err := oracle.Next()
+		assert.NoError(t, err)
+		return ts
+	}
+
+	var last int64
+	for i := 0; i < 20000; i++ {
+		ts := next()
+		assert.Greater(t, ts, last)
+		last = ts
+	}
+
+	// 模拟崩溃后重启，不会重复使用已经分配过的时间戳
+	oracle, err = db.OpenOracle(path, false)
+	assert.NoError(t, err)
+	assert.Greater(t, next(), last)
+
+	assert.NoError(t, oracle.Observe(last+100000))
+	assert.Greater(t, next(), last+100000)
+	oracle, err = db.OpenOracle(path, false)
+	assert.NoError(t, err)
+	assert.Greater(t, next(), last+100000)
+}
+
+func TestOracleInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "TIMESTAMP")
+	assert.NoError(t, os.WriteFile(path, []byte("garbage"), 0644))
+	_, err := db.OpenOracle(path, false)
+	assert.ErrorIs(t, err, db.ErrInvalidOracle)
+}
+
+func TestOracleReserveError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "TIMESTAMP")
+	oracle, err := db.OpenOracle(path, false)
+	assert.NoError(t, err)
+
+	// 临时文件的位置被目录占用，预留上界无法持久化时返回错误，而不是退出进程
+	assert.NoError(t, os.MkdirAll(filepath.Join(path+".tmp", "blocked"), 0755))
+	_, err = oracle.Next()
+	assert.Error(t, err)
+
+	assert.NoError(t, os.RemoveAll(path+".tmp"))
+	ts, err := oracle.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), ts)
+}
@@ -0,0 +1,55 @@
+package db_test
+
+import (
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func collectPairs(t *testing.T, it *db.Iterator) []string {
+	var pairs []string
+	for ; it.Valid(); it.Next() {
+		value, err := it.Value()
+		assert.NoError(t, err)
+		pairs = append(pairs, string(it.Key())+"="+string(value))
+	}
+	return pairs
+}
+
+func TestTransactionScan(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	assert.NoError(t, kvdb.Put([]byte("user:1"), []byte("alice")))
+	assert.NoError(t, kvdb.Put([]byte("user:2"), []byte("bob")))
+	assert.NoError(t, kvdb.Put([]byte("user:4"), []byte("dave")))
+	assert.NoError(t, kvdb.Put([]byte("order:1"), []byte("x")))
+
+	assert.Equal(t, []string{"user:1=alice", "user:2=bob", "user:4=dave"},
+		collectPairs(t, kvdb.PrefixScan([]byte("user:"))))
+
+	txn, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, txn.Put([]byte("user:3"), []byte("carol")))
+	assert.NoError(t, txn.Put([]byte("user:1"), []byte("alice2")))
+	assert.NoError(t, txn.Delete([]byte("user:4")))
+
+	assert.Equal(t, []string{"user:1=alice2", "user:2=bob", "user:3=carol"},
+		collectPairs(t, txn.PrefixScan([]byte("user:"))))
+	assert.Equal(t, []string{"user:3=carol", "user:2=bob"},
+		collectPairs(t, txn.PrefixScan([]byte("user:"), bitcask.WithReverse(), bitcask.WithLimit(2))))
+
+	it := txn.Scan(nil, nil)
+	it.Seek([]byte("user:2"))
+	assert.Equal(t, []string{"user:2=bob", "user:3=carol"}, collectPairs(t, it))
+
+	// 事务外看不到未提交的写入
+	assert.Equal(t, []string{"user:1=alice", "user:2=bob", "user:4=dave"},
+		collectPairs(t, kvdb.PrefixScan([]byte("user:"))))
+
+	assert.NoError(t, txn.Commit())
+	assert.Equal(t, []string{"user:1=alice2", "user:2=bob", "user:3=carol"},
+		collectPairs(t, kvdb.PrefixScan([]byte("user:"))))
+}
@@ -0,0 +1,84 @@
+package db_test
+
+import (
+	"testing"
+
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+// withdraw 在事务中读取两个账户，只要余额总和足够就从 from 中扣款
+func withdraw(t *testing.T, txn *db.Transaction, from string) {
+	a, err := txn.Get([]byte("alice"))
+	assert.NoError(t, err)
+	b, err := txn.Get([]byte("bob"))
+	assert.NoError(t, err)
+	assert.Equal(t, "50", string(a))
+	assert.Equal(t, "50", string(b))
+	assert.NoError(t, txn.Put([]byte(from), []byte("-40")))
+}
+
+func TestSerializablePreventsWriteSkew(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	assert.NoError(t, kvdb.Put([]byte("alice"), []byte("50")))
+	assert.NoError(t, kvdb.Put([]byte("bob"), []byte("50")))
+
+	txn1, err := kvdb.BeginTransaction(db.WithIsolation(db.Serializable))
+	assert.NoError(t, err)
+	txn2, err := kvdb.BeginTransaction(db.WithIsolation(db.Serializable))
+	assert.NoError(t, err)
+	withdraw(t, txn1, "alice")
+	withdraw(t, txn2, "bob")
+	assert.NoError(t, txn1.Commit())
+	assert.ErrorIs(t, txn2.Commit(), db.ErrTxnConflict)
+}
+
+func TestSnapshotIsolationAllowsWriteSkew(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	assert.NoError(t, kvdb.Put([]byte("alice"), []byte("50")))
+	assert.NoError(t, kvdb.Put([]byte("bob"), []byte("50")))
+
+	txn1, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	txn2, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	withdraw(t, txn1, "alice")
+	withdraw(t, txn2, "bob")
+	assert.NoError(t, txn1.Commit())
+	assert.NoError(t, txn2.Commit())
+}
+
+func TestSerializableDetectsPhantom(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	assert.NoError(t, kvdb.Put([]byte("user:1"), []byte("a")))
+
+	txn, err := kvdb.BeginTransaction(db.WithIsolation(db.Serializable))
+	assert.NoError(t, err)
+	it := txn.PrefixScan([]byte("user:"))
+	count := 0
+	for ; it.Valid(); it.Next() {
+		count++
+	}
+	it.Close()
+	assert.Equal(t, 1, count)
+	assert.NoError(t, txn.Put([]byte("user:count"), []byte("1")))
+
+	// 其他事务在扫描范围内插入新键
+	assert.NoError(t, kvdb.Put([]byte("user:2"), []byte("b")))
+	assert.ErrorIs(t, txn.Commit(), db.ErrTxnConflict)
+
+	// 范围之外的写入不影响提交
+	txn, err = kvdb.BeginTransaction(db.WithIsolation(db.Serializable))
+	assert.NoError(t, err)
+	it = txn.PrefixScan([]byte("user:"))
+	it.Close()
+	assert.NoError(t, txn.Put([]byte("user:count"), []byte("2")))
+	assert.NoError(t, kvdb.Put([]byte("order:1"), []byte("c")))
+	assert.NoError(t, txn.Commit())
+}
@@ -0,0 +1,114 @@
+package db_test
+
+import (
+	"fmt"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotGet(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	assert.NoError(t, kvdb.Put([]byte("a"), []byte("1")))
+	assert.NoError(t, kvdb.Put([]byte("b"), []byte("2")))
+
+	snap, err := kvdb.Snapshot()
+	assert.NoError(t, err)
+	assert.NoError(t, kvdb.Put([]byte("a"), []byte("10")))
+	assert.NoError(t, kvdb.Delete([]byte("b")))
+	assert.NoError(t, kvdb.Put([]byte("c"), []byte("3")))
+
+	value, err := snap.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	value, err = snap.Get([]byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(value))
+	_, err = snap.Get([]byte("c"))
+	assert.Error(t, err)
+
+	keys, err := snap.ListKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, keys)
+
+	// 最新的读取不受快照影响
+	value, err = kvdb.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "10", string(value))
+
+	snap.Release()
+	_, err = snap.Get([]byte("a"))
+	assert.ErrorIs(t, err, db.ErrSnapshotReleased)
+	_, err = snap.ListKeys()
+	assert.ErrorIs(t, err, db.ErrSnapshotReleased)
+
+	// 释放后的遍历不会读取已经可以被清理的版本
+	for _, it := range []*db.Iterator{snap.Scan(nil, nil), snap.PrefixScan([]byte("a"))} {
+		assert.False(t, it.Valid())
+		assert.ErrorIs(t, it.Err(), db.ErrSnapshotReleased)
+		_, err = it.Value()
+		assert.ErrorIs(t, err, db.ErrSnapshotReleased)
+		it.Rewind()
+		assert.False(t, it.Valid())
+		it.Close()
+	}
+}
+
+func TestSnapshotScanAcrossMerge(t *testing.T) {
+	kvdb, err := db.Open(t.TempDir(), []bitcask.Option{
+		bitcask.WithReadWrite(),
+		bitcask.WithMaxFileSize(1024),
+	}, &db.Options{BloomFilterSize: 10000, BloomFilterFP: 0.01})
+	assert.NoError(t, err)
+	defer kvdb.Close()
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, kvdb.Put([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+
+	snap, err := kvdb.Snapshot()
+	assert.NoError(t, err)
+	defer snap.Release()
+	for i := 0; i < 50; i += 2 {
+		assert.NoError(t, kvdb.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("new")))
+	}
+	for i := 1; i < 50; i += 2 {
+		assert.NoError(t, kvdb.Delete([]byte(fmt.Sprintf("key%02d", i))))
+	}
+	assert.NoError(t, kvdb.Put([]byte("key99"), []byte("new")))
+	assert.NoError(t, kvdb.Merge())
+
+	it := snap.PrefixScan([]byte("key"))
+	defer it.Close()
+	count := 0
+	for ; it.Valid(); it.Next() {
+		value, err := it.Value()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("key%02d", count), string(it.Key()))
+		assert.Equal(t, fmt.Sprintf("v%d", count), string(value))
+		count++
+	}
+	assert.Equal(t, 50, count)
+}
+
+func TestTransactionReadsAtStart(t *testing.T) {
+	kvdb := openDB(t, t.TempDir())
+	defer kvdb.Close()
+
+	assert.NoError(t, kvdb.Put([]byte("a"), []byte("1")))
+	txn, err := kvdb.BeginTransaction()
+	assert.NoError(t, err)
+	assert.NoError(t, kvdb.Put([]byte("a"), []byte("2")))
+	assert.NoError(t, kvdb.Put([]byte("b"), []byte("3")))
+
+	value, err := txn.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	_, err = txn.Get([]byte("b"))
+	assert.Error(t, err)
+	assert.NoError(t, txn.Rollback())
+}
@@ -0,0 +1,42 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func openDB(t *testing.T, dir string) *db.DB {
+	kvdb, err := db.Open(dir, []bitcask.Option{bitcask.WithReadWrite()}, &db.Options{
+		BloomFilterSize: 10000,
+		BloomFilterFP:   0.01,
+	})
+	assert.NoError(t, err)
+	return kvdb
+}
+
+func TestDBPutWithTTL(t *testing.T) {
+	dir := t.TempDir()
+	kvdb := openDB(t, dir)
+
+	assert.NoError(t, kvdb.PutWithTTL([]byte("session"), []byte("token"), 50*time.Millisecond))
+	value, err := kvdb.Get([]byte("session"))
+	assert.NoError(t, err)
+	assert.Equal(t, "token", string(value))
+	ttl, err := kvdb.TTL([]byte("session"))
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = kvdb.Get([]byte("session"))
+	assert.Error(t, err)
+	assert.NoError(t, kvdb.Close())
+
+	kvdb = openDB(t, dir)
+	defer kvdb.Close()
+	_, err = kvdb.Get([]byte("session"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package db_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"FinnKV/internal/bitcask"
+	"FinnKV/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func countSegments(t *testing.T, dir string) int {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	assert.NoError(t, err)
+	return len(matches)
+}
+
+func TestWALSegmentRotation(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	wal, err := db.NewWAL(dir, db.WithMaxSegmentSize(256))
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		lsn, err := wal.Commit([]*bitcask.Entry{
+			{Type: bitcask.EntryTypeTxnBegin, TxnID: int64(i + 1)},
+			{Key: []byte(fmt.Sprintf("key%02d", i)), Value: make([]byte, 64), Type: bitcask.EntryTypePut, TxnID: int64(i + 1)},
+			{Type: bitcask.EntryTypeTxnEnd, TxnID: int64(i + 1)},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(i+1), lsn)
+	}
+	assert.Greater(t, countSegments(t, dir), 1)
+
+	entries, err := wal.ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 20)
+
+	// 检查点之前的段被删除，也不再被读取
+	segmentID, lastLSN, err := wal.Rotate()
+	assert.Equal(t, uint64(20), lastLSN)
+	assert.NoError(t, err)
+	assert.NoError(t, wal.Checkpoint(segmentID))
+	assert.Equal(t, 1, countSegments(t, dir))
+	entries, err = wal.ReadAll()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.NoError(t, wal.Close())
+
+	// 重新打开后从检查点继续
+	wal, err = db.NewWAL(dir)
+	assert.NoError(t, err)
+	defer wal.Close()
+	entries, err = wal.ReadAll()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.GreaterOrEqual(t, wal.SegmentID(), segmentID)
+}
+
+func TestWALMigratesLegacyFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	wal, err := db.NewWAL(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, wal.WriteBatch([]*bitcask.Entry{
+		{Key: []byte("a"), Value: []byte("1"), Type: bitcask.EntryTypePut},
+	}))
+	assert.NoError(t, wal.Close())
+
+	// 旧版本只有一个 wal.log 文件
+	matches, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	assert.NoError(t, err)
+	for _, match := range matches {
+		data, err := os.ReadFile(match)
+		assert.NoError(t, err)
+		if len(data) > 0 {
+			assert.NoError(t, os.Rename(match, filepath.Join(dir, "wal.log")))
+		} else {
+			assert.NoError(t, os.Remove(match))
+		}
+	}
+
+	wal, err = db.NewWAL(dir)
+	assert.NoError(t, err)
+	defer wal.Close()
+	entries, err := wal.ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	_, err = os.Stat(filepath.Join(dir, "wal.log"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDBCheckpointRemovesSegments(t *testing.T) {
+	dir := t.TempDir()
+	kvdb, err := db.Open(dir, []bitcask.Option{bitcask.WithReadWrite()}, &db.Options{
+		BloomFilterSize:    10000,
+		BloomFilterFP:      0.01,
+		MaxWALSegment:      512,
+		CheckpointInterval: -1,
+	})
+	assert.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		assert.NoError(t, kvdb.Put([]byte(fmt.Sprintf("key%02d", i)), make([]byte, 64)))
+	}
+	walDir := filepath.Join(dir, "wal")
+	assert.Greater(t, countSegments(t, walDir), 1)
+
+	assert.NoError(t, kvdb.Checkpoint())
+	assert.Equal(t, 1, countSegments(t, walDir))
+	assert.NoError(t, kvdb.Close())
+
+	kvdb = openDB(t, dir)
+	defer kvdb.Close()
+	for i := 0; i < 50; i++ {
+		_, err := kvdb.Get([]byte(fmt.Sprintf("key%02d", i)))
+		assert.NoError(t, err)
+	}
+}
+
+func TestDBCloseAfterFailedCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	kvdb := openDB(t, dir)
+	assert.NoError(t, kvdb.Put([]byte("a"), []byte("1")))
+
+	// 检查点文件的位置被非空目录占用，关闭时写入检查点失败
+	checkpoint := filepath.Join(dir, "wal", "CHECKPOINT")
+	assert.NoError(t, os.MkdirAll(filepath.Join(checkpoint, "blocked"), 0755))
+	assert.Error(t, kvdb.Close())
+
+	// 即使检查点失败，WAL 和底层存储也已经关闭，目录锁被释放，未完成检查点的记录在下次打开时重放
+	assert.NoError(t, os.RemoveAll(checkpoint))
+	kvdb = openDB(t, dir)
+	defer kvdb.Close()
+	value, err := kvdb.Get([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+}
+
+func TestWALFailsWhenRotationFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	wal, err := db.NewWAL(dir, db.WithMaxSegmentSize(64))
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	// 下一个段的位置被目录占用，切换段时关闭了活跃段却无法打开新段
+	next := filepath.Join(dir, fmt.Sprintf("%09d.wal", wal.SegmentID()+1))
+	assert.NoError(t, os.MkdirAll(next, 0755))
+
+	entries := []*bitcask.Entry{
+		{Type: bitcask.EntryTypeTxnBegin, TxnID: 1},
+		{Key: []byte("key"), Value: make([]byte, 64), Type: bitcask.EntryTypePut, TxnID: 1},
+		{Type: bitcask.EntryTypeTxnEnd, TxnID: 1},
+	}
+	// 这一组已经持久化，切换段失败不影响它的提交结果，之后的提交明确返回错误
+	_, err = wal.Commit(entries)
+	assert.NoError(t, err)
+	_, err = wal.Commit(entries)
+	assert.ErrorIs(t, err, db.ErrWALFailed)
+	_, _, err = wal.Rotate()
+	assert.ErrorIs(t, err, db.ErrWALFailed)
+	assert.NoError(t, wal.Close())
+}